@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"net/http"
+	"path"
 	"strconv"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+	"webapp/pkg/passwords"
+	"webapp/pkg/throttle"
+	"webapp/pkg/validate"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Credentials struct {
@@ -17,40 +22,185 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+// MFAChallenge is returned instead of a TokenPairs when the user has two-factor enabled.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// MFACredentials exchanges a challenge token and an authenticator or recovery code for a TokenPairs.
+type MFACredentials struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	creds := Credentials{}
 	//read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	//slow down repeated failures for this account or client
+	ip := app.clientIP(r)
+	if app.tooManyAttempts(w, r, creds.UserName, ip) {
 		return
 	}
 
 	//look up the user by email address
-	user, err := app.DB.GetUserByEmail(creds.UserName)
+	user, err := app.DB.GetUserByEmail(r.Context(), creds.UserName)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.loginFailed(r.Context(), "password", creds.UserName, ip)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
 	//check password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
-	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	valid, err := app.Hasher.Verify(user.Password, creds.Password)
+	if err != nil || !valid {
+		app.loginFailed(r.Context(), "password", creds.UserName, ip)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.rehashIfNeeded(r.Context(), user, creds.Password)
+
+	//users with two-factor enabled get a challenge rather than tokens
+	if user.TOTPEnabled {
+		mfaToken, err := app.generateMFAToken(user)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		app.Metrics.Auth.Record("password", true)
+		_ = app.writeJSON(w, http.StatusOK, MFAChallenge{MFARequired: true, MFAToken: mfaToken})
 		return
 	}
 
 	//generate tokens
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
+	app.loginSucceeded(r.Context(), "password", user.Email)
+
 	//send tokens to user
-	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+	app.sendTokens(w, tokenPairs)
+}
+
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	creds := MFACredentials{}
+	err := app.readJSON(w, r, &creds)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	//make sure the password step was completed
+	challenge, userID, err := app.verifyMFAToken(r.Context(), creds.MFAToken)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	ip := app.clientIP(r)
+	if app.tooManyAttempts(w, r, user.Email, ip) {
+		return
+	}
+
+	//check the code
+	if !app.verifyMFACode(r.Context(), user, creds.Code) {
+		app.loginFailed(r.Context(), "mfa", user.Email, ip)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// a challenge is good for one login
+	err = app.revokeToken(r.Context(), challenge)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokenPairs, err := app.generateTokenPair(user)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.loginSucceeded(r.Context(), "mfa", user.Email)
+
+	app.sendTokens(w, tokenPairs)
+}
+
+// tooManyAttempts responds with 429 and reports true when the account or IP is being throttled.
+func (app *application) tooManyAttempts(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	wait, err := app.Throttle.Allow(r.Context(), email, ip)
+	if err != nil {
+		// fail open, an unavailable store should not lock everybody out
+		slog.ErrorContext(r.Context(), "checking login throttle", "error", err)
+		return false
+	}
+
+	if wait <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", throttle.RetryAfter(wait))
+	app.errorJSON(w, r, &apiError{Status: http.StatusTooManyRequests, Code: codeTooManyAttempts, Message: "too many failed login attempts, try again later"})
+	return true
+}
+
+func (app *application) loginFailed(ctx context.Context, method, email, ip string) {
+	app.Metrics.Auth.Record(method, false)
+	if err := app.Throttle.Failure(ctx, email, ip); err != nil {
+		slog.ErrorContext(ctx, "recording failed login", "error", err)
+	}
+}
+
+func (app *application) loginSucceeded(ctx context.Context, method, email string) {
+	app.Metrics.Auth.Record(method, true)
+
+	if err := app.Throttle.Success(ctx, email); err != nil {
+		slog.ErrorContext(ctx, "clearing failed logins", "error", err)
+	}
+}
+
+// rehashIfNeeded replaces the stored hash of a password that has just been checked if it
+// was made with a lower cost or an older algorithm than the one configured now.
+func (app *application) rehashIfNeeded(ctx context.Context, user *data.User, password string) {
+	if !app.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	if err := app.DB.RehashPassword(ctx, user.ID, user.Password, password); err != nil {
+		slog.ErrorContext(ctx, "rehashing password", "error", err)
+	}
 }
 
+// verifyMFACode checks code with mfa.Verify, logging errors from the database.
+func (app *application) verifyMFACode(ctx context.Context, user *data.User, code string) bool {
+	ok, err := mfa.Verify(ctx, app.DB, user, code, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "verifying mfa code", "error", err)
+		return false
+	}
+
+	return ok
+}
+
+// refresh exchanges the refresh token posted in the refresh_token field for a new pair.
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -58,63 +208,150 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refreshToken := r.Form.Get("refresh_token")
+	app.rotateRefreshToken(w, r, r.Form.Get("refresh_token"))
+}
 
-	claims := &Claims{}
+// refreshFromCookie is refresh for browser clients, which keep the refresh token in the
+// HttpOnly cookie. The cookie is sent along with any request to us, so the CSRF token
+// from the last response must come back in the X-CSRF-Token header as well.
+func (app *application) refreshFromCookie(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(app.refreshCookieName())
+	if err != nil || c.Value == "" {
+		app.errorJSON(w, r, errors.New("no refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	if !app.validRefreshCSRF(c.Value, r.Header.Get("X-CSRF-Token")) {
+		app.errorJSON(w, r, errors.New("invalid csrf token"), http.StatusForbidden)
+		return
+	}
 
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (any, error) {
-		return []byte(app.JWTSecret), nil
-	})
+	app.rotateRefreshToken(w, r, c.Value)
+}
 
+// rotateRefreshToken issues a new pair for refreshToken and revokes it, so every refresh
+// token can only be used once.
+func (app *application) rotateRefreshToken(w http.ResponseWriter, r *http.Request, refreshToken string) {
+	claims, err := app.parseToken(refreshToken)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("invalid refresh token"), http.StatusBadRequest)
 		return
 	}
 
-	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+	if !claims.VerifyAudience(refreshAudience, true) {
+		app.errorJSON(w, r, errors.New("invalid refresh token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.checkNotRevoked(r.Context(), claims)
+	if errors.Is(err, errTokenRevoked) {
+		app.errorJSON(w, r, errors.New("refresh token has been revoked"), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Message: "cannot check the refresh token", Err: err})
 		return
 	}
 
 	userID, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("invalid refresh token"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("unknown user"), http.StatusBadRequest)
+		return
+	}
+
+	// a password reset revokes refresh tokens issued before it
+	if issuedBeforePasswordChange(claims, user) {
+		app.errorJSON(w, r, errors.New("refresh token has been revoked"), http.StatusUnauthorized)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	err = app.revokeToken(r.Context(), claims)
 	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("refresh token cannot be rotated"), http.StatusUnauthorized)
 		return
 	}
 
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	// make refresh token available for web apps
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost",
-		HttpOnly: true,
-		Secure:   true,
-	})
+	app.sendTokens(w, tokenPairs)
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// logout revokes the access token in the Authorization header and the refresh token in
+// the body or cookie, so neither can be used again, and clears the refresh cookie. The
+// access token may already have expired, as long as a valid refresh token is given.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	// the cookie goes whatever else happens
+	http.SetCookie(w, app.refreshCookie("", 0))
+
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &req)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.RefreshToken == "" {
+		if c, err := r.Cookie(app.refreshCookieName()); err == nil {
+			req.RefreshToken = c.Value
+		}
+	}
+
+	var revoke []*Claims
+
+	if r.Header.Get("Authorization") != "" {
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
+		if err == nil {
+			revoke = append(revoke, claims)
+		}
+	}
+
+	if req.RefreshToken != "" {
+		claims, err := app.parseToken(req.RefreshToken)
+		if err == nil && claims.VerifyAudience(refreshAudience, true) {
+			if len(revoke) > 0 && revoke[0].Subject != claims.Subject {
+				app.errorJSON(w, r, errors.New("tokens belong to different users"), http.StatusBadRequest)
+				return
+			}
+			revoke = append(revoke, claims)
+		}
+	}
+
+	if len(revoke) == 0 {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
 
-	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+	for _, claims := range revoke {
+		err := app.revokeToken(r.Context(), claims)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
 
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	users, err := app.DB.AllUsers(r.Context())
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 	_ = app.writeJSON(w, http.StatusOK, users)
@@ -123,13 +360,13 @@ func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidUserID)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("unknown user"), http.StatusBadRequest)
 		return
 	}
 
@@ -137,50 +374,266 @@ func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// UserDetails are the parts of a user that can be set through the api. Passwords are only
+// set when the user is created, and changed by resetting them.
+type UserDetails struct {
+	FirstName string `json:"first_name" validate:"required,max=255"`
+	LastName  string `json:"last_name" validate:"required,max=255"`
+	Email     string `json:"email" validate:"required,email,max=255"`
+	IsAdmin   int    `json:"is_admin" validate:"oneof=0 1"`
+}
+
+func detailsOf(u *data.User) UserDetails {
+	return UserDetails{FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, IsAdmin: u.IsAdmin}
+}
+
+// apply sets the details of u, leaving everything else as it is.
+func (d UserDetails) apply(u *data.User) {
+	u.FirstName, u.LastName, u.Email, u.IsAdmin = d.FirstName, d.LastName, d.Email, d.IsAdmin
+}
+
+// UpdateUserRequest is the body of the deprecated PATCH /users/, which takes the id from
+// the body rather than the path.
+type UpdateUserRequest struct {
+	ID int `json:"id" validate:"required,min=1"`
+	UserDetails
+}
+
+// updateUser serves the deprecated PATCH /users/; see replaceUser and patchUser.
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var req UpdateUserRequest
+	if !app.readRequest(w, r, &req) {
+		return
+	}
+
+	existing, err := app.DB.GetUser(r.Context(), req.ID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("user could not be updated"), http.StatusBadRequest)
 		return
 	}
+	if !app.mayChangeUser(w, r, existing, req.UserDetails) {
+		return
+	}
+
+	user := data.User{ID: req.ID}
+	req.apply(&user)
 
-	err = app.DB.UpdateUser(user)
+	err = app.DB.UpdateUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "updating user", "error", err)
+		app.errorJSON(w, r, errors.New("user could not be updated"), http.StatusBadRequest)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// userFromPath looks up the user named by the {userID} path parameter, writing the error
+// response if there is none.
+func (app *application) userFromPath(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidUserID)
+		return nil, false
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("no such user"), http.StatusNotFound)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// replaceUser sets all of a user's details, answering with the updated user.
+func (app *application) replaceUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req UserDetails
+	if !app.readRequest(w, r, &req) {
+		return
+	}
+
+	app.saveUser(w, r, user, req)
+}
+
+// patchUser changes some of a user's details, following JSON Merge Patch (RFC 7396): the
+// members of the body replace those of the user, and null ones remove them, which is only
+// allowed where the details can be empty.
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var patch interface{}
+	if err := app.readJSON(w, r, &patch); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		app.errorJSON(w, r, &apiError{Status: http.StatusBadRequest, Code: codeInvalidType, Message: "body must be a JSON object"})
+		return
+	}
+
+	var req UserDetails
+	if err := mergePatch(detailsOf(user), patch, &req); err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+	if fields := validate.Struct(req); fields != nil {
+		app.validationErrorJSON(w, r, fields)
+		return
+	}
+
+	app.saveUser(w, r, user, req)
+}
+
+// mayChangeUser checks that the caller may give user details, writing the error response
+// if not. Administrators may change anyone; everyone else only themselves, and never
+// whether they are an administrator.
+func (app *application) mayChangeUser(w http.ResponseWriter, r *http.Request, user *data.User, details UserDetails) bool {
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return false
+	}
+	if p.Admin {
+		return true
+	}
+
+	if p.UserID != user.ID {
+		app.errorJSON(w, r, errors.New("you can only change your own details"), http.StatusForbidden)
+		return false
+	}
+	if details.IsAdmin != user.IsAdmin {
+		app.errorJSON(w, r, errors.New("only administrators can change is_admin"), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// saveUser stores details for user and answers with the result.
+func (app *application) saveUser(w http.ResponseWriter, r *http.Request, user *data.User, details UserDetails) {
+	if !app.mayChangeUser(w, r, user, details) {
+		return
+	}
+
+	details.apply(user)
+
+	err := app.DB.UpdateUser(r.Context(), *user)
+	if err != nil {
+		slog.WarnContext(r.Context(), "updating user", "error", err)
+		app.errorJSON(w, r, errors.New("user could not be updated"), http.StatusBadRequest)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidUserID)
 		return
 	}
 
-	err = app.DB.DeleteUser(userID)
+	err = app.DB.DeleteUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "deleting user", "error", err)
+		app.errorJSON(w, r, errors.New("user could not be deleted"), http.StatusBadRequest)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// NewUserRequest is the body of a request to create a user. Unlike data.User, it carries
+// the password, which is checked against the password policy too.
+type NewUserRequest struct {
+	UserDetails
+	Password string `json:"password" validate:"required"`
+}
+
+// createUser adds a user, answering 201 with the new user and its URL in Location.
+func (app *application) createUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.addUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, strconv.Itoa(user.ID)))
+	_ = app.writeJSON(w, http.StatusCreated, user)
+}
+
+// insertUser serves the deprecated PUT /users/, which answers without the new user.
 func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	if _, ok := app.addUser(w, r); !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addUser creates a user from the request body, writing the error response if it cannot.
+func (app *application) addUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	var req NewUserRequest
+	if !app.readRequest(w, r, &req) {
+		return nil, false
+	}
+
+	p, ok := principalFromContext(r.Context())
+	admin := ok && p.Admin
+	if req.IsAdmin != 0 && !admin {
+		app.errorJSON(w, r, errors.New("only administrators can create administrators"), http.StatusForbidden)
+		return nil, false
+	}
+
+	if !app.validatePassword(w, r, req.Password, passwords.Owner{Email: req.Email, FirstName: req.FirstName, LastName: req.LastName}) {
+		return nil, false
+	}
+
+	user := data.User{Password: req.Password}
+	req.apply(&user)
+
+	// users created by an administrator do not need to verify their address; anyone else
+	// could use this to get around verification
+	if admin {
+		user.EmailVerifiedAt = time.Now()
+	}
+
+	var err error
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		slog.WarnContext(r.Context(), "inserting user", "error", err)
+		app.errorJSON(w, r, errors.New("user could not be created"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &user, true
+}
+
+func (app *application) unlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidUserID)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("unknown user"), http.StatusBadRequest)
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	err = app.Throttle.Unlock(r.Context(), user.Email)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
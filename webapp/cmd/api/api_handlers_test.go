@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,8 +13,13 @@ import (
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/throttle"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 var authTests = []struct {
@@ -25,6 +33,7 @@ var authTests = []struct {
 	{"empty email", `{"email":""}`, http.StatusUnauthorized},
 	{"empty password", `{"email":"admin@example.com"}`, http.StatusUnauthorized},
 	{"invalid user", `{"email":"admin@nothere.com","password":"secret"}`, http.StatusUnauthorized},
+	{"unverified user", `{"email":"unverified@example.com","password":"secret"}`, http.StatusOK},
 }
 
 func TestApi_authenticate(t *testing.T) {
@@ -47,18 +56,164 @@ func TestApi_authenticate(t *testing.T) {
 	}
 }
 
-func TestApi_refresh(t *testing.T) {
+func TestApi_authenticateRehash(t *testing.T) {
+	oldDB, oldHasher := app.DB, app.Hasher
+	defer func() { app.DB, app.Hasher = oldDB, oldHasher }()
+
+	// the test users' hashes have cost 14
+	tests := []struct {
+		name           string
+		cost           int
+		expectedRehash bool
+	}{
+		{"same cost", 14, false},
+		{"higher cost", 15, true},
+	}
+
+	for _, e := range tests {
+		db := &dbrepo.TestDBRepo{}
+		app.DB = db
+		app.Hasher = passwords.NewBcrypt(e.cost)
+
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"admin@example.com","password":"secret"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 but got %d", e.name, rr.Code)
+		}
+
+		if rehashed := len(db.Rehashed) == 1 && db.Rehashed[0] == 1; rehashed != e.expectedRehash {
+			t.Errorf("%s: expected rehash %v but got %v", e.name, e.expectedRehash, db.Rehashed)
+		}
+	}
+}
+
+func TestApi_authenticateThrottled(t *testing.T) {
+	oldThrottle := app.Throttle
+	defer func() { app.Throttle = oldThrottle }()
+
+	app.Throttle = throttle.New(throttle.NewMemoryStore())
+	app.Throttle.Account.FreeAttempts = 1
+
+	tests := []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"first failure", `{"email":"admin@example.com","password":"wrong"}`, http.StatusUnauthorized},
+		{"second failure", `{"email":"admin@example.com","password":"wrong"}`, http.StatusUnauthorized},
+		{"backing off", `{"email":"admin@example.com","password":"secret"}`, http.StatusTooManyRequests},
+		{"other account", `{"email":"mfa@example.com","password":"wrong"}`, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedStatusCode == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+}
+
+func TestApi_unlockUser(t *testing.T) {
+	oldThrottle := app.Throttle
+	defer func() { app.Throttle = oldThrottle }()
+
+	app.Throttle = throttle.New(throttle.NewMemoryStore())
+	for i := 0; i < app.Throttle.Account.MaxFailures; i++ {
+		_ = app.Throttle.Failure(context.Background(), "admin@example.com", "")
+	}
+
+	tests := []struct {
+		name           string
+		paramID        string
+		expectedStatus int
+	}{
+		{"bad url param", "XD", http.StatusBadRequest},
+		{"unknown user", "2", http.StatusBadRequest},
+		{"valid user", "1", http.StatusNoContent},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("DELETE", "/", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.unlockUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned, expected %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+
+	if wait, _ := app.Throttle.Allow(context.Background(), "admin@example.com", ""); wait != 0 {
+		t.Errorf("account still locked after unlock, wait %s", wait)
+	}
+}
+
+// mfaChallenge logs in as mfa@example.com, which should hand back a challenge rather than tokens.
+func mfaChallenge(t *testing.T) string {
+	req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"mfa@example.com","password":"secret"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+	var challenge MFAChallenge
+	if err := json.NewDecoder(rr.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatal("expected an mfa challenge from authenticate")
+	}
+
+	return challenge.MFAToken
+}
+
+func TestApi_authenticateMFA(t *testing.T) {
+	challenge := mfaChallenge(t)
+	code, _ := mfa.GenerateCode(dbrepo.TestTOTPSecret, time.Now())
+	adminTokens, _ := app.generateTokenPair(&data.User{ID: 3})
+
 	tests := []struct {
 		name               string
 		token              string
+		code               string
 		expectedStatusCode int
-		resetRefreshTime   bool
 	}{
-		{"valid", "", http.StatusOK, true},
-		{"too early", "", http.StatusTooEarly, false},
-		{"expired token", expiredToken, http.StatusBadRequest, false},
+		{"wrong code", challenge, "000000", http.StatusUnauthorized},
+		{"valid code", challenge, code, http.StatusOK},
+		{"used challenge", challenge, dbrepo.TestRecoveryCode, http.StatusUnauthorized},
+		{"recovery code", mfaChallenge(t), dbrepo.TestRecoveryCode, http.StatusOK},
+		{"replayed code", mfaChallenge(t), code, http.StatusUnauthorized},
+		{"missing token", "", code, http.StatusUnauthorized},
+		{"access token", adminTokens.Token, code, http.StatusUnauthorized},
+		{"refresh token", adminTokens.RefreshToken, code, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		body, _ := json.Marshal(MFACredentials{MFAToken: e.token, Code: e.code})
+		req, _ := http.NewRequest("POST", "/auth/mfa", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.authenticateMFA).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
 	}
+}
 
+func TestApi_refresh(t *testing.T) {
 	testUser := data.User{
 		ID:        1,
 		FirstName: "Admin",
@@ -66,22 +221,24 @@ func TestApi_refresh(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	oldRefreshTime := refreshTokenExpiry
+	tokens, _ := app.generateTokenPair(&testUser)
+	mfaToken, _ := app.generateMFAToken(&testUser)
 
-	for _, e := range tests {
-		var tkn string
-		if e.token == "" {
-			if e.resetRefreshTime {
-				refreshTokenExpiry = time.Second * 1
-			}
-			tokens, _ := app.generateTokenPair(&testUser)
-			tkn = tokens.RefreshToken
-		} else {
-			tkn = e.token
-		}
+	tests := []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"access token", tokens.Token, http.StatusBadRequest},
+		{"mfa token", mfaToken, http.StatusBadRequest},
+		{"valid", tokens.RefreshToken, http.StatusOK},
+		{"reused", tokens.RefreshToken, http.StatusUnauthorized},
+		{"expired token", expiredToken, http.StatusBadRequest},
+	}
 
+	for _, e := range tests {
 		postedData := url.Values{
-			"refresh_token": {tkn},
+			"refresh_token": {e.token},
 		}
 
 		req := httptest.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
@@ -94,7 +251,172 @@ func TestApi_refresh(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
-		refreshTokenExpiry = oldRefreshTime
+	}
+}
+
+// brokenRevocations is a revocation store that cannot be reached.
+type brokenRevocations struct{}
+
+func (brokenRevocations) RevokeToken(context.Context, string, time.Time) error {
+	return errors.New("revocation store is down")
+}
+
+func (brokenRevocations) IsTokenRevoked(context.Context, string) (bool, error) {
+	return false, errors.New("revocation store is down")
+}
+
+func TestApi_refreshRevocationErrors(t *testing.T) {
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1})
+
+	refresh := func() *httptest.ResponseRecorder {
+		postedData := url.Values{"refresh_token": {tokens.RefreshToken}}
+		req := httptest.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.refresh).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the store's error is logged, never sent
+	store := app.Revoked
+	app.Revoked = brokenRevocations{}
+	rr := refresh()
+	app.Revoked = store
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 but got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "revocation store is down") {
+		t.Errorf("the store's error was sent to the client: %s", rr.Body.String())
+	}
+
+	if rr := refresh(); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+
+	rr = refresh()
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a used refresh token to be refused with 401 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "refresh token has been revoked") {
+		t.Errorf("unexpected body %s", rr.Body.String())
+	}
+}
+
+func TestApi_logout(t *testing.T) {
+	admin := &data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	other := &data.User{ID: 3, FirstName: "MFA", LastName: "User", Email: "mfa@example.com"}
+
+	tests := []struct {
+		name           string
+		access         bool
+		body           bool
+		cookie         bool
+		otherRefresh   bool
+		accessRefresh  bool
+		expectedStatus int
+	}{
+		{"access and refresh", true, true, false, false, false, http.StatusNoContent},
+		{"refresh cookie only", false, false, true, false, false, http.StatusNoContent},
+		{"access only", true, false, false, false, false, http.StatusNoContent},
+		{"nothing", false, false, false, false, false, http.StatusUnauthorized},
+		{"someone else's refresh token", true, true, false, true, false, http.StatusBadRequest},
+		{"access token as refresh token", false, true, false, false, true, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		tokens, _ := app.generateTokenPair(admin)
+		refreshToken := tokens.RefreshToken
+		if e.otherRefresh {
+			otherTokens, _ := app.generateTokenPair(other)
+			refreshToken = otherTokens.RefreshToken
+		}
+		if e.accessRefresh {
+			refreshToken = tokens.Token
+		}
+
+		var body io.Reader = http.NoBody
+		if e.body {
+			body = strings.NewReader(`{"refresh_token":"` + refreshToken + `"}`)
+		}
+		req := httptest.NewRequest("POST", "/logout", body)
+		if e.access {
+			req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		}
+		if e.cookie {
+			req.AddCookie(&http.Cookie{Name: app.refreshCookieName(), Value: refreshToken})
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.logout).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if !strings.Contains(rr.Header().Get("Set-Cookie"), app.refreshCookieName()+"=;") {
+			t.Errorf("%s: expected the refresh cookie to be cleared", e.name)
+		}
+
+		if rr.Code != http.StatusNoContent {
+			continue
+		}
+
+		// the revoked tokens no longer work
+		if e.access {
+			req = httptest.NewRequest("GET", "/users/", nil)
+			req.Header.Set("Authorization", "Bearer "+tokens.Token)
+			rr = httptest.NewRecorder()
+			app.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s: expected revoked access token to be refused but got %d", e.name, rr.Code)
+			}
+		}
+
+		if e.body || e.cookie {
+			postedData := url.Values{"refresh_token": {refreshToken}}
+			req = httptest.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr = httptest.NewRecorder()
+			http.HandlerFunc(app.refresh).ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s: expected revoked refresh token to be refused but got %d", e.name, rr.Code)
+			}
+		}
+	}
+}
+
+func TestApi_refreshAfterPasswordReset(t *testing.T) {
+	// mfa@example.com last reset their password an hour ago
+	stale := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "3",
+		"aud": refreshAudience,
+		"iat": time.Now().Add(-2 * time.Hour).Unix(),
+		"exp": time.Now().Add(time.Second).Unix(),
+	})
+	staleToken, _ := stale.SignedString([]byte(app.JWTSecret))
+	fresh, _ := app.generateTokenPair(&data.User{ID: 3})
+
+	tests := []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"issued before reset", staleToken, http.StatusUnauthorized},
+		{"issued after reset", fresh.RefreshToken, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"refresh_token": {e.token}}
+		req := httptest.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.refresh).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
 	}
 }
 
@@ -129,6 +451,22 @@ func TestApi_userEndpoints(t *testing.T) {
 			app.updateUser,
 			http.StatusBadRequest,
 		},
+		{
+			"update user without id",
+			"PATCH",
+			`{"first_name": "Administrator", "last_name":"User", "email":"admin@example.com"}`,
+			"",
+			app.updateUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"update user with empty email",
+			"PATCH",
+			`{"id":1, "first_name": "Administrator", "last_name":"User", "email":""}`,
+			"",
+			app.updateUser,
+			http.StatusUnprocessableEntity,
+		},
 		{
 			"update invalid json",
 			"PATCH",
@@ -140,11 +478,35 @@ func TestApi_userEndpoints(t *testing.T) {
 		{
 			"insert valid user",
 			"PUT",
-			`{"first_name": "Jack", "last_name":"Smith", "email":"jack@example.com"}`,
+			`{"first_name": "Jack", "last_name":"Smith", "email":"jack@example.com", "password":"Correct-Horse-9"}`,
 			"",
 			app.insertUser,
 			http.StatusNoContent,
 		},
+		{
+			"insert user without password",
+			"PUT",
+			`{"first_name": "Jack", "last_name":"Smith", "email":"jack@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insert user with breached password",
+			"PUT",
+			`{"first_name": "Jack", "last_name":"Smith", "email":"jack@example.com", "password":"Password123"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insert user with bad email",
+			"PUT",
+			`{"first_name": "Jack", "last_name":"Smith", "email":"jack", "password":"Correct-Horse-9"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
 		{
 			"insert invalid user",
 			"PUT",
@@ -177,6 +539,9 @@ func TestApi_userEndpoints(t *testing.T) {
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
 		}
 
+		// as authRequired would have it, for an administrator
+		req = req.WithContext(context.WithValue(req.Context(), contextPrincipalKey, &principal{UserID: 1, Admin: true}))
+
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(e.handler)
 		handler.ServeHTTP(rr, req)
@@ -186,3 +551,28 @@ func TestApi_userEndpoints(t *testing.T) {
 		}
 	}
 }
+
+func TestApi_insertUserValidation(t *testing.T) {
+	body := `{"first_name": "", "last_name":"Smith", "email":"jack", "password":"Correct-Horse-9", "is_admin": 2}`
+	req := httptest.NewRequest("PUT", "/", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	app.insertUser(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	var resp struct {
+		Error errorBody `json:"error"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	for _, field := range []string{"first_name", "email", "is_admin"} {
+		if len(resp.Error.Fields[field]) == 0 {
+			t.Errorf("expected a problem with %s, got %v", field, resp.Error.Fields)
+		}
+	}
+	if _, ok := resp.Error.Fields["last_name"]; ok {
+		t.Error("did not expect a problem with last_name")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"webapp/pkg/apikey"
+	"webapp/pkg/data"
+	"webapp/pkg/validate"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type contextKey string
+
+const contextPrincipalKey contextKey = "principal"
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// principal is who a request was authenticated as, by access token or by api key.
+type principal struct {
+	UserID int
+	Admin  bool
+	// APIKey is set when the request was made with an api key.
+	APIKey *data.APIKey
+}
+
+// can reports whether the principal may act with scope. Access tokens may do anything
+// their user may; api keys are limited to the scopes they were created with.
+func (p *principal) can(scope string) bool {
+	if p.APIKey == nil {
+		return true
+	}
+	return p.APIKey.HasScope(scope)
+}
+
+// scopeFor returns the scope needed to make a request with method.
+func scopeFor(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return apikey.ScopeRead
+	default:
+		return apikey.ScopeWrite
+	}
+}
+
+func principalFromContext(ctx context.Context) (*principal, bool) {
+	p, ok := ctx.Value(contextPrincipalKey).(*principal)
+	return p, ok
+}
+
+// authenticateRequest checks the X-API-Key header if there is one, and otherwise the
+// bearer token in the Authorization header.
+func (app *application) authenticateRequest(w http.ResponseWriter, r *http.Request) (*principal, error) {
+	w.Header().Add("Vary", "X-API-Key")
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		p, err := app.principalFromAPIKey(r.Context(), key)
+		app.Metrics.Auth.Record("api_key", err == nil)
+		return p, err
+	}
+
+	_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	// a password reset revokes access tokens issued before it
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if issuedBeforePasswordChange(claims, user) {
+		return nil, errTokenRevoked
+	}
+
+	return &principal{UserID: userID, Admin: claims.Admin}, nil
+}
+
+// principalFromAPIKey resolves a key to its owner. Admin rights come from the owner as
+// they are now, so demoting a user also demotes their keys.
+func (app *application) principalFromAPIKey(ctx context.Context, key string) (*principal, error) {
+	prefix, err := apikey.Prefix(key)
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+
+	k, err := app.DB.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil || !k.RevokedAt.IsZero() || !apikey.Matches(key, k.Hash) {
+		return nil, errInvalidAPIKey
+	}
+
+	user, err := app.DB.GetUser(ctx, k.UserID)
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+
+	if err := app.DB.TouchAPIKey(ctx, k.ID); err != nil {
+		slog.ErrorContext(ctx, "touching api key", "error", err)
+	}
+
+	return &principal{UserID: user.ID, Admin: user.IsAdmin == 1 && k.HasScope(apikey.ScopeAdmin), APIKey: k}, nil
+}
+
+type NewAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required"`
+}
+
+// NewAPIKeyResponse is the only time the full key is shown.
+type NewAPIKeyResponse struct {
+	data.APIKey
+	Key string `json:"key"`
+}
+
+// createAPIKey issues a key for the caller. Keys cannot be used to create more keys, so a
+// leaked key cannot be used to keep access after it is revoked.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFromContext(r.Context())
+	if p == nil || p.APIKey != nil {
+		app.errorJSON(w, r, errors.New("api keys can only be created with an access token"), http.StatusForbidden)
+		return
+	}
+
+	var req NewAPIKeyRequest
+	if !app.readRequest(w, r, &req) {
+		return
+	}
+
+	fields := make(validate.Errors)
+	for _, scope := range req.Scopes {
+		if !apikey.ValidScope(scope) {
+			fields.Add("scopes", fmt.Sprintf("Unknown scope %q", scope))
+		}
+	}
+	if len(fields) > 0 {
+		app.validationErrorJSON(w, r, fields)
+		return
+	}
+
+	key, prefix, hash, err := apikey.Generate()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	k := data.APIKey{
+		UserID: p.UserID,
+		Name:   req.Name,
+		Prefix: prefix,
+		Hash:   hash,
+		Scopes: req.Scopes,
+	}
+
+	k.ID, err = app.DB.InsertAPIKey(r.Context(), k)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, NewAPIKeyResponse{APIKey: k, Key: key})
+}
+
+func (app *application) allAPIKeys(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFromContext(r.Context())
+	if p == nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := app.DB.AllAPIKeys(r.Context(), p.UserID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if keys == nil {
+		keys = []*data.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFromContext(r.Context())
+	if p == nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid api key id"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(r.Context(), p.UserID, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, r, errors.New("api key not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
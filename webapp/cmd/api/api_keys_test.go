@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/apikey"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestApi_createAPIKey(t *testing.T) {
+	tokenUser := &principal{UserID: 1, Admin: true}
+	keyUser := &principal{UserID: 1, APIKey: &data.APIKey{ID: 2, Scopes: []string{apikey.ScopeWrite}}}
+
+	tests := []struct {
+		name           string
+		principal      *principal
+		requestBody    string
+		expectedStatus int
+	}{
+		{"valid", tokenUser, `{"name":"backup script","scopes":["read"]}`, http.StatusCreated},
+		{"with api key", keyUser, `{"name":"backup script","scopes":["read"]}`, http.StatusForbidden},
+		{"no name", tokenUser, `{"scopes":["read"]}`, http.StatusUnprocessableEntity},
+		{"no scopes", tokenUser, `{"name":"backup script"}`, http.StatusUnprocessableEntity},
+		{"unknown scope", tokenUser, `{"name":"backup script","scopes":["root"]}`, http.StatusUnprocessableEntity},
+		{"bad json", tokenUser, `{"name":}`, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/api-keys", strings.NewReader(e.requestBody))
+		req = req.WithContext(context.WithValue(req.Context(), contextPrincipalKey, e.principal))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.createAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Code == http.StatusCreated {
+			var resp NewAPIKeyResponse
+			_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+			prefix, err := apikey.Prefix(resp.Key)
+			if err != nil || prefix != resp.Prefix {
+				t.Errorf("%s: expected a key starting with %s but got %s", e.name, resp.Prefix, resp.Key)
+			}
+			if strings.Contains(rr.Body.String(), apikey.Hash(resp.Key)) {
+				t.Errorf("%s: hash should not be returned", e.name)
+			}
+		}
+	}
+}
+
+func TestApi_allAPIKeys(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api-keys", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextPrincipalKey, &principal{UserID: 1}))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.allAPIKeys).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+
+	var keys []data.APIKey
+	_ = json.NewDecoder(rr.Body).Decode(&keys)
+
+	// the revoked key is left out
+	if len(keys) != 2 {
+		t.Errorf("expected 2 keys but got %d", len(keys))
+	}
+}
+
+func TestApi_revokeAPIKey(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         int
+		keyID          string
+		expectedStatus int
+	}{
+		{"valid", 1, "1", http.StatusNoContent},
+		{"already revoked", 1, "3", http.StatusNotFound},
+		{"someone else's key", 2, "1", http.StatusNotFound},
+		{"bad id", 1, "abc", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("DELETE", "/api-keys/"+e.keyID, nil)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("keyID", e.keyID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+		ctx = context.WithValue(ctx, contextPrincipalKey, &principal{UserID: e.userID})
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.revokeAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
@@ -1,30 +1,99 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/logging"
+	"webapp/pkg/tracing"
+)
 
+// enableCORS applies the configured CORS policy.
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	return app.CORS.Handler(next)
+}
 
+// authRequired accepts either a bearer access token or an X-API-Key header, and stores
+// who the request was made by in its context. Api keys must have the scope for the method.
+func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8090")
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+		p, err := app.authenticateRequest(w, r)
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+		recordUser(r, p.UserID)
+		if !p.can(scopeFor(r.Method)) {
+			app.errorJSON(w, r, errors.New("api key does not have the scope for this request"), http.StatusForbidden)
 			return
-		} else {
-			next.ServeHTTP(w, r)
 		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextPrincipalKey, p)))
 	})
-
 }
 
-func (app *application) authRequired(next http.Handler) http.Handler {
+func (app *application) adminRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		p, ok := principalFromContext(r.Context())
+		if !ok {
+			var err error
+			p, err = app.authenticateRequest(w, r)
+			if err != nil {
+				app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+			recordUser(r, p.UserID)
+		}
+		if !p.Admin {
+			app.errorJSON(w, r, errors.New("administrators only"), http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// deprecation says when routes stopped being recommended, and when they will be removed.
+type deprecation struct {
+	Since  time.Time
+	Sunset time.Time
+}
+
+// usersDeprecation covers PUT /users/ and PATCH /users/, which took the user's id in the
+// body and are replaced by POST /users and PUT and PATCH /users/{userID}.
+var usersDeprecation = deprecation{
+	Since:  time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+	Sunset: time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC),
+}
+
+// announce adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and a link to
+// successor, what replaces the deprecated route.
+func (d deprecation) announce(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	w.Header().Set("Sunset", d.Sunset.Format(http.TimeFormat))
+	w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+}
+
+// deprecated marks responses from a deprecated route, linking to successor within the same
+// version of the api, and logs each use so that the remaining callers can be found before
+// the route goes.
+func deprecated(d deprecation, successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			link := successor
+			if v := apiVersionFromContext(r.Context()); v != "" {
+				link = "/" + v + successor
+			}
+			d.announce(w, link)
+			slog.InfoContext(r.Context(), "deprecated route used", "method", r.Method, "path", r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// recordUser adds who made the request to its log records and span.
+func recordUser(r *http.Request, userID int) {
+	logging.SetUserID(r.Context(), userID)
+	tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr{Key: "enduser.id", Value: userID})
+}
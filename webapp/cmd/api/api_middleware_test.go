@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/logging"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/tracing"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func TestMiddleware_enableCORS(t *testing.T) {
@@ -14,16 +22,23 @@ func TestMiddleware_enableCORS(t *testing.T) {
 	tests := []struct {
 		name         string
 		method       string
+		origin       string
 		expectHeader bool
 	}{
-		{"preflight", "OPTIONS", true},
-		{"get", "GET", false},
+		{"preflight", "OPTIONS", "http://localhost:8090", true},
+		{"get", "GET", "http://localhost:8090", true},
+		{"preflight from unknown origin", "OPTIONS", "http://evil.example", false},
+		{"get from unknown origin", "GET", "http://evil.example", false},
 	}
 
 	for _, e := range tests {
 		handlerToTest := app.enableCORS(nextHandler)
 
 		req := httptest.NewRequest(e.method, "http://testing", nil)
+		req.Header.Set("Origin", e.origin)
+		if e.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
 		rr := httptest.NewRecorder()
 
 		handlerToTest.ServeHTTP(rr, req)
@@ -50,6 +65,17 @@ func TestMiddleware_authRequired(t *testing.T) {
 
 	tokens, _ := app.generateTokenPair(&testUser)
 
+	// mfa@example.com last reset their password an hour ago
+	stale := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "3",
+		"aud": app.Domain,
+		"iss": app.Domain,
+		"iat": time.Now().Add(-2 * time.Hour).Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	staleToken, _ := stale.SignedString([]byte(app.JWTSecret))
+	fresh, _ := app.generateTokenPair(&data.User{ID: 3})
+
 	var tests = []struct {
 		name               string
 		token              string
@@ -59,6 +85,8 @@ func TestMiddleware_authRequired(t *testing.T) {
 		{"valid token", fmt.Sprintf("Bearer %s", tokens.Token), true, true},
 		{"no token", "", false, false},
 		{"invalid token", fmt.Sprintf("Bearer %s", expiredToken), false, true},
+		{"issued before password reset", fmt.Sprintf("Bearer %s", staleToken), false, true},
+		{"issued after password reset", fmt.Sprintf("Bearer %s", fresh.Token), true, true},
 	}
 
 	for _, e := range tests {
@@ -83,3 +111,175 @@ func TestMiddleware_authRequired(t *testing.T) {
 	}
 
 }
+
+func TestMiddleware_adminRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	adminTokens, _ := app.generateTokenPair(&data.User{ID: 1, IsAdmin: 1})
+	userTokens, _ := app.generateTokenPair(&data.User{ID: 3, IsAdmin: 0})
+
+	var tests = []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"admin", "Bearer " + adminTokens.Token, http.StatusOK},
+		{"not admin", "Bearer " + userTokens.Token, http.StatusForbidden},
+		{"no token", "", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("DELETE", "/", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", e.token)
+		}
+
+		rr := httptest.NewRecorder()
+		app.adminRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestMiddleware_authRequiredAPIKey(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := principalFromContext(r.Context()); !ok {
+			t.Error("no principal in context")
+		}
+	})
+
+	var tests = []struct {
+		name           string
+		key            string
+		method         string
+		expectedStatus int
+	}{
+		{"read key get", dbrepo.TestReadAPIKey, "GET", http.StatusOK},
+		{"read key delete", dbrepo.TestReadAPIKey, "DELETE", http.StatusForbidden},
+		{"write key delete", dbrepo.TestAdminAPIKey, "DELETE", http.StatusOK},
+		{"revoked key", dbrepo.TestRevokedAPIKey, "GET", http.StatusUnauthorized},
+		{"unknown key", "wa_0000000f_00000000000000000000000000000000000000000000000f", "GET", http.StatusUnauthorized},
+		{"wrong secret", "wa_00000001_00000000000000000000000000000000000000000000000f", "GET", http.StatusUnauthorized},
+		{"malformed key", "nonsense", "GET", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		db := &dbrepo.TestDBRepo{}
+		app.DB = db
+
+		req := httptest.NewRequest(e.method, "/", nil)
+		req.Header.Set("X-API-Key", e.key)
+
+		rr := httptest.NewRecorder()
+		app.authRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if touched := len(db.TouchedAPIKeys) > 0; touched != (rr.Code != http.StatusUnauthorized) {
+			t.Errorf("%s: expected last used to be recorded only for valid keys, got %v", e.name, db.TouchedAPIKeys)
+		}
+	}
+}
+
+func TestMiddleware_adminRequiredAPIKey(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		key            string
+		expectedStatus int
+	}{
+		{"admin scope", dbrepo.TestAdminAPIKey, http.StatusOK},
+		{"no admin scope", dbrepo.TestReadAPIKey, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", e.key)
+
+		rr := httptest.NewRecorder()
+		app.authRequired(app.adminRequired(nextHandler)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestMiddleware_requestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	saved := app.Logger
+	app.Logger, _ = logging.New(&buf, "json", "info")
+	defer func() { app.Logger = saved }()
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("X-API-Key", dbrepo.TestAdminAPIKey)
+	req.Header.Set(logging.RequestIDHeader, "trace-me")
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if id := rr.Header().Get(logging.RequestIDHeader); id != "trace-me" {
+		t.Errorf("expected the request id to be sent back but got %q", id)
+	}
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected one access log record but got %q", buf.String())
+	}
+	if rec["request_id"] != "trace-me" || rec["user_id"] != float64(1) || rec["route"] != "/users/{userID}" {
+		t.Errorf("unexpected access log record %v", rec)
+	}
+}
+
+func TestMiddleware_tracing(t *testing.T) {
+	var buf bytes.Buffer
+	saved := app.Tracer
+	app.Tracer = tracing.New("test", tracing.NewWriterExporter(&buf))
+	defer func() { app.Tracer = saved }()
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("X-API-Key", dbrepo.TestAdminAPIKey)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	var exported struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					Name       string `json:"name"`
+					Attributes []struct {
+						Key   string            `json:"key"`
+						Value map[string]string `json:"value"`
+					} `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatalf("expected one exported span but got %q", buf.String())
+	}
+
+	span := exported.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Name != "GET /users/{userID}" {
+		t.Errorf("unexpected span %+v", span)
+	}
+
+	var user string
+	for _, a := range span.Attributes {
+		if a.Key == "enduser.id" {
+			user = a.Value["intValue"]
+		}
+	}
+	if user != "1" {
+		t.Errorf("expected the user to be recorded on the span, got %q", user)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"webapp/pkg/health"
+	"webapp/pkg/logging"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,12 +13,43 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	//register middleware
+	mux.Use(app.Tracer.Middleware)
+	mux.Use(app.ClientIP.Middleware)
+	mux.Use(logging.RequestID)
+	mux.Use(logging.AccessLog(app.Logger))
 	mux.Use(middleware.Recoverer)
+	mux.Use(app.Metrics.HTTP.Middleware)
 	mux.Use(app.enableCORS)
+	mux.NotFound(app.notFound)
+	mux.MethodNotAllowed(app.methodNotAllowed)
 
-	// authentication routes -auth handler, refresh handler
-	mux.Post("/auth", app.authenticate)
-	mux.Post("/refresh-token", app.refresh)
+	// health checks, for the orchestrator. They are not part of the api, so not versioned
+	checks := app.healthChecks()
+	mux.Get("/healthz", checks.Healthz)
+	mux.Get("/readyz", checks.Readyz)
+	mux.Get("/version", health.Version)
+	mux.Method("GET", "/metrics", app.Metrics.Registry.Handler())
+
+	// the api described for its consumers, see openAPI
+	mux.Get("/openapi.json", app.serveOpenAPI(app.openAPI()))
+	mux.Get("/docs", app.docs)
+	mux.Method("GET", "/docs/assets/*", app.docsAssets())
+
+	// each version of the api under its own prefix, and the paths from before versioning
+	versions := app.versions()
+	routers := make(map[string]http.Handler, len(versions))
+	for _, v := range versions {
+		routers[v.Name] = app.versionRouter(v)
+		mux.Mount("/"+v.Name, routers[v.Name])
+	}
+	mux.Mount("/", app.unversioned(versions, routers))
+
+	return mux
+}
+
+// v1Routes registers version 1 of the api.
+func (app *application) v1Routes(mux chi.Router) {
+	app.authRoutes(mux)
 
 	// test handler
 	// mux.Get("/test", func(w http.ResponseWriter, r *http.Request) {
@@ -30,17 +63,62 @@ func (app *application) routes() http.Handler {
 
 	// })
 
-	// protected routes
-	mux.Route("/users", func(mux chi.Router) {
-		// user auth middleware
-		mux.Use(app.authRequired)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.rateLimit(app.DefaultLimit))
+
+		mux.Post("/logout", app.logout)
+
+		// protected routes
+		mux.Route("/users", func(mux chi.Router) {
+			// user auth middleware
+			mux.Use(app.authRequired)
+
+			app.userRoutes(mux)
+
+			// the old shape, until clients have moved to the routes above
+			mux.With(deprecated(usersDeprecation, "/users")).Put("/", app.insertUser)
+			mux.With(deprecated(usersDeprecation, "/users")).Patch("/", app.updateUser)
+		})
 
-		mux.Get("/", app.allUsers)
-		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/", app.insertUser)
-		mux.Patch("/", app.updateUser)
+		mux.Route("/api-keys", func(mux chi.Router) {
+			mux.Use(app.authRequired)
+
+			app.apiKeyRoutes(mux)
+		})
 	})
+}
 
-	return mux
+// The route groups below are shared by the versions that keep them unchanged.
+
+// authRoutes registers the authentication routes -auth handler, refresh handler. They check
+// passwords or hand out tokens, so they get the stricter limit.
+func (app *application) authRoutes(mux chi.Router) {
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.rateLimit(app.AuthLimit))
+
+		mux.Post("/auth", app.authenticate)
+		mux.Post("/auth/mfa", app.authenticateMFA)
+		mux.Post("/refresh-token", app.refresh)
+		mux.Get("/refresh", app.refreshFromCookie)
+		mux.Post("/forgot-password", app.forgotPassword)
+		mux.Post("/reset-password", app.resetPassword)
+	})
+}
+
+// userRoutes registers the user resource, under /users.
+func (app *application) userRoutes(mux chi.Router) {
+	mux.Get("/", app.allUsers)
+	mux.Post("/", app.createUser)
+	mux.Get("/{userID}", app.getUser)
+	mux.Put("/{userID}", app.replaceUser)
+	mux.Patch("/{userID}", app.patchUser)
+	mux.Delete("/{userID}", app.deleteUser)
+	mux.With(app.adminRequired).Delete("/{userID}/lockout", app.unlockUser)
+}
+
+// apiKeyRoutes registers the caller's api keys, under /api-keys.
+func (app *application) apiKeyRoutes(mux chi.Router) {
+	mux.Get("/", app.allAPIKeys)
+	mux.Post("/", app.createAPIKey)
+	mux.Delete("/{keyID}", app.revokeAPIKey)
 }
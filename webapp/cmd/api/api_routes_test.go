@@ -12,13 +12,29 @@ var registered = []struct {
 	route  string
 	method string
 }{
-	{route: "/auth", method: "POST"},
-	{route: "/refresh-token", method: "POST"},
-	{route: "/users/", method: "GET"},
-	{route: "/users/{userID}", method: "GET"},
-	{route: "/users/", method: "PATCH"},
-	{route: "/users/", method: "PUT"},
-	{route: "/users/{userID}", method: "DELETE"},
+	{route: "/healthz", method: "GET"},
+	{route: "/readyz", method: "GET"},
+	{route: "/version", method: "GET"},
+	{route: "/metrics", method: "GET"},
+	{route: "/v1/auth", method: "POST"},
+	{route: "/v1/auth/mfa", method: "POST"},
+	{route: "/v1/refresh-token", method: "POST"},
+	{route: "/v1/refresh", method: "GET"},
+	{route: "/v1/logout", method: "POST"},
+	{route: "/v1/forgot-password", method: "POST"},
+	{route: "/v1/reset-password", method: "POST"},
+	{route: "/v1/users/", method: "GET"},
+	{route: "/v1/users/", method: "POST"},
+	{route: "/v1/users/{userID}", method: "GET"},
+	{route: "/v1/users/{userID}", method: "PUT"},
+	{route: "/v1/users/{userID}", method: "PATCH"},
+	{route: "/v1/users/", method: "PATCH"},
+	{route: "/v1/users/", method: "PUT"},
+	{route: "/v1/users/{userID}", method: "DELETE"},
+	{route: "/v1/users/{userID}/lockout", method: "DELETE"},
+	{route: "/v1/api-keys/", method: "GET"},
+	{route: "/v1/api-keys/", method: "POST"},
+	{route: "/v1/api-keys/{keyID}", method: "DELETE"},
 }
 
 func TestAPI_routes(t *testing.T) {
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"webapp/pkg/data"
@@ -13,14 +17,26 @@ import (
 
 var jwtTokenExpiry = time.Minute * 15
 var refreshTokenExpiry = time.Hour * 24
+var mfaTokenExpiry = time.Minute * 5
+
+// mfaAudience marks the short lived token handed out between the password and code steps,
+// so that it can never be mistaken for an access or refresh token.
+const mfaAudience = "mfa"
+
+// refreshAudience marks refresh tokens, which are only accepted by the refresh and logout
+// handlers. Access tokens carry the domain as their audience and can not be used in their place.
+const refreshAudience = "refresh"
 
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// CSRFToken must be sent in the X-CSRF-Token header to refresh with the cookie.
+	CSRFToken string `json:"csrf_token"`
 }
 
 type Claims struct {
 	UserName string `json:"name"`
+	Admin    bool   `json:"admin"`
 	jwt.RegisteredClaims
 }
 
@@ -74,10 +90,82 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 		return "", nil, errors.New("incorrect issuer")
 	}
 
+	// and that it has not been logged out
+	err = app.checkNotRevoked(r.Context(), claims)
+	if err != nil {
+		return "", nil, err
+	}
+
 	return token, claims, nil
 }
 
+// parseToken checks the signature and expiry of a token we issued and returns its claims.
+func (app *application) parseToken(token string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(app.JWTSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// newTokenID returns a random jti, so that a single token can be revoked.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issuedBeforePasswordChange reports whether the token with claims was issued before the
+// user's password was last reset, which revokes every token issued until then.
+func issuedBeforePasswordChange(claims *Claims, user *data.User) bool {
+	if user.PasswordChangedAt.IsZero() {
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.PasswordChangedAt.Truncate(time.Second))
+}
+
+// errTokenRevoked is returned by checkNotRevoked for a token that has been revoked.
+var errTokenRevoked = errors.New("token has been revoked")
+
+// checkNotRevoked returns errTokenRevoked if the token with claims has been revoked, or
+// the store's error if that cannot be checked.
+func (app *application) checkNotRevoked(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" {
+		return nil
+	}
+
+	revoked, err := app.Revoked.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errTokenRevoked
+	}
+
+	return nil
+}
+
+// revokeToken stops the token with claims from being used again before it expires.
+func (app *application) revokeToken(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return errors.New("token cannot be revoked")
+	}
+	return app.Revoked.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
 func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
+	var err error
 
 	// create the token
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -93,7 +181,12 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	} else {
 		claims["admin"] = false
 	}
+	claims["iat"] = time.Now().Unix()
 	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
+	claims["jti"], err = newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
 	// create signed token
 	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
@@ -105,14 +198,74 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	refreshToken := jwt.New(jwt.SigningMethodHS256)
 	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["aud"] = refreshAudience
+	refreshTokenClaims["iat"] = time.Now().Unix()
 	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshTokenClaims["jti"], err = newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
 	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
-	tokenPairs := TokenPairs{signedAccessToken, signedRefreshToken}
+	tokenPairs := TokenPairs{
+		Token:        signedAccessToken,
+		RefreshToken: signedRefreshToken,
+		CSRFToken:    app.refreshCSRFToken(signedRefreshToken),
+	}
 
 	return tokenPairs, nil
 }
+
+// generateMFAToken issues the challenge token returned when a user with two-factor enabled
+// has entered the right password, and that must be exchanged with a code for a TokenPairs.
+// It is revoked once it has been used, see authenticateMFA.
+func (app *application) generateMFAToken(user *data.User) (string, error) {
+	var err error
+
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = mfaAudience
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(mfaTokenExpiry).Unix()
+	claims["jti"], err = newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	return token.SignedString([]byte(app.JWTSecret))
+}
+
+// verifyMFAToken checks a challenge token and returns its claims and the ID of the user it
+// was issued to. Challenges without a jti, which cannot be revoked, are refused.
+func (app *application) verifyMFAToken(ctx context.Context, token string) (*Claims, int, error) {
+	claims, err := app.parseToken(token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !claims.VerifyAudience(mfaAudience, true) {
+		return nil, 0, errors.New("not an mfa token")
+	}
+
+	if claims.ID == "" {
+		return nil, 0, errors.New("mfa token has no id")
+	}
+
+	err = app.checkNotRevoked(ctx, claims)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return claims, userID, nil
+}
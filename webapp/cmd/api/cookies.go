@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+// refreshCookieName returns the name of the cookie holding the refresh token. Browsers
+// only accept a __Host- cookie if it is secure and has no domain, and a __Secure- cookie
+// if it is secure, so the prefix depends on how the cookie is configured.
+func (app *application) refreshCookieName() string {
+	switch {
+	case app.CookieSecure && app.CookieDomain == "":
+		return "__Host-refresh_token"
+	case app.CookieSecure:
+		return "__Secure-refresh_token"
+	default:
+		return "refresh_token"
+	}
+}
+
+// refreshCookie returns the cookie holding a refresh token for web apps. A zero or
+// negative maxAge returns a cookie that deletes it.
+func (app *application) refreshCookie(token string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     app.refreshCookieName(),
+		Path:     "/",
+		Value:    token,
+		Expires:  time.Now().Add(maxAge),
+		MaxAge:   int(maxAge.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain:   app.CookieDomain,
+		HttpOnly: true,
+		Secure:   app.CookieSecure,
+	}
+
+	if maxAge <= 0 {
+		c.Expires = time.Unix(0, 0)
+		c.MaxAge = -1
+	}
+
+	return c
+}
+
+// refreshCSRFToken returns the token a browser must echo back in the X-CSRF-Token header
+// to refresh with the cookie. It is derived from the refresh token, so it changes on every
+// rotation and needs no storage, and only a page that was given it in a response body can
+// know it.
+func (app *application) refreshCSRFToken(refreshToken string) string {
+	mac := hmac.New(sha256.New, []byte(app.JWTSecret))
+	mac.Write([]byte("csrf|" + refreshToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *application) validRefreshCSRF(refreshToken, csrfToken string) bool {
+	if csrfToken == "" {
+		return false
+	}
+	return hmac.Equal([]byte(app.refreshCSRFToken(refreshToken)), []byte(csrfToken))
+}
+
+// sendTokens writes tokenPairs as the response and puts the refresh token in the cookie
+// as well. The body still carries the refresh token, for clients that are not browsers,
+// so a page that reads the body can see it; browser clients should drop it and refresh
+// with the cookie, which scripts can not read.
+func (app *application) sendTokens(w http.ResponseWriter, tokenPairs TokenPairs) {
+	http.SetCookie(w, app.refreshCookie(tokenPairs.RefreshToken, refreshTokenExpiry))
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
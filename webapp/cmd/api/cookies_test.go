@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+)
+
+func TestApi_refreshCookieName(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   string
+		secure   bool
+		expected string
+	}{
+		{"host only", "", true, "__Host-refresh_token"},
+		{"with domain", "example.com", true, "__Secure-refresh_token"},
+		{"insecure", "", false, "refresh_token"},
+	}
+
+	for _, e := range tests {
+		a := application{CookieDomain: e.domain, CookieSecure: e.secure}
+
+		if name := a.refreshCookieName(); name != e.expected {
+			t.Errorf("%s: expected cookie name %s but got %s", e.name, e.expected, name)
+		}
+
+		c := a.refreshCookie("token", refreshTokenExpiry)
+		if c.Domain != e.domain || c.Secure != e.secure || !c.HttpOnly {
+			t.Errorf("%s: unexpected cookie %s", e.name, c.String())
+		}
+	}
+}
+
+func TestApi_refreshFromCookie(t *testing.T) {
+	testUser := &data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	other, _ := app.generateTokenPair(testUser)
+
+	tests := []struct {
+		name           string
+		cookie         bool
+		csrf           string
+		expectedStatus int
+	}{
+		{"valid", true, "own", http.StatusOK},
+		{"no csrf token", true, "", http.StatusForbidden},
+		{"another token's csrf token", true, other.CSRFToken, http.StatusForbidden},
+		{"no cookie", false, "own", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		tokens, _ := app.generateTokenPair(testUser)
+
+		req := httptest.NewRequest("GET", "/refresh", nil)
+		if e.cookie {
+			req.AddCookie(&http.Cookie{Name: app.refreshCookieName(), Value: tokens.RefreshToken})
+		}
+		csrf := e.csrf
+		if csrf == "own" {
+			csrf = tokens.CSRFToken
+		}
+		if csrf != "" {
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.refreshFromCookie).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var fresh TokenPairs
+		_ = json.NewDecoder(rr.Body).Decode(&fresh)
+
+		if fresh.RefreshToken == tokens.RefreshToken || fresh.CSRFToken == tokens.CSRFToken {
+			t.Errorf("%s: expected the refresh and csrf tokens to be rotated", e.name)
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Set-Cookie"), app.refreshCookieName()+"="+fresh.RefreshToken) {
+			t.Errorf("%s: expected the cookie to hold the new refresh token", e.name)
+		}
+
+		// the old cookie cannot be used again
+		req = httptest.NewRequest("GET", "/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: app.refreshCookieName(), Value: tokens.RefreshToken})
+		req.Header.Set("X-CSRF-Token", tokens.CSRFToken)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(app.refreshFromCookie).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected reused refresh token to be refused but got %d", e.name, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"webapp/pkg/logging"
+)
+
+// Error codes, which clients can rely on, unlike messages, which may be reworded.
+const (
+	codeBadRequest       = "bad_request"
+	codeMalformedJSON    = "malformed_json"
+	codeUnknownField     = "unknown_field"
+	codeInvalidType      = "invalid_type"
+	codeBodyTooLarge     = "body_too_large"
+	codeValidation       = "validation_failed"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeNotAcceptable    = "not_acceptable"
+	codeRateLimited      = "rate_limited"
+	codeTooManyAttempts  = "too_many_attempts"
+	codeInternal         = "internal_error"
+)
+
+// problemContentType is the RFC 7807 media type, sent to clients that ask for it.
+const problemContentType = "application/problem+json"
+
+// apiError is an error together with what the client is told about it. Handlers and
+// helpers return one when they know better than the status passed to errorJSON.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	// Fields lists problems with individual fields of the request, by JSON name.
+	Fields map[string][]string
+	// Err is the cause, which is logged but never sent.
+	Err error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error { return e.Err }
+
+// errInvalidUserID answers a {userID} URL parameter that is not a number.
+var errInvalidUserID = &apiError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: "invalid user id"}
+
+// codeForStatus is the code used for errors that do not bring their own.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return codeNotAcceptable
+	case http.StatusRequestEntityTooLarge:
+		return codeBodyTooLarge
+	case http.StatusUnprocessableEntity:
+		return codeValidation
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+	return codeBadRequest
+}
+
+// errorBody is the default error response, {"error": {...}}.
+type errorBody struct {
+	Status    int                 `json:"status"`
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Fields    map[string][]string `json:"fields,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// problemBody is the same error as an RFC 7807 problem document, with the code, fields and
+// request ID as extension members.
+type problemBody struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail"`
+	Code      string              `json:"code"`
+	Fields    map[string][]string `json:"fields,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// errorJSON writes err as an error response with status, 400 if not given. An *apiError
+// in err's chain decides the status, code and message itself. Server errors are logged and
+// reported without detail, so that database and other internal errors never reach clients.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
+	var e *apiError
+	if !errors.As(err, &e) {
+		statusCode := http.StatusBadRequest
+		if len(status) > 0 {
+			statusCode = status[0]
+		}
+		e = &apiError{Status: statusCode, Code: codeForStatus(statusCode), Message: err.Error()}
+	}
+
+	message := e.Message
+	if e.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "status", e.Status, "error", err)
+		message = "the server could not handle the request"
+	}
+
+	body := errorBody{
+		Status:    e.Status,
+		Code:      e.Code,
+		Message:   message,
+		Fields:    e.Fields,
+		RequestID: logging.RequestIDFromContext(r.Context()),
+	}
+
+	if wantsProblem(r) {
+		out, _ := json.Marshal(problemBody{
+			Type:      "about:blank",
+			Title:     http.StatusText(body.Status),
+			Status:    body.Status,
+			Detail:    body.Message,
+			Code:      body.Code,
+			Fields:    body.Fields,
+			RequestID: body.RequestID,
+		})
+		w.Header().Set("Content-Type", problemContentType)
+		w.WriteHeader(body.Status)
+		_, _ = w.Write(out)
+		return
+	}
+
+	_ = app.writeJSON(w, body.Status, body, "error")
+}
+
+// wantsProblem reports whether the client listed the problem+json type in Accept.
+func wantsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(part)
+			if err == nil && mediaType == problemContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validationErrorJSON reports which fields of a request were rejected and why, e.g.
+// {"error": {"code": "validation_failed", "fields": {"password": ["..."]}, ...}}.
+func (app *application) validationErrorJSON(w http.ResponseWriter, r *http.Request, fields map[string][]string) {
+	app.errorJSON(w, r, &apiError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    codeValidation,
+		Message: "validation failed",
+		Fields:  fields,
+	})
+}
+
+// notFound and methodNotAllowed answer for the router, so that every error is JSON.
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.errorJSON(w, r, errors.New("no such resource"), http.StatusNotFound)
+}
+
+func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	app.errorJSON(w, r, fmt.Errorf("method %s is not allowed here", r.Method), http.StatusMethodNotAllowed)
+}
+
+// decodeError turns an error from decoding a request body into one fit for the client.
+func decodeError(err error, maxBytes int64) *apiError {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return &apiError{Status: http.StatusBadRequest, Code: codeMalformedJSON,
+			Message: fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset), Err: err}
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &apiError{Status: http.StatusBadRequest, Code: codeMalformedJSON,
+			Message: "body contains badly-formed JSON", Err: err}
+
+	case errors.As(err, &typeError):
+		if typeError.Field == "" {
+			return &apiError{Status: http.StatusBadRequest, Code: codeInvalidType,
+				Message: "body must be a JSON object", Err: err}
+		}
+		return &apiError{Status: http.StatusBadRequest, Code: codeInvalidType,
+			Message: fmt.Sprintf("body contains the wrong type for field %q", typeError.Field),
+			Fields:  map[string][]string{typeError.Field: {"must be a " + jsonType(typeError.Type.Kind().String())}},
+			Err:     err}
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &apiError{Status: http.StatusBadRequest, Code: codeUnknownField,
+			Message: fmt.Sprintf("body contains unknown field %q", field),
+			Fields:  map[string][]string{field: {"is not a known field"}},
+			Err:     err}
+
+	case errors.Is(err, io.EOF):
+		return &apiError{Status: http.StatusBadRequest, Code: codeMalformedJSON,
+			Message: "body must not be empty", Err: err}
+
+	case errors.As(err, &maxBytesError):
+		return &apiError{Status: http.StatusRequestEntityTooLarge, Code: codeBodyTooLarge,
+			Message: fmt.Sprintf("body must not be larger than %d bytes", maxBytes), Err: err}
+	}
+
+	return &apiError{Status: http.StatusBadRequest, Code: codeMalformedJSON,
+		Message: "body could not be read", Err: err}
+}
+
+// jsonType names a Go kind the way a JSON client would think of it.
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "struct", kind == "map":
+		return "object"
+	}
+	return kind
+}
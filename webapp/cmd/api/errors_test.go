@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testErrorBody struct {
+	Error errorBody `json:"error"`
+}
+
+func TestApi_decodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"malformed", `{"email":`, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"bad syntax", `{"email" "x"}`, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"empty", ``, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"unknown field", `{"email":"a@b.c","admin":true}`, http.StatusBadRequest, codeUnknownField, "admin"},
+		{"wrong type", `{"email":5}`, http.StatusBadRequest, codeInvalidType, "email"},
+		{"not an object", `["a@b.c"]`, http.StatusBadRequest, codeInvalidType, ""},
+		{"two values", `{"email":"a@b.c"}{}`, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"too large", `{"email":"` + strings.Repeat("a", 1024*1024) + `"}`, http.StatusRequestEntityTooLarge, codeBodyTooLarge, ""},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/forgot-password", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, rr.Code)
+		}
+
+		var body testErrorBody
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Errorf("%s: expected a JSON error: %s", e.name, err)
+			continue
+		}
+		if body.Error.Code != e.code || body.Error.Status != e.status {
+			t.Errorf("%s: expected code %s but got %+v", e.name, e.code, body.Error)
+		}
+		if e.field != "" && len(body.Error.Fields[e.field]) == 0 {
+			t.Errorf("%s: expected a problem with %s, got %v", e.name, e.field, body.Error.Fields)
+		}
+		if body.Error.RequestID == "" || body.Error.RequestID != rr.Header().Get("X-Request-ID") {
+			t.Errorf("%s: expected the request id in the error", e.name)
+		}
+	}
+}
+
+func TestApi_problemJSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/no-such-thing", nil)
+	req.Header.Set("Accept", "application/json, application/problem+json;q=0.9")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("expected a 404 problem, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	var problem problemBody
+	_ = json.NewDecoder(rr.Body).Decode(&problem)
+	if problem.Type != "about:blank" || problem.Title != "Not Found" || problem.Status != http.StatusNotFound || problem.Code != codeNotFound || problem.RequestID == "" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+}
+
+func TestApi_errorJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  []int
+		code    int
+		message string
+	}{
+		{"default status", errors.New("bad"), nil, http.StatusBadRequest, "bad"},
+		{"given status", errors.New("no"), []int{http.StatusForbidden}, http.StatusForbidden, "no"},
+		{"server errors are hidden", errors.New("pq: relation users does not exist"), []int{http.StatusInternalServerError}, http.StatusInternalServerError, "the server could not handle the request"},
+		{"api error wins", &apiError{Status: http.StatusConflict, Code: "conflict", Message: "taken"}, []int{http.StatusBadRequest}, http.StatusConflict, "taken"},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		app.errorJSON(rr, httptest.NewRequest("GET", "/", nil), e.err, e.status...)
+
+		var body testErrorBody
+		_ = json.NewDecoder(rr.Body).Decode(&body)
+		if rr.Code != e.code || body.Error.Status != e.code || body.Error.Message != e.message {
+			t.Errorf("%s: unexpected response %d %+v", e.name, rr.Code, body.Error)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"webapp/pkg/health"
+)
+
+// healthChecks returns what /readyz checks before traffic is sent to us.
+func (app *application) healthChecks() *health.Checker {
+	c := health.New()
+	c.Add("database", health.Database(app.DB.Connection()))
+	c.Add("revocation", func(ctx context.Context) error {
+		_, err := app.Revoked.IsTokenRevoked(ctx, "readyz")
+		return err
+	})
+	return c
+}
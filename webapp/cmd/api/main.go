@@ -1,44 +1,196 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"sync"
+	"webapp/pkg/clientip"
+	"webapp/pkg/config"
+	"webapp/pkg/cors"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/passwords"
+	"webapp/pkg/ratelimit"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/revocation"
+	"webapp/pkg/server"
+	"webapp/pkg/signedtoken"
+	"webapp/pkg/throttle"
+	"webapp/pkg/tracing"
 )
 
-const port = 8090
-
 type application struct {
 	DSN       string
 	DB        repository.DatabaseRepo
 	Domain    string
 	JWTSecret string
+	Throttle  *throttle.Guard
+	Mailer    mailer.Mailer
+	Tokens    *signedtoken.Signer
+	ResetURL  string
+	Passwords *passwords.Policy
+	Hasher    passwords.Hasher
+	Revoked   revocation.Store
+	// CookieDomain and CookieSecure configure the refresh token cookie.
+	CookieDomain string
+	CookieSecure bool
+	CORS         *cors.CORS
+	Metrics      *metrics.Service
+	Logger       *slog.Logger
+	Tracer       *tracing.Tracer
+	ClientIP     *clientip.Resolver
+	// AuthLimit and DefaultLimit are nil when rate limiting is off.
+	AuthLimit    *ratelimit.Limiter
+	DefaultLimit *ratelimit.Limiter
+	// Background tracks the goroutines started by background.
+	Background *sync.WaitGroup
+}
+
+// background runs fn in a goroutine, for work the response should not wait for.
+// Shutdown waits for it to finish.
+func (app *application) background(fn func()) {
+	app.Background.Add(1)
+	go func() {
+		defer app.Background.Done()
+		fn()
+	}()
 }
 
 func main() {
 
-	app := application{}
-	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres Connection")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], func(fs *flag.FlagSet, c *config.Config) {
+		c.SharedFlags(fs)
+		fs.IntVar(&c.API.Port, "port", c.API.Port, "port to listen on")
+		fs.StringVar(&c.API.Domain, "domain", c.API.Domain, "Domain for application, e.g. company.com")
+		fs.StringVar(&c.Secret, "jwt-secret", c.Secret, "signing secret")
+		fs.StringVar(&c.API.RevocationStore, "revocation-store", c.API.RevocationStore, "where to track logged out tokens: memory|db")
+		fs.StringVar(&c.API.CookieDomain, "cookie-domain", c.API.CookieDomain, "domain for the refresh token cookie; leave empty to keep it to this host")
+		fs.BoolVar(&c.API.CookieSecure, "cookie-secure", c.API.CookieSecure, "only send the refresh token cookie over https")
+		fs.StringVar(&c.API.ResetURL, "reset-url", c.API.ResetURL, "page that password reset links point to")
+		fs.StringVar(&c.API.CORSFile, "cors-config", c.API.CORSFile, "JSON file with the CORS policy, instead of allowing only the local front end")
+		c.API.TLS.Flags(fs)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	log.Printf("Effective config:\n%s", cfg.Redacted())
 
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "oh_my_how_secret_this_is", "signing secret")
-	flag.Parse()
+	// the handler is set once the app is ready; until then it collects what to close
+	srv := server.New(fmt.Sprintf(":%d", cfg.API.Port), nil, cfg.Server)
+	err = srv.ConfigureTLS(cfg.API.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := application{
+		DSN:          cfg.DSN,
+		Domain:       cfg.API.Domain,
+		JWTSecret:    cfg.Secret,
+		ResetURL:     cfg.API.ResetURL,
+		CookieDomain: cfg.API.CookieDomain,
+		CookieSecure: cfg.API.CookieSecure,
+		Metrics:      metrics.NewService(),
+		Logger:       logger,
+		Background:   &sync.WaitGroup{},
+	}
+
+	exporter, err := tracing.NewExporter(cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Tracer = tracing.New("webapp-api", exporter)
+	srv.OnShutdown(func() error {
+		return app.Tracer.Shutdown(context.Background())
+	})
+
+	app.ClientIP, err = clientip.New(cfg.Server.TrustedProxies, cfg.Server.ClientIPHeader)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer conn.Close()
+	srv.OnShutdown(conn.Close)
+	metrics.RegisterPoolStats(app.Metrics.Registry, conn)
+
+	app.Hasher = passwords.NewBcrypt(cfg.BcryptCost)
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn, Hasher: app.Hasher, Metrics: app.Metrics.DB}
+	app.Tokens = signedtoken.New(app.JWTSecret)
+
+	corsCfg := cors.DefaultConfig()
+	if cfg.API.CORSFile != "" {
+		corsCfg, err = cors.LoadFile(cfg.API.CORSFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.CORS, err = cors.New(corsCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.Passwords, err = passwords.NewPolicy(cfg.BreachedPasswords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MailDir != "" {
+		app.Mailer = &mailer.FileMailer{Dir: cfg.MailDir}
+	} else {
+		app.Mailer = mailer.NewLogMailer()
+	}
+
+	switch cfg.ThrottleStore {
+	case "memory":
+		app.Throttle = throttle.New(throttle.NewMemoryStore())
+	case "db":
+		app.Throttle = throttle.New(app.DB)
+	default:
+		log.Fatalf("unknown throttle store %q", cfg.ThrottleStore)
+	}
+
+	if cfg.API.RateLimit.Enabled {
+		store := ratelimit.NewMemoryStore()
+		app.AuthLimit = &ratelimit.Limiter{Store: store, Limit: ratelimit.FromConfig(cfg.API.RateLimit.Auth), Name: "auth"}
+		app.DefaultLimit = &ratelimit.Limiter{Store: store, Limit: ratelimit.FromConfig(cfg.API.RateLimit.Default), Name: "default"}
+	}
+
+	switch cfg.API.RevocationStore {
+	case "memory":
+		app.Revoked = revocation.NewMemoryStore()
+	case "db":
+		app.Revoked = app.DB
+	default:
+		log.Fatalf("unknown revocation store %q", cfg.API.RevocationStore)
+	}
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	// registered last so that it runs first, while everything the work needs is still open
+	srv.OnShutdown(func() error {
+		app.Background.Wait()
+		return nil
+	})
 
-	log.Printf("Starting api on port %d", port)
+	log.Printf("Starting api on port %d", cfg.API.Port)
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	srv.Server.Handler = app.routes()
+	err = srv.Run(context.Background())
 
 	if err != nil {
 		log.Fatal(err)
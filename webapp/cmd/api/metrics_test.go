@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/metrics"
+)
+
+func TestApi_metrics(t *testing.T) {
+	saved := app.Metrics
+	app.Metrics = metrics.NewService()
+	defer func() { app.Metrics = saved }()
+
+	routes := app.routes()
+
+	req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email":"metrics@nothere.com","password":"secret"}`))
+	routes.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("X-API-Key", "not a key")
+	routes.ServeHTTP(httptest.NewRecorder(), req)
+
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	body, _ := io.ReadAll(rr.Body)
+	expected := []string{
+		`auth_attempts_total{method="password",result="failure"} 1`,
+		`auth_attempts_total{method="api_key",result="failure"} 1`,
+		`http_requests_total{method="POST",route="/auth",status="401"} 1`,
+		// rejected by middleware before the /users router picked a route
+		`http_requests_total{method="GET",route="/users/*",status="401"} 1`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(body), e) {
+			t.Errorf("expected %q in metrics", e)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/health"
+	"webapp/pkg/openapi"
+)
+
+// docsPage is Swagger UI for /openapi.json. It loads Swagger UI itself from swaggerUI.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// swaggerUI holds the script and stylesheet of swagger-ui-dist 5.18.2, served under
+// /docs/assets/ so that the docs page loads nothing from other sites. Swagger UI is under
+// the Apache License in swagger-ui/LICENSE; update it by replacing the files.
+//
+//go:embed swagger-ui/swagger-ui-bundle.js swagger-ui/swagger-ui.css
+var swaggerUI embed.FS
+
+// openAPI describes the api as an OpenAPI 3 document, which routes serves at /openapi.json.
+// Every route registered in routes must have an operation here; TestApi_openAPIRoutes fails
+// otherwise. The paths without a version are left out: they serve the same operations as
+// /v1, and are deprecated.
+func (app *application) openAPI() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "webapp api",
+		Description: "Each version is served under its own prefix, such as /v1. Errors are {\"error\": {...}}, or RFC 7807 problem documents for clients that accept application/problem+json.",
+		Version:     "1",
+	})
+	doc.Tags = []openapi.Tag{
+		{Name: "auth", Description: "Logging in and out, and refreshing tokens"},
+		{Name: "users", Description: "The users of the application"},
+		{Name: "api-keys", Description: "Keys for scripts to call the api as the user who created them"},
+		{Name: "operations", Description: "Health checks, metrics and this document, outside the versions of the api"},
+	}
+	doc.Components.SecuritySchemes["bearer"] = openapi.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description:  "An access token from /v1/auth or /v1/refresh-token.",
+	}
+	doc.Components.SecuritySchemes["apiKey"] = openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        "X-API-Key",
+		Description: "An api key from /v1/api-keys. Keys need the read scope for GET requests and the write scope for the rest.",
+	}
+	authenticated := []openapi.SecurityRequirement{{"bearer": {}}, {"apiKey": {}}}
+
+	// the errors every operation can answer with, in either format
+	errorSchema := doc.Define("Error", &openapi.Schema{
+		Type:       "object",
+		Properties: map[string]*openapi.Schema{"error": doc.Define("ErrorDetails", errorBody{})},
+		Required:   []string{"error"},
+	})
+	problemSchema := doc.Define("Problem", problemBody{})
+	errorResponse := func(name, description string) *openapi.Response {
+		return doc.DefineResponse(name, &openapi.Response{
+			Description: description,
+			Content: map[string]openapi.MediaType{
+				"application/json": {Schema: errorSchema},
+				problemContentType: {Schema: problemSchema},
+			},
+		})
+	}
+	badRequest := errorResponse("BadRequest", "The request could not be read")
+	unauthorized := errorResponse("Unauthorized", "No valid token or api key was given")
+	forbidden := errorResponse("Forbidden", "The caller may not do this")
+	notFound := errorResponse("NotFound", "There is no such resource")
+	invalid := errorResponse("ValidationFailed", "Fields of the request were rejected, listed in fields")
+	tooManyRequests := errorResponse("TooManyRequests", "The rate limit or the limit on failed logins was reached")
+	doc.Components.Responses["TooManyRequests"].Headers = map[string]openapi.Header{
+		"Retry-After": {Description: "Seconds until the request may be made again", Schema: &openapi.Schema{Type: "integer"}},
+	}
+
+	doc.Define("User", data.User{})
+	userID := openapi.Parameter{Name: "userID", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer"}}
+	tokens := doc.Response("A new access and refresh token. The refresh token is also set in an HttpOnly cookie, for browsers.", "application/json", TokenPairs{})
+
+	// v1 adds an operation of version 1, all of which are rate limited.
+	v1 := func(method, path string, op *openapi.Operation) {
+		op.Responses["429"] = tooManyRequests
+		doc.Add(method, "/v1"+path, op)
+	}
+	// protected is op for a route behind authRequired.
+	protected := func(op *openapi.Operation) *openapi.Operation {
+		op.Security = authenticated
+		op.Responses["401"] = unauthorized
+		op.Responses["403"] = forbidden
+		return op
+	}
+
+	v1("POST", "/auth", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "authenticate",
+		Summary:     "Log in with an email address and password",
+		Description: "Users with two-factor authentication get an MFAChallenge instead of tokens, to answer at /v1/auth/mfa.",
+		RequestBody: doc.Body("application/json", Credentials{}),
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: tokens.Description,
+				Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+					OneOf: []*openapi.Schema{doc.Schema(TokenPairs{}), doc.Schema(MFAChallenge{})},
+				}}},
+			},
+			"400": badRequest,
+			"401": unauthorized,
+		},
+	})
+	v1("POST", "/auth/mfa", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "authenticateMFA",
+		Summary:     "Finish logging in with an authenticator or recovery code",
+		RequestBody: doc.Body("application/json", MFACredentials{}),
+		Responses:   map[string]*openapi.Response{"200": tokens, "400": badRequest, "401": unauthorized},
+	})
+	v1("POST", "/refresh-token", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "refreshToken",
+		Summary:     "Exchange a refresh token for a new pair",
+		Description: "The refresh token given can not be used again.",
+		RequestBody: doc.Body("application/x-www-form-urlencoded", &openapi.Schema{
+			Type:       "object",
+			Properties: map[string]*openapi.Schema{"refresh_token": {Type: "string"}},
+			Required:   []string{"refresh_token"},
+		}),
+		Responses: map[string]*openapi.Response{"200": tokens, "400": badRequest, "401": unauthorized},
+	})
+	v1("GET", "/refresh", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "refreshFromCookie",
+		Summary:     "Exchange the refresh token in the cookie for a new pair",
+		Description: "For browsers. The csrf_token of the last token response must be sent along.",
+		Parameters: []openapi.Parameter{
+			{Name: app.refreshCookieName(), In: "cookie", Required: true, Schema: &openapi.Schema{Type: "string"}},
+			{Name: "X-CSRF-Token", In: "header", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{"200": tokens, "400": badRequest, "401": unauthorized, "403": forbidden},
+	})
+	v1("POST", "/forgot-password", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "forgotPassword",
+		Summary:     "Email a password reset link",
+		Description: "Answers the same whether or not the address has an account.",
+		RequestBody: doc.Body("application/json", ForgotPasswordRequest{}),
+		Responses: map[string]*openapi.Response{
+			"202": doc.Response("A link was sent, if the address has an account", "application/json", struct {
+				Message string `json:"message"`
+			}{}),
+			"400": badRequest,
+		},
+	})
+	v1("POST", "/reset-password", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "resetPassword",
+		Summary:     "Set a new password with the token from a reset link",
+		RequestBody: doc.Body("application/json", ResetPasswordRequest{}),
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "The password was changed"},
+			"400": badRequest,
+			"422": invalid,
+		},
+	})
+	v1("POST", "/logout", &openapi.Operation{
+		Tags:        []string{"auth"},
+		OperationID: "logout",
+		Summary:     "Revoke the access token and the refresh token",
+		Description: "The refresh token is taken from the body, or the cookie. The access token may have expired already.",
+		Security:    []openapi.SecurityRequirement{{"bearer": {}}, {}},
+		RequestBody: &openapi.RequestBody{Content: doc.Body("application/json", LogoutRequest{}).Content},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "The tokens were revoked"},
+			"400": badRequest,
+			"401": unauthorized,
+		},
+	})
+
+	v1("GET", "/users", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "listUsers",
+		Summary:     "List the users",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The users", "application/json", []data.User{})},
+	}))
+	v1("POST", "/users", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "createUser",
+		Summary:     "Create a user",
+		Description: "The password must meet the password policy. Only administrators can create administrators.",
+		RequestBody: doc.Body("application/json", NewUserRequest{}),
+		Responses: map[string]*openapi.Response{
+			"201": {
+				Description: "The new user",
+				Headers:     map[string]openapi.Header{"Location": {Description: "The URL of the new user", Schema: &openapi.Schema{Type: "string"}}},
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.Schema(data.User{})}},
+			},
+			"400": badRequest,
+			"422": invalid,
+		},
+	}))
+	v1("GET", "/users/{userID}", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "getUser",
+		Summary:     "Get a user",
+		Parameters:  []openapi.Parameter{userID},
+		Responses: map[string]*openapi.Response{
+			"200": doc.Response("The user", "application/json", data.User{}),
+			"400": badRequest,
+			"404": notFound,
+		},
+	}))
+	v1("PUT", "/users/{userID}", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "replaceUser",
+		Summary:     "Set all of a user's details",
+		Description: "Users other than administrators can only change themselves, and not is_admin.",
+		Parameters:  []openapi.Parameter{userID},
+		RequestBody: doc.Body("application/json", UserDetails{}),
+		Responses: map[string]*openapi.Response{
+			"200": doc.Response("The updated user", "application/json", data.User{}),
+			"400": badRequest,
+			"404": notFound,
+			"422": invalid,
+		},
+	}))
+	v1("PATCH", "/users/{userID}", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "patchUser",
+		Summary:     "Change some of a user's details",
+		Description: "The body is a JSON Merge Patch (RFC 7396) of the user's details. Users other than administrators can only change themselves, and not is_admin.",
+		Parameters:  []openapi.Parameter{userID},
+		RequestBody: doc.Body("application/merge-patch+json", UserDetails{}),
+		Responses: map[string]*openapi.Response{
+			"200": doc.Response("The updated user", "application/json", data.User{}),
+			"400": badRequest,
+			"404": notFound,
+			"422": invalid,
+		},
+	}))
+	v1("DELETE", "/users/{userID}", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "deleteUser",
+		Summary:     "Delete a user",
+		Parameters:  []openapi.Parameter{userID},
+		Responses:   map[string]*openapi.Response{"204": {Description: "The user was deleted"}, "400": badRequest},
+	}))
+	v1("DELETE", "/users/{userID}/lockout", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "unlockUser",
+		Summary:     "Clear a user's failed logins",
+		Description: "Administrators only.",
+		Parameters:  []openapi.Parameter{userID},
+		Responses:   map[string]*openapi.Response{"204": {Description: "The user can log in again"}, "400": badRequest},
+	}))
+	usersSunset := "Deprecated, and removed after " + usersDeprecation.Sunset.Format("2006-01-02") + ". "
+	v1("PUT", "/users", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "insertUser",
+		Summary:     "Create a user, without answering with it",
+		Description: usersSunset + "Use POST /v1/users.",
+		Deprecated:  true,
+		RequestBody: doc.Body("application/json", NewUserRequest{}),
+		Responses:   map[string]*openapi.Response{"204": {Description: "The user was created"}, "400": badRequest, "422": invalid},
+	}))
+	v1("PATCH", "/users", protected(&openapi.Operation{
+		Tags:        []string{"users"},
+		OperationID: "updateUser",
+		Summary:     "Set all of the details of the user with the id in the body",
+		Description: usersSunset + "Use PUT or PATCH /v1/users/{userID}.",
+		Deprecated:  true,
+		RequestBody: doc.Body("application/json", UpdateUserRequest{}),
+		Responses:   map[string]*openapi.Response{"204": {Description: "The user was updated"}, "400": badRequest, "422": invalid},
+	}))
+
+	v1("GET", "/api-keys", protected(&openapi.Operation{
+		Tags:        []string{"api-keys"},
+		OperationID: "listAPIKeys",
+		Summary:     "List the caller's api keys",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The keys, without the keys themselves", "application/json", []data.APIKey{})},
+	}))
+	v1("POST", "/api-keys", protected(&openapi.Operation{
+		Tags:        []string{"api-keys"},
+		OperationID: "createAPIKey",
+		Summary:     "Issue an api key",
+		Description: "The key is only shown in this response. Api keys cannot be used to create more keys.",
+		RequestBody: doc.Body("application/json", NewAPIKeyRequest{}),
+		Responses: map[string]*openapi.Response{
+			"201": doc.Response("The new key", "application/json", NewAPIKeyResponse{}),
+			"400": badRequest,
+			"422": invalid,
+		},
+	}))
+	v1("DELETE", "/api-keys/{keyID}", protected(&openapi.Operation{
+		Tags:        []string{"api-keys"},
+		OperationID: "revokeAPIKey",
+		Summary:     "Revoke one of the caller's api keys",
+		Parameters:  []openapi.Parameter{{Name: "keyID", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer"}}},
+		Responses:   map[string]*openapi.Response{"204": {Description: "The key was revoked"}, "400": badRequest, "404": notFound},
+	}))
+
+	status := doc.Define("HealthStatus", health.Status{})
+	doc.Add("GET", "/healthz", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "healthz",
+		Summary:     "Report that the process is up",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The process is serving", "application/json", status)},
+	})
+	doc.Add("GET", "/readyz", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "readyz",
+		Summary:     "Report whether the dependencies are available",
+		Responses: map[string]*openapi.Response{
+			"200": doc.Response("Every check passed", "application/json", status),
+			"503": doc.Response("A check failed, named in checks", "application/json", status),
+		},
+	})
+	doc.Add("GET", "/version", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "version",
+		Summary:     "Describe the running build",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The build", "application/json", health.BuildInfo{})},
+	})
+	doc.Add("GET", "/metrics", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "metrics",
+		Summary:     "Metrics in the Prometheus text format",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The metrics", "text/plain", &openapi.Schema{Type: "string"})},
+	})
+	doc.Add("GET", "/openapi.json", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "openAPI",
+		Summary:     "This document",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The OpenAPI document", "application/json", &openapi.Schema{Type: "object"})},
+	})
+	doc.Add("GET", "/docs", &openapi.Operation{
+		Tags:        []string{"operations"},
+		OperationID: "docs",
+		Summary:     "Browse this document with Swagger UI",
+		Responses:   map[string]*openapi.Response{"200": doc.Response("The Swagger UI page", "text/html", &openapi.Schema{Type: "string"})},
+	})
+
+	return doc
+}
+
+// serveOpenAPI returns the handler for /openapi.json. The document is encoded once, as it
+// only changes with the code.
+func (app *application) serveOpenAPI(doc *openapi.Document) http.HandlerFunc {
+	out, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(out)
+	}
+}
+
+// docs serves Swagger UI.
+func (app *application) docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(docsPage)
+}
+
+// docsAssets serves the files of swaggerUI under /docs/assets/.
+func (app *application) docsAssets() http.Handler {
+	assets, err := fs.Sub(swaggerUI, "swagger-ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/docs/assets/", http.FileServer(http.FS(assets)))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/openapi"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestApi_openAPIRoutes(t *testing.T) {
+	doc := app.openAPI()
+
+	registered := make(map[string]bool)
+	err := chi.Walk(app.routes().(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// the paths without a version, which serve the same operations as /v1
+		if route == "/*" {
+			return nil
+		}
+		// Swagger UI's own files, which are not part of the api
+		if route == "/docs/assets/*" {
+			return nil
+		}
+
+		path := route
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+		registered[method+" "+path] = true
+
+		if doc.Operation(method, path) == nil {
+			t.Errorf("%s %s is not in the OpenAPI document", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !registered[strings.ToUpper(method)+" "+path] {
+				t.Errorf("the OpenAPI document has %s %s, which is not a route", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestApi_openAPIDocument(t *testing.T) {
+	req := httptest.NewRequest("GET", "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %q", rr.Header().Get("Content-Type"))
+	}
+
+	var doc openapi.Document
+	err := json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("expected openapi %s but got %q", openapi.Version, doc.OpenAPI)
+	}
+
+	// the schemas come from the types the handlers read and write
+	schemas := doc.Components.Schemas
+	for name, properties := range map[string][]string{
+		"Credentials":    {"email", "password"},
+		"TokenPairs":     {"access_token", "refresh_token", "csrf_token"},
+		"User":           {"id", "first_name", "last_name", "email", "is_admin"},
+		"NewUserRequest": {"first_name", "last_name", "email", "is_admin", "password"},
+		"ErrorDetails":   {"status", "code", "message", "fields", "request_id"},
+	} {
+		s, ok := schemas[name]
+		if !ok {
+			t.Errorf("no schema %s", name)
+			continue
+		}
+		if len(s.Properties) != len(properties) {
+			t.Errorf("%s: expected properties %v but got %v", name, properties, s.Properties)
+		}
+		for _, p := range properties {
+			if s.Properties[p] == nil {
+				t.Errorf("%s: no property %s", name, p)
+			}
+		}
+	}
+
+	if email := schemas["NewUserRequest"].Properties["email"]; email.Format != "email" || email.MaxLength == nil || *email.MaxLength != 255 {
+		t.Errorf("the email of NewUserRequest does not carry its validate rules: %+v", email)
+	}
+
+	op := doc.Paths["/v1/users/{userID}"]["get"]
+	if op == nil || op.Responses["200"].Content["application/json"].Schema.Ref != "#/components/schemas/User" {
+		t.Errorf("GET /v1/users/{userID} does not answer with a User: %+v", op)
+	}
+	if !doc.Paths["/v1/users"]["put"].Deprecated {
+		t.Error("PUT /v1/users is not marked deprecated")
+	}
+}
+
+func TestApi_docs(t *testing.T) {
+	req := httptest.NewRequest("GET", "/docs", nil)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("unexpected content type %q", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), `url: "/openapi.json"`) {
+		t.Error("the page does not load /openapi.json")
+	}
+	if strings.Contains(rr.Body.String(), "://") {
+		t.Error("the page loads something from another site")
+	}
+
+	for _, e := range []struct {
+		path        string
+		contentType string
+	}{
+		{"/docs/assets/swagger-ui-bundle.js", "text/javascript"},
+		{"/docs/assets/swagger-ui.css", "text/css"},
+	} {
+		if !strings.Contains(rr.Body.String(), `"`+e.path+`"`) {
+			t.Errorf("the page does not load %s", e.path)
+		}
+
+		req := httptest.NewRequest("GET", e.path, nil)
+		assetRR := httptest.NewRecorder()
+		app.routes().ServeHTTP(assetRR, req)
+
+		if assetRR.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 but got %d", e.path, assetRR.Code)
+		}
+		if !strings.HasPrefix(assetRR.Header().Get("Content-Type"), e.contentType) {
+			t.Errorf("%s: unexpected content type %q", e.path, assetRR.Header().Get("Content-Type"))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/passwords"
+	"webapp/pkg/signedtoken"
+)
+
+const passwordResetPurpose = "password-reset"
+
+var passwordResetExpiry = time.Hour
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPassword emails a reset link if the address belongs to an account. It always
+// answers 202, and sends the mail after answering so that the time taken is the same too,
+// so that it cannot be used to find out which addresses are registered.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		app.errorJSON(w, r, errors.New("email is required"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), req.Email)
+	if err == nil {
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.sendPasswordReset(user)
+			if err != nil {
+				slog.ErrorContext(ctx, "sending password reset", "error", err)
+			}
+		})
+	}
+
+	payload := struct {
+		Message string `json:"message"`
+	}{
+		Message: "if that address has an account, a reset link has been sent to it",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *application) sendPasswordReset(user *data.User) error {
+	token := app.Tokens.Generate(passwordResetPurpose, user.ID, user.Password, passwordResetExpiry)
+	link := fmt.Sprintf("%s?token=%s", app.ResetURL, url.QueryEscape(token))
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Somebody asked to reset the password for your account. If it was you, follow this link within %s:\n\n%s\n\nIf not, you can ignore this email.",
+			passwordResetExpiry, link),
+	})
+}
+
+// resetPassword sets a new password using a token from a reset email. Access and refresh
+// tokens issued before the reset stop working, see authenticateRequest and refresh, and the
+// user is logged out of the web app.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := signedtoken.UserID(req.Token)
+	if err != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	// tokens are bound to the current password hash, so they stop working once used
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil || app.Tokens.Verify(passwordResetPurpose, req.Token, user.Password) != nil {
+		app.errorJSON(w, r, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	if !app.validatePassword(w, r, req.Password, passwords.Owner{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName}) {
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), user.ID, req.Password)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.DeleteAllUserSessions(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "destroying sessions after password reset", "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestApi_forgotPassword(t *testing.T) {
+	tests := []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+		expectMail         bool
+	}{
+		{"known user", `{"email":"admin@example.com"}`, http.StatusAccepted, true},
+		{"unknown user", `{"email":"nobody@example.com"}`, http.StatusAccepted, false},
+		{"missing email", `{}`, http.StatusBadRequest, false},
+		{"not json", `email`, http.StatusBadRequest, false},
+	}
+
+	for _, e := range tests {
+		mail := &mailer.MemoryMailer{}
+		app.Mailer = mail
+
+		req := httptest.NewRequest("POST", "/forgot-password", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.forgotPassword).ServeHTTP(rr, req)
+		app.Background.Wait()
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		msg, sent := mail.Last()
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %t", e.name, e.expectMail)
+		}
+
+		if sent && !strings.Contains(msg.Body, app.ResetURL+"?token=") {
+			t.Errorf("%s: mail does not contain reset link: %s", e.name, msg.Body)
+		}
+	}
+}
+
+func TestApi_resetPassword(t *testing.T) {
+	user, _ := app.DB.GetUser(context.Background(), 1)
+	token := app.Tokens.Generate(passwordResetPurpose, user.ID, user.Password, passwordResetExpiry)
+	staleToken := app.Tokens.Generate(passwordResetPurpose, user.ID, "old hash", passwordResetExpiry)
+	otherPurpose := app.Tokens.Generate("verify-email", user.ID, user.Password, passwordResetExpiry)
+
+	tests := []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"valid", `{"token":"` + token + `","password":"A-new-password-1"}`, http.StatusNoContent},
+		{"too short", `{"token":"` + token + `","password":"Short-1"}`, http.StatusUnprocessableEntity},
+		{"contains email", `{"token":"` + token + `","password":"Admin-password-1"}`, http.StatusUnprocessableEntity},
+		{"already used", `{"token":"` + staleToken + `","password":"A-new-password-1"}`, http.StatusBadRequest},
+		{"wrong purpose", `{"token":"` + otherPurpose + `","password":"A-new-password-1"}`, http.StatusBadRequest},
+		{"bad token", `{"token":"nonsense","password":"A-new-password-1"}`, http.StatusBadRequest},
+		{"not json", `token`, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/reset-password", strings.NewReader(e.requestBody))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.resetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusUnprocessableEntity && !strings.Contains(rr.Body.String(), `"fields":{"password":[`) {
+			t.Errorf("%s: expected password field errors but got %s", e.name, rr.Body.String())
+		}
+	}
+
+	// the valid reset logged the user out of the web app
+	if loggedOut := app.DB.(*dbrepo.TestDBRepo).LoggedOut; len(loggedOut) != 1 || loggedOut[0] != user.ID {
+		t.Errorf("expected the user's web sessions to be deleted once, got %v", loggedOut)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"webapp/pkg/ratelimit"
+)
+
+// rateLimit applies l to each request, keyed by rateLimitKey. A nil l, when limiting is
+// turned off, lets everything through.
+func (app *application) rateLimit(l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Take(r.Context(), app.rateLimitKey(r))
+			if err != nil {
+				// fail open, an unavailable store should not take the api down with it
+				slog.ErrorContext(r.Context(), "checking rate limit", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			l.SetHeaders(w.Header(), res)
+			if !res.Allowed {
+				app.errorJSON(w, r, errors.New("rate limit exceeded, try again later"), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey is the user a valid access token was issued to, or else the client IP. Only
+// the signature is checked, not revocation, which keeps the database out of it; an unchecked
+// value would let a client pick a fresh bucket for every request.
+func (app *application) rateLimitKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := app.parseToken(token)
+		if err == nil && claims.Issuer == app.Domain && claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	}
+	return "ip:" + app.clientIP(r)
+}
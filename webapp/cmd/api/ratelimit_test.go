@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/ratelimit"
+)
+
+func TestApi_rateLimit(t *testing.T) {
+	savedAuth, savedDefault := app.AuthLimit, app.DefaultLimit
+	defer func() { app.AuthLimit, app.DefaultLimit = savedAuth, savedDefault }()
+
+	store := ratelimit.NewMemoryStore()
+	app.AuthLimit = &ratelimit.Limiter{Store: store, Limit: ratelimit.Limit{Requests: 2, Period: time.Hour}, Name: "auth"}
+	app.DefaultLimit = &ratelimit.Limiter{Store: store, Limit: ratelimit.Limit{Requests: 1, Period: time.Hour}, Name: "default"}
+
+	routes := app.routes()
+
+	auth := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email":"limit@nothere.com","password":"secret"}`))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := auth("10.0.0.1:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status %d but got %d", i+1, http.StatusUnauthorized, rr.Code)
+		}
+	}
+
+	rr := auth("10.0.0.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"message":"rate limit exceeded`) {
+		t.Errorf("expected a JSON error but got %s", rr.Body.String())
+	}
+	if rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("Retry-After") != "1800" {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+
+	// another client has its own bucket
+	if rr := auth("10.0.0.2:1234"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected another IP to be let through but got %d", rr.Code)
+	}
+
+	// authenticated requests are counted per user, wherever they come from
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1})
+	other, _ := app.generateTokenPair(&data.User{ID: 3})
+	users := func(token string) int {
+		req := httptest.NewRequest("GET", "/users/1", nil)
+		req.RemoteAddr = "10.0.0.9:1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := users(tokens.Token); code == http.StatusTooManyRequests {
+		t.Error("expected the first request to be let through")
+	}
+	if code := users(tokens.Token); code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d but got %d", http.StatusTooManyRequests, code)
+	}
+	if code := users(other.Token); code == http.StatusTooManyRequests {
+		t.Error("expected another user to have their own bucket")
+	}
+
+	// health checks are never limited
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("expected /healthz to be left alone, got %d", rr.Code)
+		}
+	}
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"io"
 	"os"
+	"sync"
 	"testing"
+	"webapp/pkg/cors"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/revocation"
+	"webapp/pkg/signedtoken"
+	"webapp/pkg/throttle"
+	"webapp/pkg/tracing"
 )
 
 var app application
@@ -11,7 +22,20 @@ var expiredToken = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJhZG1pbiI6dHJ1ZSwiYXV
 
 func TestMain(m *testing.M) {
 	app.DB = &dbrepo.TestDBRepo{}
+	app.Metrics = metrics.NewService()
+	app.Logger, _ = logging.New(io.Discard, "text", "error")
+	app.Tracer = tracing.New("test", nil)
+	app.Throttle = throttle.New(throttle.NewMemoryStore())
+	app.Mailer = &mailer.MemoryMailer{}
+	app.Background = &sync.WaitGroup{}
+	app.Passwords = passwords.DefaultPolicy()
+	app.Hasher = passwords.NewBcrypt(passwords.DefaultBcryptCost)
+	app.Revoked = revocation.NewMemoryStore()
+	app.ResetURL = "http://localhost:8080/reset-password"
 	app.Domain = "example.com"
+	app.CookieSecure = true
+	app.CORS, _ = cors.New(cors.DefaultConfig())
 	app.JWTSecret = "oh_my_how_secret_this_is"
+	app.Tokens = signedtoken.New(app.JWTSecret)
 	os.Exit(m.Run())
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+// serveAsAdmin sends a request through the routes with an access token for user 1.
+func serveAsAdmin(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, &data.User{ID: 1, IsAdmin: 1}, method, target, body)
+}
+
+// serveAs sends a request through the routes with an access token for user.
+func serveAs(t *testing.T, user *data.User, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	tokens, _ := app.generateTokenPair(user)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestApi_createUser(t *testing.T) {
+	rr := serveAsAdmin(t, "POST", "/users", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"Correct-Horse-9"}`)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var user data.User
+	_ = json.NewDecoder(rr.Body).Decode(&user)
+	if user.Email != "jack@example.com" || rr.Header().Get("Location") != "/users/-1" {
+		t.Errorf("unexpected user %+v at %q", user, rr.Header().Get("Location"))
+	}
+	if strings.Contains(rr.Body.String(), "password") {
+		t.Error("did not expect the password in the response")
+	}
+
+	rr = serveAsAdmin(t, "POST", "/users", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d without a password but got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// longer than bcrypt can hash
+	long := "Correct-Horse-9" + strings.Repeat("x", 58)
+	rr = serveAsAdmin(t, "POST", "/users", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"`+long+`"}`)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), `"password":["Password must be at most 72 bytes long"]`) {
+		t.Errorf("expected a password field error for a 73 byte password but got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestApi_createUserVerification(t *testing.T) {
+	db := app.DB.(*dbrepo.TestDBRepo)
+	body := `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"Correct-Horse-9"}`
+
+	tests := []struct {
+		name     string
+		user     *data.User
+		verified bool
+	}{
+		{"admin", &data.User{ID: 1, IsAdmin: 1}, true},
+		{"not admin", &data.User{ID: 3}, false},
+	}
+
+	for _, e := range tests {
+		rr := serveAs(t, e.user, "POST", "/users", body)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("%s: expected status %d but got %d: %s", e.name, http.StatusCreated, rr.Code, rr.Body.String())
+		}
+
+		inserted := db.Inserted[len(db.Inserted)-1]
+		if !inserted.EmailVerifiedAt.IsZero() != e.verified {
+			t.Errorf("%s: expected verified %t but got email_verified_at %s", e.name, e.verified, inserted.EmailVerifiedAt)
+		}
+	}
+}
+
+func TestApi_replaceAndPatchUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedUser   data.User
+	}{
+		{"replace", "PUT", "/users/1", `{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com","is_admin":1}`, http.StatusOK,
+			data.User{ID: 1, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", IsAdmin: 1}},
+		{"replace needs everything", "PUT", "/users/1", `{"first_name":"Ada"}`, http.StatusUnprocessableEntity, data.User{}},
+		{"replace unknown user", "PUT", "/users/2", `{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com"}`, http.StatusNotFound, data.User{}},
+		{"replace bad id", "PUT", "/users/x", `{}`, http.StatusBadRequest, data.User{}},
+		{"patch one field", "PATCH", "/users/1", `{"first_name":"Ada"}`, http.StatusOK,
+			data.User{ID: 1, FirstName: "Ada", LastName: "User", Email: "admin@example.com", IsAdmin: 1}},
+		{"patch removes is_admin", "PATCH", "/users/1", `{"is_admin":null}`, http.StatusOK,
+			data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 0}},
+		{"patch cannot remove email", "PATCH", "/users/1", `{"email":null}`, http.StatusUnprocessableEntity, data.User{}},
+		{"patch bad email", "PATCH", "/users/1", `{"email":"admin"}`, http.StatusUnprocessableEntity, data.User{}},
+		{"patch unknown field", "PATCH", "/users/1", `{"password":"x"}`, http.StatusBadRequest, data.User{}},
+		{"patch wrong type", "PATCH", "/users/1", `{"is_admin":"yes"}`, http.StatusBadRequest, data.User{}},
+		{"patch not an object", "PATCH", "/users/1", `["first_name"]`, http.StatusBadRequest, data.User{}},
+		{"patch unknown user", "PATCH", "/users/2", `{}`, http.StatusNotFound, data.User{}},
+	}
+
+	for _, e := range tests {
+		rr := serveAsAdmin(t, e.method, e.target, e.body)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if e.expectedStatus == http.StatusOK {
+			var user data.User
+			_ = json.NewDecoder(rr.Body).Decode(&user)
+			if user != e.expectedUser {
+				t.Errorf("%s: expected %+v but got %+v", e.name, e.expectedUser, user)
+			}
+		}
+	}
+}
+
+func TestApi_changeUserPermissions(t *testing.T) {
+	admin := &data.User{ID: 1, IsAdmin: 1}
+	user := &data.User{ID: 3}
+	details := `"first_name":"MFA","last_name":"User","email":"mfa@example.com"`
+
+	tests := []struct {
+		name           string
+		caller         *data.User
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+	}{
+		{"patch own details", user, "PATCH", "/users/3", `{"first_name":"Ada"}`, http.StatusOK},
+		{"replace own details", user, "PUT", "/users/3", `{` + details + `,"is_admin":0}`, http.StatusOK},
+		{"patch own is_admin", user, "PATCH", "/users/3", `{"is_admin":1}`, http.StatusForbidden},
+		{"replace own is_admin", user, "PUT", "/users/3", `{` + details + `,"is_admin":1}`, http.StatusForbidden},
+		{"patch someone else", user, "PATCH", "/users/1", `{"first_name":"Ada"}`, http.StatusForbidden},
+		{"deprecated patch of someone else", user, "PATCH", "/v1/users/", `{"id":1,"first_name":"Ada","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusForbidden},
+		{"deprecated patch of own is_admin", user, "PATCH", "/v1/users/", `{"id":3,` + details + `,"is_admin":1}`, http.StatusForbidden},
+		{"create an administrator", user, "POST", "/users", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":1,"password":"Correct-Horse-9"}`, http.StatusForbidden},
+		{"admin patches someone else", admin, "PATCH", "/users/3", `{"is_admin":1}`, http.StatusOK},
+		{"admin creates an administrator", admin, "POST", "/users", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","is_admin":1,"password":"Correct-Horse-9"}`, http.StatusCreated},
+	}
+
+	for _, e := range tests {
+		rr := serveAs(t, e.caller, e.method, e.target, e.body)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestApi_deprecatedUserRoutes(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"PUT", `{"first_name":"Jack","last_name":"Smith","email":"jack@example.com","password":"Correct-Horse-9"}`, http.StatusNoContent},
+		{"PATCH", `{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com"}`, http.StatusNoContent},
+	}
+
+	for _, e := range tests {
+		rr := serveAsAdmin(t, e.method, "/v1/users/", e.body)
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.method, e.status, rr.Code)
+		}
+		if rr.Header().Get("Deprecation") != "@1792368000" || rr.Header().Get("Sunset") != "Mon, 19 Apr 2027 00:00:00 GMT" {
+			t.Errorf("%s: expected deprecation headers, got %v", e.method, rr.Header())
+		}
+		if rr.Header().Get("Link") != `</v1/users>; rel="successor-version"` {
+			t.Errorf("%s: unexpected link %q", e.method, rr.Header().Get("Link"))
+		}
+	}
+
+	rr := serveAsAdmin(t, "GET", "/v1/users/1", "")
+	if rr.Header().Get("Deprecation") != "" {
+		t.Error("did not expect other routes to be deprecated")
+	}
+}
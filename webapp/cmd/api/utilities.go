@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
-	"errors"
 	"io"
 	"net/http"
+	"webapp/pkg/passwords"
+	"webapp/pkg/validate"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,40 +44,111 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
-	statusCode := http.StatusBadRequest
-	if len(status) > 0 {
-		statusCode = status[0]
-	}
-
-	type jsonError struct {
-		Message string `json:"message"`
+// validatePassword checks password against the password policy. It writes the response
+// and returns false if the password was rejected.
+func (app *application) validatePassword(w http.ResponseWriter, r *http.Request, password string, owner passwords.Owner) bool {
+	problems, err := app.Passwords.Validate(password, owner)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return false
 	}
 
-	theError := jsonError{
-		Message: err.Error(),
+	if len(problems) > 0 {
+		app.validationErrorJSON(w, r, map[string][]string{"password": problems})
+		return false
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	return true
 }
 
+// readJSON decodes the request body into data. Its errors are *apiError, telling the
+// client what was wrong with the body.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1024 * 1024 // one megabyte
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	maxBytes := int64(1024 * 1024) // one megabyte
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
 	// attempt to decode the data
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		return decodeError(err, maxBytes)
 	}
 
 	// make sure only one JSON value in payload
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return &apiError{Status: http.StatusBadRequest, Code: codeMalformedJSON, Message: "body must only contain a single JSON value"}
+	}
+
+	return nil
+}
+
+// mergePatch applies patch, a decoded JSON Merge Patch (RFC 7396), to the JSON form of
+// target, and decodes the result into out. Members out does not have are refused, as in
+// readJSON.
+func mergePatch(target interface{}, patch interface{}, out interface{}) error {
+	b, err := json.Marshal(target)
+	if err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Message: "encoding patch target", Err: err}
+	}
+
+	var doc interface{}
+	_ = json.Unmarshal(b, &doc)
+
+	b, err = json.Marshal(mergeValue(doc, patch))
+	if err != nil {
+		return &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Message: "encoding patched document", Err: err}
 	}
 
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(out); err != nil {
+		return decodeError(err, int64(len(b)))
+	}
 	return nil
 }
+
+// mergeValue is the MergePatch function from RFC 7396.
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergeValue(t[name], value)
+		}
+	}
+	return t
+}
+
+// readRequest decodes the body into req and checks it against the validate tags on its
+// fields. It writes the error response and returns false if either fails, so that nothing
+// invalid gets as far as the database.
+func (app *application) readRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	err := app.readJSON(w, r, req)
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return false
+	}
+
+	if fields := validate.Struct(req); fields != nil {
+		app.validationErrorJSON(w, r, fields)
+		return false
+	}
+
+	return true
+}
+
+// clientIP returns the address of the client, looking past our own proxies.
+func (app *application) clientIP(r *http.Request) string {
+	return app.ClientIP.ClientIP(r)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiVersion is one version of the api, served under /<Name>. A new version gets its own
+// Routes, which can reuse the route groups that still apply, such as authRoutes, and
+// register new handlers for the rest; everything it leaves out is gone from that version.
+type apiVersion struct {
+	Name string
+	// Deprecation, if set, is announced on every response from this version.
+	Deprecation *deprecation
+	Routes      func(mux chi.Router)
+}
+
+// versions lists the versions of the api, oldest first.
+func (app *application) versions() []apiVersion {
+	return []apiVersion{
+		{Name: "v1", Routes: app.v1Routes},
+	}
+}
+
+// unversionedDeprecation covers the paths without a version, which were all there was
+// before /v1.
+var unversionedDeprecation = deprecation{
+	Since:  time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+	Sunset: time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC),
+}
+
+// versionMediaType is how clients ask for a version in Accept, e.g.
+// application/vnd.webapp.v1+json.
+const (
+	versionMediaTypePrefix = "application/vnd.webapp."
+	versionMediaTypeSuffix = "+json"
+)
+
+// APIVersionHeader names the version that served a response.
+const APIVersionHeader = "API-Version"
+
+type versionKey struct{}
+
+// apiVersionFromContext returns the version serving the request, or "".
+func apiVersionFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(versionKey{}).(string)
+	return v
+}
+
+// versionRouter builds the router for v.
+func (app *application) versionRouter(v apiVersion) http.Handler {
+	mux := chi.NewRouter()
+	mux.NotFound(app.notFound)
+	mux.MethodNotAllowed(app.methodNotAllowed)
+
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(APIVersionHeader, v.Name)
+			if v.Deprecation != nil {
+				v.Deprecation.announce(w, "/"+v.Name+"/")
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, v.Name)))
+		})
+	})
+
+	v.Routes(mux)
+	return mux
+}
+
+// unversioned serves paths without a version prefix from the version asked for in Accept,
+// or from the first version, which is what they meant before there were versions. Every
+// response says the path is deprecated in favour of the versioned one.
+func (app *application) unversioned(versions []apiVersion, routers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := negotiateVersion(r, versions)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusNotAcceptable)
+			return
+		}
+
+		unversionedDeprecation.announce(w, "/"+name+r.URL.Path)
+		routers[name].ServeHTTP(w, r)
+	})
+}
+
+// negotiateVersion picks the version named by an application/vnd.webapp.<version>+json
+// media type in Accept, the first version if there is none, or fails if only versions that
+// do not exist were asked for.
+func negotiateVersion(r *http.Request, versions []apiVersion) (string, error) {
+	var asked []string
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(part)
+			if err != nil || !strings.HasPrefix(mediaType, versionMediaTypePrefix) || !strings.HasSuffix(mediaType, versionMediaTypeSuffix) {
+				continue
+			}
+
+			name := strings.TrimSuffix(strings.TrimPrefix(mediaType, versionMediaTypePrefix), versionMediaTypeSuffix)
+			for _, v := range versions {
+				if v.Name == name {
+					return name, nil
+				}
+			}
+			asked = append(asked, name)
+		}
+	}
+
+	if len(asked) > 0 {
+		return "", fmt.Errorf("unknown api version %s", strings.Join(asked, ", "))
+	}
+	return versions[0].Name, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestApi_versions(t *testing.T) {
+	routes := app.routes()
+
+	tests := []struct {
+		name       string
+		target     string
+		accept     string
+		status     int
+		version    string
+		deprecated bool
+		link       string
+	}{
+		{"versioned", "/v1/refresh", "", http.StatusUnauthorized, "v1", false, ""},
+		{"unversioned", "/refresh", "", http.StatusUnauthorized, "v1", true, `</v1/refresh>; rel="successor-version"`},
+		{"asked for in accept", "/refresh", "application/json, application/vnd.webapp.v1+json", http.StatusUnauthorized, "v1", true, `</v1/refresh>; rel="successor-version"`},
+		{"unknown version", "/refresh", "application/vnd.webapp.v9+json", http.StatusNotAcceptable, "", false, ""},
+		{"path wins over accept", "/v1/refresh", "application/vnd.webapp.v9+json", http.StatusUnauthorized, "v1", false, ""},
+		{"unknown path", "/v1/nothing", "", http.StatusNotFound, "v1", false, ""},
+		{"health is not versioned", "/healthz", "", http.StatusOK, "", false, ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.target, nil)
+		if e.accept != "" {
+			req.Header.Set("Accept", e.accept)
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, rr.Code)
+		}
+		if rr.Header().Get(APIVersionHeader) != e.version {
+			t.Errorf("%s: expected version %q but got %q", e.name, e.version, rr.Header().Get(APIVersionHeader))
+		}
+		if (rr.Header().Get("Deprecation") != "") != e.deprecated || rr.Header().Get("Link") != e.link {
+			t.Errorf("%s: unexpected deprecation headers %v", e.name, rr.Header())
+		}
+	}
+}
+
+func TestApi_laterVersion(t *testing.T) {
+	// a second version keeping the auth routes, and dropping the rest of v1
+	v1 := apiVersion{Name: "v1", Deprecation: &deprecation{Since: time.Now(), Sunset: time.Now().AddDate(1, 0, 0)}, Routes: app.v1Routes}
+	v2 := apiVersion{Name: "v2", Routes: func(mux chi.Router) {
+		app.authRoutes(mux)
+	}}
+
+	versions := []apiVersion{v1, v2}
+	routers := map[string]http.Handler{"v1": app.versionRouter(v1), "v2": app.versionRouter(v2)}
+	handler := app.unversioned(versions, routers)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		accept  string
+		status  int
+		version string
+		link    string
+	}{
+		{"shared route", routers["v2"], "/refresh", "", http.StatusUnauthorized, "v2", ""},
+		{"dropped route", routers["v2"], "/users/", "", http.StatusNotFound, "v2", ""},
+		{"deprecated version", routers["v1"], "/refresh", "", http.StatusUnauthorized, "v1", `</v1/>; rel="successor-version"`},
+		{"unversioned default", handler, "/refresh", "", http.StatusUnauthorized, "v1", `</v1/refresh>; rel="successor-version"`},
+		{"unversioned asking for v2", handler, "/refresh", "application/vnd.webapp.v2+json", http.StatusUnauthorized, "v2", `</v2/refresh>; rel="successor-version"`},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.target, nil)
+		if e.accept != "" {
+			req.Header.Set("Accept", e.accept)
+		}
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.status || rr.Header().Get(APIVersionHeader) != e.version {
+			t.Errorf("%s: expected %d from %s but got %d from %q", e.name, e.status, e.version, rr.Code, rr.Header().Get(APIVersionHeader))
+		}
+		if rr.Header().Get("Link") != e.link {
+			t.Errorf("%s: expected link %q but got %q", e.name, e.link, rr.Header().Get("Link"))
+		}
+	}
+}
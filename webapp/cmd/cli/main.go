@@ -4,13 +4,16 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
+	"webapp/pkg/config"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type application struct {
 	JWTSecret string
+	Domain    string
 	Action    string
 }
 
@@ -21,9 +24,17 @@ type application struct {
 
 func main() {
 	var app application
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "oh_my_how_secret_this_is", "secret")
 	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
-	flag.Parse()
+
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], func(fs *flag.FlagSet, c *config.Config) {
+		fs.StringVar(&c.Secret, "jwt-secret", c.Secret, "secret")
+		fs.StringVar(&c.API.Domain, "domain", c.API.Domain, "audience and issuer of the token")
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.JWTSecret = cfg.Secret
+	app.Domain = cfg.API.Domain
 
 	// generate a token
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -33,8 +44,8 @@ func main() {
 	claims["name"] = "John Doe"
 	claims["sub"] = "1"
 	claims["admin"] = true
-	claims["aud"] = "example.com"
-	claims["iss"] = "example.com"
+	claims["aud"] = app.Domain
+	claims["iss"] = app.Domain
 	// leave this to 3 days, for easy manual testing
 	if app.Action == "valid" {
 		expires := time.Now().UTC().Add(time.Hour * 72)
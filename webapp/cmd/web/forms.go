@@ -2,7 +2,8 @@ package main
 
 import (
 	"net/url"
-	"strings"
+	"webapp/pkg/passwords"
+	"webapp/pkg/validate"
 )
 
 type errors map[string][]string
@@ -37,13 +38,31 @@ func (f *Form) Has(field string) bool {
 	return x != ""
 }
 
+// Validate checks field against rules written as in the api's request types, such as
+// "required,email,max=255"; see package validate.
+func (f *Form) Validate(field string, rules string) {
+	for _, problem := range validate.Value(f.Data.Get(field), rules) {
+		f.Errors.Add(field, problem)
+	}
+}
+
 func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
-		value := f.Data.Get(field)
-		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
-		}
+		f.Validate(field, "required")
+	}
+}
+
+// Password adds an error to field for every way its value breaks policy.
+func (f *Form) Password(field string, policy *passwords.Policy, owner passwords.Owner) error {
+	problems, err := policy.Validate(f.Data.Get(field), owner)
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		f.Errors.Add(field, problem)
 	}
+	return nil
 }
 
 func (f *Form) Check(ok bool, key string, message string) {
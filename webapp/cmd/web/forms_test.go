@@ -72,3 +72,25 @@ func Test_form_ErrorGet(t *testing.T) {
 		t.Error("have error when we shouldnt")
 	}
 }
+
+func Test_form_Validate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("good", "admin@example.com")
+	postedData.Add("bad", "not an email")
+	form := NewForm(postedData)
+
+	form.Validate("good", "required,email,max=255")
+	if !form.Valid() {
+		t.Error("form shows invalid email for a valid address")
+	}
+
+	form.Validate("bad", "required,email,max=255")
+	if form.Errors.Get("bad") == "" {
+		t.Error("form shows valid email for an invalid address")
+	}
+
+	form.Validate("missing", "required,email")
+	if form.Errors.Get("missing") == "" {
+		t.Error("form shows a missing required field as valid")
+	}
+}
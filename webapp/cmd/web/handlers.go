@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+	"webapp/pkg/throttle"
 )
 
 var pathToTemplates = "./templates/"
@@ -24,11 +27,40 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	} else {
 		app.Session.Put(r.Context(), "test", "Hit this page at "+time.Now().UTC().String())
 	}
+
+	if email := app.Session.GetString(r.Context(), "unverified_email"); email != "" {
+		td["unverified_email"] = email
+	}
 	_ = app.render(w, r, "home.page.gohtml", &TemplateData{Data: td})
 }
 
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
-	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
+	td := make(map[string]any)
+
+	user := app.userFromContext(r.Context())
+	if user.TOTPSecret != "" && !user.TOTPEnabled {
+		td["totp_secret"] = user.TOTPSecret
+		td["totp_uri"] = mfa.ProvisioningURI(app.MFAIssuer, user.Email, user.TOTPSecret)
+	}
+
+	if codes, ok := app.Session.Pop(r.Context(), "recovery_codes").([]string); ok {
+		td["recovery_codes"] = codes
+	}
+
+	sessions, err := app.DB.AllUserSessions(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "listing sessions", "error", err)
+	}
+	td["sessions"] = sessions
+
+	token := app.Session.Token(r.Context())
+	for _, s := range sessions {
+		if s.Token == token {
+			td["current_session_id"] = s.ID
+		}
+	}
+
+	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Data: td})
 }
 
 type TemplateData struct {
@@ -37,6 +69,7 @@ type TemplateData struct {
 	Error string
 	Flash string
 	User  data.User
+	Form  *Form
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
@@ -52,8 +85,8 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, t string,
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 
-	if app.Session.Exists(r.Context(), "user") {
-		td.User = app.Session.Get(r.Context(), "user").(data.User)
+	if user := app.userFromContext(r.Context()); user != nil {
+		td.User = *user
 	}
 
 	// execute template, passing it data if any
@@ -69,18 +102,21 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "parsing login form", "error", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
+	// a new attempt abandons any login waiting for its second factor
+	app.Session.Remove(r.Context(), "mfa_user_id")
+
 	form := NewForm(r.PostForm)
 
 	form.Required("email", "password")
 	if !form.Valid() {
 		// redirect to login with error message
 		for i := range form.Errors {
-			log.Println(i)
+			slog.DebugContext(r.Context(), "invalid login form", "field", i)
 		}
 		app.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -89,16 +125,30 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
+	ip := app.ipFromContext(r.Context())
 
-	user, err := app.DB.GetUserByEmail(email)
+	if app.tooManyAttempts(w, r, email, ip) {
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
 	if err != nil {
+		app.loginFailed(r.Context(), "password", email, ip)
 		app.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
 	// authenticate user
-	if !app.authenticate(r, user, password) {
+	err = app.authenticate(r, user, password)
+	if err == errEmailNotVerified {
+		app.Session.Put(r.Context(), "unverified_email", user.Email)
+		app.Session.Put(r.Context(), "error", "Please verify your email address before logging in.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.loginFailed(r.Context(), "password", email, ip)
 		app.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -106,6 +156,16 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	// prevent fixation attack
 	_ = app.Session.RenewToken(r.Context())
+	app.Session.Remove(r.Context(), "unverified_email")
+
+	// users with two-factor enabled still need to enter a code
+	if app.Session.Exists(r.Context(), "mfa_user_id") {
+		app.Metrics.Auth.Record("password", true)
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	app.loginSucceeded("password", r, user)
 
 	// redirect to user profile
 	app.Session.Put(r.Context(), "flash", "Login Succesful")
@@ -113,17 +173,105 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
+// Logout ends the current session, leaving the user's other sessions alone.
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromContext(r.Context())
+
+	if id := app.currentSessionID(r, user.ID); id != 0 {
+		if _, err := app.DB.DeleteUserSession(r.Context(), user.ID, id); err != nil {
+			slog.ErrorContext(r.Context(), "deleting session record", "error", err)
+		}
+	}
+
+	err := app.Session.Destroy(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "destroying session", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// start a fresh session under a new token for the flash message
+	_ = app.Session.RenewToken(r.Context())
+
+	app.Session.Put(r.Context(), "flash", "You have been logged out.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	if valid, err := user.PasswordMatches(password); err != nil || !valid {
+// tooManyAttempts responds with 429 and reports true when the account or IP is being throttled.
+func (app *application) tooManyAttempts(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	wait, err := app.Throttle.Allow(r.Context(), email, ip)
+	if err != nil {
+		// fail open, an unavailable store should not lock everybody out
+		slog.ErrorContext(r.Context(), "checking login throttle", "error", err)
 		return false
 	}
 
-	app.Session.Put(r.Context(), "user", user)
+	if wait <= 0 {
+		return false
+	}
 
+	w.Header().Set("Retry-After", throttle.RetryAfter(wait))
+	http.Error(w, "too many failed login attempts, try again later", http.StatusTooManyRequests)
 	return true
 }
 
+func (app *application) loginFailed(ctx context.Context, method, email, ip string) {
+	app.Metrics.Auth.Record(method, false)
+	if err := app.Throttle.Failure(ctx, email, ip); err != nil {
+		slog.ErrorContext(ctx, "recording failed login", "error", err)
+	}
+}
+
+func (app *application) loginSucceeded(method string, r *http.Request, user *data.User) {
+	app.Metrics.Auth.Record(method, true)
+
+	if err := app.Throttle.Success(r.Context(), user.Email); err != nil {
+		slog.ErrorContext(r.Context(), "clearing failed logins", "error", err)
+	}
+
+	app.trackSession(r, user.ID)
+}
+
+// rehashIfNeeded replaces the stored hash of a password that has just been checked if it
+// was made with a lower cost or an older algorithm than the one configured now.
+func (app *application) rehashIfNeeded(ctx context.Context, user *data.User, password string) {
+	if !app.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	if err := app.DB.RehashPassword(ctx, user.ID, user.Password, password); err != nil {
+		slog.ErrorContext(ctx, "rehashing password", "error", err)
+	}
+}
+
+var (
+	errInvalidCredentials = fmt.Errorf("invalid login credentials")
+	errEmailNotVerified   = fmt.Errorf("email address has not been verified")
+)
+
+func (app *application) authenticate(r *http.Request, user *data.User, password string) error {
+
+	if valid, err := app.Hasher.Verify(user.Password, password); err != nil || !valid {
+		return errInvalidCredentials
+	}
+
+	app.rehashIfNeeded(r.Context(), user, password)
+
+	if user.EmailVerifiedAt.IsZero() {
+		return errEmailNotVerified
+	}
+
+	// hold back the user until the second factor has been checked
+	if user.TOTPEnabled {
+		app.Session.Put(r.Context(), "mfa_user_id", user.ID)
+		return nil
+	}
+
+	app.Session.Put(r.Context(), "user_id", user.ID)
+
+	return nil
+}
+
 func (app *application) UploadProfilePicture(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts the file form and upload
 	uploads, err := app.UploadFiles(r, pathToUploads)
@@ -132,8 +280,8 @@ func (app *application) UploadProfilePicture(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// get the user from the session
-	user := app.Session.Get(r.Context(), "user").(data.User)
+	// get the logged in user
+	user := app.userFromContext(r.Context())
 
 	// create a variable of type data.UserImage
 	i := data.UserImage{
@@ -142,17 +290,11 @@ func (app *application) UploadProfilePicture(w http.ResponseWriter, r *http.Requ
 	}
 
 	// insert UserImage into user_images
-	_, err = app.DB.InsertUserImage(i)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-	}
-
-	// refresh session variable "user"
-	updatedUser, err := app.DB.GetUser(user.ID)
+	_, err = app.DB.InsertUserImage(r.Context(), i)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	app.Session.Put(r.Context(), "user", updatedUser)
 
 	// redirect back to profile page
 	app.Session.Put(r.Context(), "flash", "Upload Succesful")
@@ -18,6 +18,9 @@ import (
 	"sync"
 	"testing"
 	"webapp/pkg/data"
+	"webapp/pkg/passwords"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/throttle"
 )
 
 var pageTests = []struct {
@@ -230,6 +233,105 @@ func Test_app_login(t *testing.T) {
 	}
 }
 
+func Test_app_loginKeepsOnlyUserID(t *testing.T) {
+	postedData := url.Values{
+		"email":    {"admin@example.com"},
+		"password": {"secret"},
+	}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if app.Session.GetInt(req.Context(), "user_id") != 1 {
+		t.Error("expected the user's id in the session")
+	}
+
+	// the password hash and TOTP secret have no business in the session store
+	for _, key := range app.Session.Keys(req.Context()) {
+		if _, ok := app.Session.Get(req.Context(), key).(data.User); ok {
+			t.Errorf("session key %s holds the whole user", key)
+		}
+	}
+}
+
+func Test_app_loginThrottled(t *testing.T) {
+	oldThrottle := app.Throttle
+	defer func() { app.Throttle = oldThrottle }()
+
+	app.Throttle = throttle.New(throttle.NewMemoryStore())
+	app.Throttle.Account.FreeAttempts = 1
+
+	attempts := []struct {
+		name               string
+		password           string
+		expectedStatusCode int
+	}{
+		{"first failure", "wrong", http.StatusSeeOther},
+		{"second failure", "wrong", http.StatusSeeOther},
+		{"backing off", "secret", http.StatusTooManyRequests},
+	}
+
+	for _, e := range attempts {
+		postedData := url.Values{
+			"email":    {"admin@example.com"},
+			"password": {e.password},
+		}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code, expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedStatusCode == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+}
+
+func Test_app_loginRehash(t *testing.T) {
+	oldDB, oldHasher := app.DB, app.Hasher
+	defer func() { app.DB, app.Hasher = oldDB, oldHasher }()
+
+	// the test users' hashes have cost 14
+	tests := []struct {
+		name           string
+		cost           int
+		expectedRehash bool
+	}{
+		{"same cost", 14, false},
+		{"higher cost", 15, true},
+	}
+
+	for _, e := range tests {
+		db := &dbrepo.TestDBRepo{}
+		app.DB = db
+		app.Hasher = passwords.NewBcrypt(e.cost)
+
+		postedData := url.Values{
+			"email":    {"admin@example.com"},
+			"password": {"secret"},
+		}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+		if rehashed := len(db.Rehashed) == 1 && db.Rehashed[0] == 1; rehashed != e.expectedRehash {
+			t.Errorf("%s: expected rehash %v but got %v", e.name, e.expectedRehash, db.Rehashed)
+		}
+	}
+}
+
 func Test_app_UploadFiles(t *testing.T) {
 	// set up pipes
 	pr, pw := io.Pipe()
@@ -327,12 +429,12 @@ func Test_app_UploadProfilePicture(t *testing.T) {
 	req := httptest.NewRequest("POST", "/", body)
 	req = addContextAndSessionToRequest(req, app)
 
-	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), "user_id", 1)
 	req.Header.Add("Content-Type", mw.FormDataContentType())
 
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(app.UploadProfilePicture)
+	handler := app.auth(http.HandlerFunc(app.UploadProfilePicture))
 
 	handler.ServeHTTP(rr, req)
 
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"webapp/pkg/health"
+)
+
+// healthChecks returns what /readyz checks before traffic is sent to us.
+func (app *application) healthChecks() *health.Checker {
+	c := health.New()
+	c.Add("database", health.Database(app.DB.Connection()))
+	c.Add("sessions", func(ctx context.Context) error {
+		// looking up a token that does not exist still has to reach the store
+		_, _, err := app.Session.Store.Find("readyz")
+		return err
+	})
+	return c
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp/pkg/health"
+)
+
+func Test_app_health(t *testing.T) {
+	tests := []struct {
+		name           string
+		route          string
+		expectedStatus int
+	}{
+		{"alive", "/healthz", http.StatusOK},
+		// the test repository has no connection to ping
+		{"not ready", "/readyz", http.StatusServiceUnavailable},
+		{"version", "/version", http.StatusOK},
+	}
+
+	routes := app.routes()
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, httptest.NewRequest("GET", e.route, nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if e.route != "/readyz" {
+			continue
+		}
+
+		var status health.Status
+		_ = json.NewDecoder(rr.Body).Decode(&status)
+		if status.Checks["database"] == "ok" || status.Checks["sessions"] != "ok" {
+			t.Errorf("%s: unexpected checks %v", e.name, status.Checks)
+		}
+	}
+}
@@ -1,48 +1,181 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/config"
 	"webapp/pkg/data"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/server"
+	"webapp/pkg/signedtoken"
+	"webapp/pkg/throttle"
+	"webapp/pkg/tracing"
 
 	"github.com/alexedwards/scs/v2"
 )
 
 type application struct {
-	Session *scs.SessionManager
-	DSN     string
-	DB      repository.DatabaseRepo
+	Session   *scs.SessionManager
+	DSN       string
+	DB        repository.DatabaseRepo
+	MFAIssuer string
+	Throttle  *throttle.Guard
+	Mailer    mailer.Mailer
+	Tokens    *signedtoken.Signer
+	BaseURL   string
+	Passwords *passwords.Policy
+	Hasher    passwords.Hasher
+	Metrics   *metrics.Service
+	Logger    *slog.Logger
+	Tracer    *tracing.Tracer
+	ClientIP  *clientip.Resolver
+	// Background tracks the goroutines started by background.
+	Background *sync.WaitGroup
+}
+
+// background runs fn in a goroutine, for work the response should not wait for.
+// Shutdown waits for it to finish.
+func (app *application) background(fn func()) {
+	app.Background.Add(1)
+	go func() {
+		defer app.Background.Done()
+		fn()
+	}()
 }
 
 func main() {
 
+	// sessions only hold the user's id now, but those saved before still hold a data.User
+	// and can not be loaded until they expire without it
 	gob.Register(data.User{})
 
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], func(fs *flag.FlagSet, c *config.Config) {
+		c.SharedFlags(fs)
+		fs.IntVar(&c.Web.Port, "port", c.Web.Port, "Port to listen on")
+		fs.StringVar(&c.Web.MFAIssuer, "mfa-issuer", c.Web.MFAIssuer, "Issuer name shown in authenticator apps")
+		fs.StringVar(&c.Web.SessionStore, "session-store", c.Web.SessionStore, "Where to keep sessions: memory|postgres")
+		fs.StringVar(&c.Secret, "secret", c.Secret, "Signing secret for emailed links")
+		fs.StringVar(&c.Web.BaseURL, "base-url", c.Web.BaseURL, "Public URL of the site, used in emailed links")
+		c.Web.TLS.Flags(fs)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	log.Printf("Effective config:\n%s", cfg.Redacted())
+
+	// the handler is set once the app is ready; until then it collects what to close
+	srv := server.New(fmt.Sprintf(":%d", cfg.Web.Port), nil, cfg.Server)
+	err = srv.ConfigureTLS(cfg.Web.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !cfg.Web.TLS.Enabled() {
+		log.Println("Serving plain HTTP: browsers will not keep the Secure session cookie unless a proxy in front provides HTTPS; use -tls-self-signed locally")
+	}
+
 	// set up an app config
-	app := application{}
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres Connection")
-	flag.Parse()
+	app := application{
+		DSN:        cfg.DSN,
+		MFAIssuer:  cfg.Web.MFAIssuer,
+		BaseURL:    cfg.Web.BaseURL,
+		Metrics:    metrics.NewService(),
+		Logger:     logger,
+		Background: &sync.WaitGroup{},
+	}
+
+	exporter, err := tracing.NewExporter(cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Tracer = tracing.New("webapp-web", exporter)
+	srv.OnShutdown(func() error {
+		return app.Tracer.Shutdown(context.Background())
+	})
+
+	app.ClientIP, err = clientip.New(cfg.Server.TrustedProxies, cfg.Server.ClientIPHeader)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer conn.Close()
+	srv.OnShutdown(conn.Close)
+	metrics.RegisterPoolStats(app.Metrics.Registry, conn)
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Hasher = passwords.NewBcrypt(cfg.BcryptCost)
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn, Hasher: app.Hasher, Metrics: app.Metrics.DB}
+
+	switch cfg.ThrottleStore {
+	case "memory":
+		app.Throttle = throttle.New(throttle.NewMemoryStore())
+	case "db":
+		app.Throttle = throttle.New(app.DB)
+	default:
+		log.Fatalf("unknown throttle store %q", cfg.ThrottleStore)
+	}
 
 	app.Session = getSession()
+	switch cfg.Web.SessionStore {
+	case "memory":
+		// scs keeps sessions in memory by default
+	case "postgres":
+		store := dbrepo.NewPostgresSessionStore(conn, app.Metrics.DB, 5*time.Minute)
+		srv.OnShutdown(func() error {
+			store.StopCleanup()
+			return nil
+		})
+		app.Session.Store = store
+	default:
+		log.Fatalf("unknown session store %q", cfg.Web.SessionStore)
+	}
+	app.Tokens = signedtoken.New(cfg.Secret)
+
+	app.Passwords, err = passwords.NewPolicy(cfg.BreachedPasswords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MailDir != "" {
+		app.Mailer = &mailer.FileMailer{Dir: cfg.MailDir}
+	} else {
+		app.Mailer = mailer.NewLogMailer()
+	}
+
+	// registered last so that it runs first, while everything the work needs is still open
+	srv.OnShutdown(func() error {
+		app.Background.Wait()
+		return nil
+	})
 
 	// print out a message
-	log.Println("Starting server on port 8080...")
+	log.Printf("Starting server on port %d...", cfg.Web.Port)
 
 	// start the server
-	err = http.ListenAndServe(":8080", app.routes())
+	srv.Server.Handler = app.routes()
+	err = srv.Run(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
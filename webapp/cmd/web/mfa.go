@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+)
+
+// MFAPage shows the second login step to a user who has entered a valid password.
+func (app *application) MFAPage(w http.ResponseWriter, r *http.Request) {
+	if !app.Session.Exists(r.Context(), "mfa_user_id") {
+		app.Session.Put(r.Context(), "error", "Log in first.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{})
+}
+
+// LoginMFA checks the authenticator or recovery code and completes the login.
+func (app *application) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "parsing mfa form", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	userID := app.Session.GetInt(r.Context(), "mfa_user_id")
+	if userID == 0 {
+		app.Session.Put(r.Context(), "error", "Log in first.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	ip := app.ipFromContext(r.Context())
+	if app.tooManyAttempts(w, r, user.Email, ip) {
+		return
+	}
+
+	if !app.verifyMFACode(r.Context(), user, form.Data.Get("code")) {
+		app.loginFailed(r.Context(), "mfa", user.Email, ip)
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	// prevent fixation attack
+	_ = app.Session.RenewToken(r.Context())
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Put(r.Context(), "user_id", user.ID)
+	app.loginSucceeded("mfa", r, user)
+
+	app.Session.Put(r.Context(), "flash", "Login Succesful")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// verifyMFACode checks code with mfa.Verify, logging errors from the database.
+func (app *application) verifyMFACode(ctx context.Context, user *data.User, code string) bool {
+	ok, err := mfa.Verify(ctx, app.DB, user, code, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "verifying mfa code", "error", err)
+		return false
+	}
+
+	return ok
+}
+
+// EnrollMFA generates a new TOTP secret for the logged in user. The profile page then
+// shows the provisioning URI until the user confirms it with a code.
+func (app *application) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromContext(r.Context())
+
+	if user.TOTPEnabled {
+		app.Session.Put(r.Context(), "error", "Two-factor authentication is already enabled")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.SetTOTPSecret(r.Context(), user.ID, secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// ConfirmMFA enables two-factor once the user proves their authenticator works, and
+// issues a fresh set of recovery codes which are shown on the profile page once.
+func (app *application) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.userFromContext(r.Context())
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	form.Check(user.TOTPSecret != "", "code", "Start two-factor setup first")
+	if form.Valid() {
+		form.Check(mfa.ValidateCode(user.TOTPSecret, form.Data.Get("code"), time.Now()), "code", "Invalid authentication code")
+	}
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", form.Errors.Get("code"))
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, mfa.HashRecoveryCode(c))
+	}
+
+	err = app.DB.ReplaceRecoveryCodes(r.Context(), user.ID, hashes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.EnableTOTP(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.Session.Put(r.Context(), "recovery_codes", codes)
+
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication enabled")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// DisableMFA turns off two-factor for the logged in user after checking a current code.
+func (app *application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.userFromContext(r.Context())
+
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	if !form.Valid() || !app.verifyMFACode(r.Context(), user, form.Data.Get("code")) {
+		app.Session.Put(r.Context(), "error", "Invalid authentication code")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.DisableTOTP(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication disabled")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
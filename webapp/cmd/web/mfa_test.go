@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mfa"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_LoginWithMFA(t *testing.T) {
+	postedData := url.Values{
+		"email":    {"mfa@example.com"},
+		"password": {"secret"},
+	}
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/login/mfa" {
+		t.Errorf("expected redirect to /login/mfa but got %s", loc)
+	}
+
+	if app.Session.Exists(req.Context(), "user_id") {
+		t.Error("user logged in before entering a code")
+	}
+
+	if app.Session.GetInt(req.Context(), "mfa_user_id") != 3 {
+		t.Error("pending mfa user not stored in session")
+	}
+}
+
+func Test_app_LoginAbandonsPendingMFA(t *testing.T) {
+	postedData := url.Values{
+		"email":    {"admin@example.com"},
+		"password": {"secret"},
+	}
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	// a two-factor login left part way through in the same browser
+	app.Session.Put(req.Context(), "mfa_user_id", 3)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/user/profile" {
+		t.Errorf("expected redirect to /user/profile but got %s", loc)
+	}
+
+	if app.Session.Exists(req.Context(), "mfa_user_id") {
+		t.Error("pending mfa user still in session")
+	}
+
+	if app.Session.GetInt(req.Context(), "user_id") != 1 {
+		t.Error("expected the user who entered their password to be logged in")
+	}
+}
+
+func Test_app_LoginMFA(t *testing.T) {
+	code, _ := mfa.GenerateCode(dbrepo.TestTOTPSecret, time.Now())
+
+	tests := []struct {
+		name        string
+		pendingUser int
+		code        string
+		expectedLoc string
+		loggedIn    bool
+	}{
+		{"valid code", 3, code, "/user/profile", true},
+		{"recovery code", 3, dbrepo.TestRecoveryCode, "/user/profile", true},
+		{"wrong code", 3, "000000", "/login/mfa", false},
+		{"missing code", 3, "", "/login/mfa", false},
+		{"no password step", 0, code, "/", false},
+		{"user without mfa", 1, code, "/login/mfa", false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		if e.pendingUser > 0 {
+			app.Session.Put(req.Context(), "mfa_user_id", e.pendingUser)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.LoginMFA).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303 but got %d", e.name, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, loc)
+		}
+
+		if app.Session.Exists(req.Context(), "user_id") != e.loggedIn {
+			t.Errorf("%s: expected logged in to be %t", e.name, e.loggedIn)
+		}
+	}
+}
+
+func Test_app_ConfirmMFA(t *testing.T) {
+	secret, _ := mfa.GenerateSecret()
+	code, _ := mfa.GenerateCode(secret, time.Now())
+
+	tests := []struct {
+		name       string
+		secret     string
+		code       string
+		expectCode bool
+	}{
+		{"valid code", secret, code, true},
+		{"wrong code", secret, "000000", false},
+		{"not enrolled", "", code, false},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/user/mfa/confirm", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		req = req.WithContext(context.WithValue(req.Context(), contextAuthUserKey, &data.User{ID: 1, TOTPSecret: e.secret}))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.ConfirmMFA).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303 but got %d", e.name, rr.Code)
+		}
+
+		codes, _ := app.Session.Get(req.Context(), "recovery_codes").([]string)
+		if e.expectCode && len(codes) != mfa.RecoveryCodeCount {
+			t.Errorf("%s: expected %d recovery codes but got %d", e.name, mfa.RecoveryCodeCount, len(codes))
+		}
+		if !e.expectCode && len(codes) != 0 {
+			t.Errorf("%s: did not expect recovery codes", e.name)
+		}
+	}
+}
@@ -2,60 +2,67 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"log/slog"
 	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/logging"
+	"webapp/pkg/tracing"
 )
 
 type contextKey string
 
 const contextUserKey contextKey = "user_ip"
 
+// contextAuthUserKey holds the logged in user, loaded by auth.
+const contextAuthUserKey contextKey = "auth_user"
+
 func (app *application) ipFromContext(ctx context.Context) string {
 	return ctx.Value(contextUserKey).(string)
 }
 
+// userFromContext returns the user auth loaded for the request, or nil outside of it.
+func (app *application) userFromContext(ctx context.Context) *data.User {
+	user, _ := ctx.Value(contextAuthUserKey).(*data.User)
+	return user
+}
+
 func (app *application) addIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//getthe ip (as accuratley as possible)
-		ip, err := getIP(r)
-		if err != nil {
-			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
+		// the client's address, looking past our own proxies
+		ip := app.ClientIP.ClientIP(r)
+		if ip == "" {
+			ip = "unknown"
 		}
 		ctx := context.WithValue(r.Context(), contextUserKey, ip)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "unknown", err
-	}
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userip: %q is not IP:port", r.RemoteAddr)
-	}
-
-	forward := r.Header.Get("X-Forwarded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-	if len(ip) == 0 {
-		ip = "forward"
-	}
-	return ip, nil
-}
-
+// auth lets through requests from a logged in user, loading them into the request's
+// context. The session only holds their id, so the user is always current and none of
+// their secrets are kept in the session store.
 func (app *application) auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !app.Session.Exists(r.Context(), "user") {
+		userID := app.Session.GetInt(r.Context(), "user_id")
+		if userID == 0 {
 			app.Session.Put(r.Context(), "error", "Log in first.")
 			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
 		}
-		next.ServeHTTP(w, r)
+
+		user, err := app.DB.GetUser(r.Context(), userID)
+		if err != nil {
+			// most likely the user has been deleted
+			slog.WarnContext(r.Context(), "loading logged in user", "user_id", userID, "error", err)
+			app.Session.Remove(r.Context(), "user_id")
+			app.Session.Put(r.Context(), "error", "Log in first.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		logging.SetUserID(r.Context(), user.ID)
+		tracing.SpanFromContext(r.Context()).SetAttributes(tracing.Attr{Key: "enduser.id", Value: user.ID})
+		ctx := context.WithValue(r.Context(), contextAuthUserKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
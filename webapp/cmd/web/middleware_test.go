@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"webapp/pkg/clientip"
 	"webapp/pkg/data"
 )
 
@@ -62,6 +63,41 @@ func Test_application_addIPToContext(t *testing.T) {
 
 }
 
+func Test_application_addIPToContext_proxies(t *testing.T) {
+	saved := app.ClientIP
+	defer func() { app.ClientIP = saved }()
+	app.ClientIP, _ = clientip.New([]string{"10.0.0.0/8"}, clientip.XForwardedFor)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"direct client cannot spoof", "203.0.113.7:4000", "1.1.1.1", "203.0.113.7"},
+		{"behind trusted proxy", "10.0.0.5:4000", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"unreadable address", "", "", "unknown"},
+	}
+
+	for _, e := range tests {
+		var ip string
+		handlerToTest := app.addIPToContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip = app.ipFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "http://testing", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		handlerToTest.ServeHTTP(httptest.NewRecorder(), req)
+
+		if ip != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, ip)
+		}
+	}
+}
+
 func Test_application_ipFromContext(t *testing.T) {
 
 	testValue := "1.1.1.1"
@@ -76,23 +112,27 @@ func Test_application_ipFromContext(t *testing.T) {
 
 var authTests = []struct {
 	name   string
+	userID int
 	isAuth bool
 }{
-	{name: "logged in", isAuth: true},
+	{name: "logged in", userID: 1, isAuth: true},
 	{name: "not logged in", isAuth: false},
+	{name: "deleted user", userID: 2, isAuth: false},
 }
 
 func Test_application_auth(t *testing.T) {
+	var seen *data.User
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
+		seen = app.userFromContext(r.Context())
 	})
 
 	for _, e := range authTests {
+		seen = nil
 		handlerToTest := app.auth(nextHandler)
 		req := httptest.NewRequest("GET", "http://testing", nil)
 		req = addContextAndSessionToRequest(req, app)
-		if e.isAuth {
-			app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		if e.userID != 0 {
+			app.Session.Put(req.Context(), "user_id", e.userID)
 		}
 		rr := httptest.NewRecorder()
 
@@ -102,9 +142,17 @@ func Test_application_auth(t *testing.T) {
 			t.Errorf("%s: expected status 200 but got %d", e.name, rr.Code)
 		}
 
+		if e.isAuth && (seen == nil || seen.ID != e.userID || seen.Email == "") {
+			t.Errorf("%s: expected the user to be loaded, got %+v", e.name, seen)
+		}
+
 		if !e.isAuth && rr.Code != http.StatusSeeOther {
 			t.Errorf("%s: expected 303 but got %d", e.name, rr.Code)
 		}
+
+		if !e.isAuth && app.Session.Exists(req.Context(), "user_id") {
+			t.Errorf("%s: expected the unknown user to be dropped from the session", e.name)
+		}
 	}
 
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/passwords"
+	"webapp/pkg/signedtoken"
+)
+
+const passwordResetPurpose = "password-reset"
+
+var passwordResetExpiry = time.Hour
+
+func (app *application) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "forgot-password.page.gohtml", &TemplateData{})
+}
+
+// ForgotPassword emails a reset link if the address belongs to an account. The response
+// is the same either way, and the mail is sent after it so that it takes the same time,
+// so it cannot be used to find out which addresses are registered.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Enter your email address")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), form.Data.Get("email"))
+	if err == nil {
+		ctx := context.WithoutCancel(r.Context())
+		app.background(func() {
+			err := app.sendPasswordReset(user)
+			if err != nil {
+				slog.ErrorContext(ctx, "sending password reset", "error", err)
+			}
+		})
+	}
+
+	app.Session.Put(r.Context(), "flash", "If that address has an account, we have sent it a link to reset the password.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) sendPasswordReset(user *data.User) error {
+	token := app.Tokens.Generate(passwordResetPurpose, user.ID, user.Password, passwordResetExpiry)
+	link := fmt.Sprintf("%s/reset-password?token=%s", app.BaseURL, url.QueryEscape(token))
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Somebody asked to reset the password for your account. If it was you, follow this link within %s:\n\n%s\n\nIf not, you can ignore this email.",
+			passwordResetExpiry, link),
+	})
+}
+
+// userForResetToken returns the user a reset token was issued to, if it is still valid.
+// Tokens are bound to the current password hash, so they stop working once used.
+func (app *application) userForResetToken(ctx context.Context, token string) (*data.User, error) {
+	userID, err := signedtoken.UserID(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.DB.GetUser(ctx, userID)
+	if err != nil {
+		return nil, signedtoken.ErrInvalidToken
+	}
+
+	err = app.Tokens.Verify(passwordResetPurpose, token, user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (app *application) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	if _, err := app.userForResetToken(r.Context(), token); err != nil {
+		app.Session.Put(r.Context(), "error", "That reset link is invalid or has expired.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "reset-password.page.gohtml", &TemplateData{Data: map[string]any{"token": token}})
+}
+
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	token := form.Data.Get("token")
+
+	user, err := app.userForResetToken(r.Context(), token)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "That reset link is invalid or has expired.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	form.Required("password", "confirm_password")
+	err = form.Password("password", app.Passwords, passwords.Owner{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "checking new password", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	form.Check(form.Data.Get("password") == form.Data.Get("confirm_password"), "confirm_password", "Passwords do not match")
+	if !form.Valid() {
+		msg := form.Errors.Get("password")
+		if msg == "" {
+			msg = form.Errors.Get("confirm_password")
+		}
+		app.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, "/reset-password?token="+url.QueryEscape(token), http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), user.ID, form.Data.Get("password"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// log the user out everywhere, including this browser
+	err = app.destroyUserSessions(r.Context(), user.ID, "")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "destroying sessions after password reset", "error", err)
+	}
+	app.Session.Remove(r.Context(), "user_id")
+	_ = app.Session.RenewToken(r.Context())
+
+	app.Session.Put(r.Context(), "flash", "Your password has been reset, please log in.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
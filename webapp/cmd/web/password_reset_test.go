@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/mailer"
+)
+
+func Test_app_ForgotPassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		expectMail bool
+	}{
+		{"known user", "admin@example.com", true},
+		{"unknown user", "nobody@example.com", false},
+	}
+
+	for _, e := range tests {
+		mail := &mailer.MemoryMailer{}
+		app.Mailer = mail
+
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.ForgotPassword).ServeHTTP(rr, req)
+		app.Background.Wait()
+
+		if loc := rr.Header().Get("Location"); loc != "/" {
+			t.Errorf("%s: expected redirect to / but got %s", e.name, loc)
+		}
+
+		msg, sent := mail.Last()
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %t", e.name, e.expectMail)
+		}
+
+		if sent && !strings.Contains(msg.Body, "http://localhost:8080/reset-password?token=") {
+			t.Errorf("%s: mail does not contain reset link: %s", e.name, msg.Body)
+		}
+	}
+}
+
+func Test_app_ResetPassword(t *testing.T) {
+	user, _ := app.DB.GetUser(context.Background(), 1)
+	token := app.Tokens.Generate(passwordResetPurpose, user.ID, user.Password, passwordResetExpiry)
+	staleToken := app.Tokens.Generate(passwordResetPurpose, user.ID, "old hash", passwordResetExpiry)
+
+	tests := []struct {
+		name        string
+		token       string
+		password    string
+		confirm     string
+		expectedLoc string
+	}{
+		{"valid", token, "A-new-password-1", "A-new-password-1", "/"},
+		{"too short", token, "Short-1", "Short-1", "/reset-password?token=" + url.QueryEscape(token)},
+		{"contains name", token, "Admin-password-1", "Admin-password-1", "/reset-password?token=" + url.QueryEscape(token)},
+		{"mismatch", token, "A-new-password-1", "Another-password-1", "/reset-password?token=" + url.QueryEscape(token)},
+		{"already used", staleToken, "A-new-password-1", "A-new-password-1", "/forgot-password"},
+		{"bad token", "nonsense", "A-new-password-1", "A-new-password-1", "/forgot-password"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"token":            {e.token},
+			"password":         {e.password},
+			"confirm_password": {e.confirm},
+		}
+		req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.ResetPassword).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303 but got %d", e.name, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLoc, loc)
+		}
+	}
+}
+
+func Test_app_ResetPasswordPage(t *testing.T) {
+	user, _ := app.DB.GetUser(context.Background(), 1)
+	token := app.Tokens.Generate(passwordResetPurpose, user.ID, user.Password, passwordResetExpiry)
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"valid", token, http.StatusOK},
+		{"invalid", "nonsense", http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/reset-password?token="+url.QueryEscape(e.token), nil)
+		req = addContextAndSessionToRequest(req, app)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.ResetPasswordPage).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_destroyUserSessions(t *testing.T) {
+	tokens := map[int]string{}
+
+	for _, id := range []int{1, 5} {
+		ctx, _ := app.Session.Load(context.Background(), "")
+		app.Session.Put(ctx, "user_id", id)
+		token, _, err := app.Session.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[id] = token
+	}
+
+	err := app.destroyUserSessions(context.Background(), 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := app.Session.Load(context.Background(), tokens[1])
+	if app.Session.Exists(ctx, "user_id") {
+		t.Error("session for user 1 still exists")
+	}
+
+	ctx, _ = app.Session.Load(context.Background(), tokens[5])
+	if !app.Session.Exists(ctx, "user_id") {
+		t.Error("session for another user was destroyed")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+	"webapp/pkg/health"
+	"webapp/pkg/logging"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,21 +13,56 @@ func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// register middleware
+	mux.Use(app.Tracer.Middleware)
+	mux.Use(app.ClientIP.Middleware)
+	mux.Use(logging.RequestID)
+	mux.Use(logging.AccessLog(app.Logger))
 	mux.Use(middleware.Recoverer)
-	mux.Use(app.addIPToContext)
-	mux.Use(app.Session.LoadAndSave)
+	mux.Use(app.Metrics.HTTP.Middleware)
 
+	// health checks don't need, or touch, sessions
+	checks := app.healthChecks()
+	mux.Get("/healthz", checks.Healthz)
+	mux.Get("/readyz", checks.Readyz)
+	mux.Get("/version", health.Version)
+	mux.Method("GET", "/metrics", app.Metrics.Registry.Handler())
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.addIPToContext)
+		mux.Use(app.Session.LoadAndSave)
+		app.pageRoutes(mux)
+	})
+
+	return mux
+}
+
+func (app *application) pageRoutes(mux chi.Router) {
 	// register routes
 	mux.Get("/", app.Home)
 	mux.Post("/login", app.Login)
+	mux.Get("/login/mfa", app.MFAPage)
+	mux.Post("/login/mfa", app.LoginMFA)
+	mux.Get("/forgot-password", app.ForgotPasswordPage)
+	mux.Post("/forgot-password", app.ForgotPassword)
+	mux.Get("/reset-password", app.ResetPasswordPage)
+	mux.Post("/reset-password", app.ResetPassword)
+	mux.Get("/signup", app.SignupPage)
+	mux.Post("/signup", app.Signup)
+	mux.Get("/verify-email", app.VerifyEmail)
+	mux.Post("/verify-email/resend", app.ResendVerification)
 
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
 		mux.Get("/profile", app.Profile)
+		mux.Post("/logout", app.Logout)
 		mux.Post("/upload-profile-image", app.UploadProfilePicture)
+		mux.Post("/mfa/enroll", app.EnrollMFA)
+		mux.Post("/mfa/confirm", app.ConfirmMFA)
+		mux.Post("/mfa/disable", app.DisableMFA)
+		mux.Post("/sessions/{sessionID}/revoke", app.RevokeSession)
+		mux.Post("/sessions/revoke-others", app.RevokeOtherSessions)
 	})
 	// static assets
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
-	return mux
 }
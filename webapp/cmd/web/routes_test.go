@@ -13,9 +13,29 @@ var registered = []struct {
 	method string
 }{
 	{route: "/", method: "GET"},
+	{route: "/healthz", method: "GET"},
+	{route: "/readyz", method: "GET"},
+	{route: "/version", method: "GET"},
+	{route: "/metrics", method: "GET"},
 	{route: "/static/*", method: "GET"},
 	{route: "/login", method: "POST"},
+	{route: "/login/mfa", method: "GET"},
+	{route: "/login/mfa", method: "POST"},
+	{route: "/forgot-password", method: "GET"},
+	{route: "/forgot-password", method: "POST"},
+	{route: "/reset-password", method: "GET"},
+	{route: "/reset-password", method: "POST"},
+	{route: "/signup", method: "GET"},
+	{route: "/signup", method: "POST"},
+	{route: "/verify-email", method: "GET"},
+	{route: "/verify-email/resend", method: "POST"},
 	{route: "/user/profile", method: "GET"},
+	{route: "/user/logout", method: "POST"},
+	{route: "/user/mfa/enroll", method: "POST"},
+	{route: "/user/mfa/confirm", method: "POST"},
+	{route: "/user/mfa/disable", method: "POST"},
+	{route: "/user/sessions/{sessionID}/revoke", method: "POST"},
+	{route: "/user/sessions/revoke-others", method: "POST"},
 }
 
 func Test_application_routes(t *testing.T) {
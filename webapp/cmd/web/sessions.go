@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
+	"webapp/pkg/data"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
 )
 
 func getSession() *scs.SessionManager {
@@ -16,3 +21,112 @@ func getSession() *scs.SessionManager {
 
 	return session
 }
+
+// trackSession adds the current session to the user's index of logged in sessions. It
+// must be called after the token has been renewed for the login.
+func (app *application) trackSession(r *http.Request, userID int) {
+	err := app.DB.InsertUserSession(r.Context(), data.UserSession{
+		UserID:    userID,
+		Token:     app.Session.Token(r.Context()),
+		IP:        app.ipFromContext(r.Context()),
+		UserAgent: r.UserAgent(),
+		ExpiresAt: time.Now().Add(app.Session.Lifetime),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "tracking session", "error", err)
+	}
+}
+
+// destroyUserSessions logs a user out everywhere but the session with keepToken, which may
+// be empty, by destroying every session that holds them, including any that are part way
+// through a two-factor login.
+func (app *application) destroyUserSessions(ctx context.Context, userID int, keepToken string) error {
+	tokens, err := app.DB.DeleteOtherUserSessions(ctx, userID, keepToken)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err = app.Session.Store.Delete(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	// sessions from before the index existed, or not yet past two-factor, are not indexed
+	return app.Session.Iterate(ctx, func(ctx context.Context) error {
+		if keepToken != "" && app.Session.Token(ctx) == keepToken {
+			return nil
+		}
+		if app.Session.GetInt(ctx, "user_id") == userID || app.Session.GetInt(ctx, "mfa_user_id") == userID {
+			return app.Session.Destroy(ctx)
+		}
+		return nil
+	})
+}
+
+// currentSessionID returns the index id of the session making the request, or zero.
+func (app *application) currentSessionID(r *http.Request, userID int) int {
+	sessions, err := app.DB.AllUserSessions(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "listing sessions", "error", err)
+		return 0
+	}
+
+	token := app.Session.Token(r.Context())
+	for _, s := range sessions {
+		if s.Token == token {
+			return s.ID
+		}
+	}
+	return 0
+}
+
+// RevokeSession logs out one of the user's other sessions, listed on their profile.
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromContext(r.Context())
+
+	id, err := strconv.Atoi(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if id == app.currentSessionID(r, user.ID) {
+		app.Session.Put(r.Context(), "error", "Use log out to end the session you are using.")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	token, err := app.DB.DeleteUserSession(r.Context(), user.ID, id)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "That session has already ended.")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	err = app.Session.Store.Delete(token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "destroying revoked session", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "That session has been logged out.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// RevokeOtherSessions logs the user out of every session except this one.
+func (app *application) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromContext(r.Context())
+
+	err := app.destroyUserSessions(r.Context(), user.ID, app.Session.Token(r.Context()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "destroying other sessions", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "You have been logged out of all other devices.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
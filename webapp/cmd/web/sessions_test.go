@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// storeSession commits a session for user 1 straight to the store under token, as if
+// they had logged in on another device.
+func storeSession(t *testing.T, token string) {
+	b, err := app.Session.Codec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"user_id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Session.Store.Commit(token, b, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sessionExists(token string) bool {
+	_, found, _ := app.Session.Store.Find(token)
+	return found
+}
+
+func Test_app_loginTracksSession(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	app.DB = db
+
+	postedData := url.Values{
+		"email":    {"admin@example.com"},
+		"password": {"secret"},
+	}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Firefox")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if len(db.Sessions) != 1 {
+		t.Fatalf("expected one tracked session but got %d", len(db.Sessions))
+	}
+
+	s := db.Sessions[0]
+	if s.UserID != 1 || s.UserAgent != "Firefox" || s.IP != "unknown" {
+		t.Errorf("unexpected session %+v", s)
+	}
+	if s.Token == "" || s.Token != app.Session.Token(req.Context()) {
+		t.Error("expected the renewed session token to be tracked")
+	}
+}
+
+func Test_app_ProfileSessions(t *testing.T) {
+	storeSession(t, dbrepo.TestSessionToken)
+
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req.Header.Set("X-Session", dbrepo.TestSessionToken)
+	req = addContextAndSessionToRequest(req, app)
+
+	rr := httptest.NewRecorder()
+	app.auth(http.HandlerFunc(app.Profile)).ServeHTTP(rr, req)
+
+	for _, s := range []string{"198.51.100.7", "Safari", "This device", "/user/sessions/2/revoke"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected profile to contain %q", s)
+		}
+	}
+	if strings.Contains(rr.Body.String(), "/user/sessions/1/revoke") {
+		t.Error("did not expect a log out button for the current session")
+	}
+}
+
+func Test_app_RevokeSession(t *testing.T) {
+	tests := []struct {
+		name          string
+		sessionID     string
+		expectRevoked bool
+		expectedError string
+	}{
+		{"other device", "2", true, ""},
+		{"this device", "1", false, "Use log out"},
+		{"unknown session", "99", false, "already ended"},
+	}
+
+	for _, e := range tests {
+		storeSession(t, dbrepo.TestSessionToken)
+		storeSession(t, "test-session-2")
+
+		req, _ := http.NewRequest("POST", "/user/sessions/"+e.sessionID+"/revoke", nil)
+		req.Header.Set("X-Session", dbrepo.TestSessionToken)
+		req = addContextAndSessionToRequest(req, app)
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("sessionID", e.sessionID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		app.auth(http.HandlerFunc(app.RevokeSession)).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303 but got %d", e.name, rr.Code)
+		}
+
+		if revoked := !sessionExists("test-session-2"); revoked != e.expectRevoked {
+			t.Errorf("%s: expected other session revoked %v but got %v", e.name, e.expectRevoked, revoked)
+		}
+
+		if !sessionExists(dbrepo.TestSessionToken) {
+			t.Errorf("%s: current session was destroyed", e.name)
+		}
+
+		if msg := app.Session.GetString(req.Context(), "error"); !strings.Contains(msg, e.expectedError) || (e.expectedError == "") != (msg == "") {
+			t.Errorf("%s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func Test_app_RevokeOtherSessions(t *testing.T) {
+	storeSession(t, dbrepo.TestSessionToken)
+	storeSession(t, "test-session-2")
+	// not in the index, e.g. from before it existed
+	storeSession(t, "unindexed-session")
+
+	req, _ := http.NewRequest("POST", "/user/sessions/revoke-others", nil)
+	req.Header.Set("X-Session", dbrepo.TestSessionToken)
+	req = addContextAndSessionToRequest(req, app)
+
+	rr := httptest.NewRecorder()
+	app.auth(http.HandlerFunc(app.RevokeOtherSessions)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status 303 but got %d", rr.Code)
+	}
+
+	if !sessionExists(dbrepo.TestSessionToken) {
+		t.Error("current session was destroyed")
+	}
+
+	for _, token := range []string{"test-session-2", "unindexed-session"} {
+		if sessionExists(token) {
+			t.Errorf("session %s still exists", token)
+		}
+	}
+}
+
+func Test_app_Logout(t *testing.T) {
+	storeSession(t, dbrepo.TestSessionToken)
+	storeSession(t, "test-session-2")
+
+	req, _ := http.NewRequest("POST", "/user/logout", nil)
+	req.Header.Set("X-Session", dbrepo.TestSessionToken)
+	req = addContextAndSessionToRequest(req, app)
+
+	rr := httptest.NewRecorder()
+	app.auth(http.HandlerFunc(app.Logout)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Errorf("expected redirect to / but got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	if sessionExists(dbrepo.TestSessionToken) {
+		t.Error("logged out session still exists")
+	}
+	if !sessionExists("test-session-2") {
+		t.Error("session on another device was destroyed")
+	}
+
+	token := app.Session.Token(req.Context())
+	if token == "" || token == dbrepo.TestSessionToken {
+		t.Error("expected the session token to be rotated")
+	}
+	if app.Session.Exists(req.Context(), "user_id") {
+		t.Error("user still in session after logout")
+	}
+}
@@ -1,19 +1,37 @@
 package main
 
 import (
+	"io"
 	"os"
+	"sync"
 	"testing"
+	"webapp/pkg/logging"
+	"webapp/pkg/mailer"
+	"webapp/pkg/metrics"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/signedtoken"
+	"webapp/pkg/throttle"
+	"webapp/pkg/tracing"
 )
 
 var app application
 
 func TestMain(m *testing.M) {
-
 	app.Session = getSession()
 	pathToTemplates = "./../../templates/"
 
 	app.DB = &dbrepo.TestDBRepo{}
+	app.Metrics = metrics.NewService()
+	app.Logger, _ = logging.New(io.Discard, "text", "error")
+	app.Tracer = tracing.New("test", nil)
+	app.Throttle = throttle.New(throttle.NewMemoryStore())
+	app.Mailer = &mailer.MemoryMailer{}
+	app.Background = &sync.WaitGroup{}
+	app.Tokens = signedtoken.New("test_secret")
+	app.BaseURL = "http://localhost:8080"
+	app.Passwords = passwords.DefaultPolicy()
+	app.Hasher = passwords.NewBcrypt(passwords.DefaultBcryptCost)
 
 	os.Exit(m.Run())
 }
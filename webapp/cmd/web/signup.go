@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/passwords"
+	"webapp/pkg/signedtoken"
+)
+
+const emailVerificationPurpose = "verify-email"
+
+var emailVerificationExpiry = time.Hour * 24
+
+func (app *application) SignupPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "signup.page.gohtml", &TemplateData{Form: NewForm(nil)})
+}
+
+func (app *application) Signup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Validate("first_name", "required,max=255")
+	form.Validate("last_name", "required,max=255")
+	form.Validate("email", "required,email,max=255")
+	form.Required("password", "confirm_password")
+	err = form.Password("password", app.Passwords, passwords.Owner{
+		Email:     form.Data.Get("email"),
+		FirstName: form.Data.Get("first_name"),
+		LastName:  form.Data.Get("last_name"),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "checking signup password", "error", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	form.Check(form.Data.Get("password") == form.Data.Get("confirm_password"), "confirm_password", "Passwords do not match")
+
+	if form.Errors.Get("email") == "" {
+		if _, err := app.DB.GetUserByEmail(r.Context(), form.Data.Get("email")); err == nil {
+			form.Errors.Add("email", "An account with this email address already exists")
+		}
+	}
+
+	if !form.Valid() {
+		_ = app.render(w, r, "signup.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	user := data.User{
+		FirstName: form.Data.Get("first_name"),
+		LastName:  form.Data.Get("last_name"),
+		Email:     form.Data.Get("email"),
+		Password:  form.Data.Get("password"),
+	}
+
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = app.sendEmailVerification(&user)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sending email verification", "error", err)
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your account has been created. Check your email for a link to verify your address.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// emailVerificationBinding ties a verification token to the address it was sent to and
+// to the user still being unverified, so that it stops working once used.
+func emailVerificationBinding(user *data.User) string {
+	return user.Email + "|" + user.EmailVerifiedAt.UTC().Format(time.RFC3339)
+}
+
+func (app *application) sendEmailVerification(user *data.User) error {
+	token := app.Tokens.Generate(emailVerificationPurpose, user.ID, emailVerificationBinding(user), emailVerificationExpiry)
+	link := fmt.Sprintf("%s/verify-email?token=%s", app.BaseURL, url.QueryEscape(token))
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Thanks for signing up. Follow this link within %s to verify your email address:\n\n%s", emailVerificationExpiry, link),
+	})
+}
+
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	user, err := app.userForVerificationToken(r.Context(), token)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "That verification link is invalid or has expired.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.DB.VerifyEmail(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.Session.Remove(r.Context(), "unverified_email")
+	app.Session.Put(r.Context(), "flash", "Your email address has been verified, please log in.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) userForVerificationToken(ctx context.Context, token string) (*data.User, error) {
+	userID, err := signedtoken.UserID(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.DB.GetUser(ctx, userID)
+	if err != nil {
+		return nil, signedtoken.ErrInvalidToken
+	}
+
+	err = app.Tokens.Verify(emailVerificationPurpose, token, emailVerificationBinding(user))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ResendVerification sends a fresh link to the address that last failed to log in because it
+// was unverified. Only addresses that got past the password check are stored in the session,
+// so this cannot be used to send mail to arbitrary addresses.
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	email := app.Session.PopString(r.Context(), "unverified_email")
+	if email == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
+	if err == nil && user.EmailVerifiedAt.IsZero() {
+		err = app.sendEmailVerification(user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "resending email verification", "error", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "We have sent you a new verification link.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/mailer"
+)
+
+func Test_app_Signup(t *testing.T) {
+	valid := url.Values{
+		"first_name":       {"Jack"},
+		"last_name":        {"Smith"},
+		"email":            {"jack@example.com"},
+		"password":         {"A-long-password-1"},
+		"confirm_password": {"A-long-password-1"},
+	}
+
+	with := func(field, value string) url.Values {
+		v := url.Values{}
+		for k, vals := range valid {
+			v[k] = vals
+		}
+		v.Set(field, value)
+		return v
+	}
+
+	tests := []struct {
+		name           string
+		postedData     url.Values
+		expectedStatus int
+		expectedHTML   string
+		expectMail     bool
+	}{
+		{"valid", valid, http.StatusSeeOther, "", true},
+		{"missing name", with("first_name", ""), http.StatusOK, "This field cannot be blank", false},
+		{"bad email", with("email", "jack"), http.StatusOK, "Enter a valid email address", false},
+		{"taken email", with("email", "admin@example.com"), http.StatusOK, "already exists", false},
+		{"short password", with("password", "Short-1"), http.StatusOK, "at least 10 characters", false},
+		{"weak password", with("password", "alllowercaseletters"), http.StatusOK, "at least 3 of", false},
+		{"breached password", with("password", "Password123"), http.StatusOK, "appeared in a data breach", false},
+		{"mismatched passwords", with("confirm_password", "something-else"), http.StatusOK, "Passwords do not match", false},
+	}
+
+	for _, e := range tests {
+		mail := &mailer.MemoryMailer{}
+		app.Mailer = mail
+
+		req, _ := http.NewRequest("POST", "/signup", strings.NewReader(e.postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Signup).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		body, _ := io.ReadAll(rr.Body)
+		if !strings.Contains(string(body), e.expectedHTML) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedHTML)
+		}
+
+		msg, sent := mail.Last()
+		if sent != e.expectMail {
+			t.Errorf("%s: expected mail sent to be %t", e.name, e.expectMail)
+		}
+		if sent && !strings.Contains(msg.Body, "/verify-email?token=") {
+			t.Errorf("%s: mail does not contain a verification link", e.name)
+		}
+	}
+}
+
+func Test_app_loginUnverified(t *testing.T) {
+	postedData := url.Values{
+		"email":    {"unverified@example.com"},
+		"password": {"secret"},
+	}
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/" {
+		t.Errorf("expected redirect to / but got %s", loc)
+	}
+
+	if app.Session.Exists(req.Context(), "user_id") {
+		t.Error("unverified user was logged in")
+	}
+
+	if app.Session.GetString(req.Context(), "unverified_email") != "unverified@example.com" {
+		t.Error("unverified email not stored for resending")
+	}
+
+	// the home page should now offer to resend the link
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.Home).ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "/verify-email/resend") {
+		t.Error("home page does not offer to resend verification")
+	}
+
+	mail := &mailer.MemoryMailer{}
+	app.Mailer = mail
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.ResendVerification).ServeHTTP(rr, req)
+
+	if msg, sent := mail.Last(); !sent || msg.To != "unverified@example.com" {
+		t.Error("verification email was not resent")
+	}
+}
+
+func Test_app_VerifyEmail(t *testing.T) {
+	user, _ := app.DB.GetUser(context.Background(), 4)
+	token := app.Tokens.Generate(emailVerificationPurpose, user.ID, emailVerificationBinding(user), emailVerificationExpiry)
+
+	// a token for a user that has already verified no longer matches
+	verified, _ := app.DB.GetUser(context.Background(), 1)
+	usedToken := app.Tokens.Generate(emailVerificationPurpose, verified.ID, "admin@example.com|0001-01-01T00:00:00Z", emailVerificationExpiry)
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedFlash bool
+	}{
+		{"valid", token, true},
+		{"already verified", usedToken, false},
+		{"bad token", "nonsense", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/verify-email?token="+url.QueryEscape(e.token), nil)
+		req = addContextAndSessionToRequest(req, app)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.VerifyEmail).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status 303 but got %d", e.name, rr.Code)
+		}
+
+		if app.Session.Exists(req.Context(), "flash") != e.expectedFlash {
+			t.Errorf("%s: expected success flash to be %t", e.name, e.expectedFlash)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Keys look like wa_<prefix>_<secret>. The prefix is stored in the clear so keys can be
+// looked up and recognized in lists; only a hash of the whole key is kept.
+const (
+	keyTag       = "wa"
+	prefixBytes  = 4
+	secretBytes  = 24
+	keySeparator = "_"
+)
+
+// Scopes limit what a key may do on behalf of its owner.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// ErrMalformedKey is returned for strings that cannot be one of our keys.
+var ErrMalformedKey = errors.New("malformed api key")
+
+// Generate returns a new key, its visible prefix and the hash to store.
+func Generate() (key, prefix, hash string, err error) {
+	p := make([]byte, prefixBytes)
+	s := make([]byte, secretBytes)
+	if _, err = rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(s); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = keyTag + keySeparator + hex.EncodeToString(p)
+	key = prefix + keySeparator + hex.EncodeToString(s)
+
+	return key, prefix, Hash(key), nil
+}
+
+// Prefix returns the visible prefix of key.
+func Prefix(key string) (string, error) {
+	parts := strings.Split(key, keySeparator)
+	if len(parts) != 3 || parts[0] != keyTag || len(parts[1]) != prefixBytes*2 || len(parts[2]) != secretBytes*2 {
+		return "", ErrMalformedKey
+	}
+	return parts[0] + keySeparator + parts[1], nil
+}
+
+// Hash returns the stored form of key. Keys are long and random, so a fast hash is enough.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether key hashes to hash, in constant time.
+func Matches(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(hash)) == 1
+}
+
+// ValidScope reports whether scope is one we know about.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
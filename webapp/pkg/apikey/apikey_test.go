@@ -0,0 +1,55 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("expected key %s to start with prefix %s", key, prefix)
+	}
+
+	p, err := Prefix(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != prefix {
+		t.Errorf("expected prefix %s but got %s", prefix, p)
+	}
+
+	if !Matches(key, hash) {
+		t.Error("expected key to match its hash")
+	}
+
+	other, _, _, _ := Generate()
+	if Matches(other, hash) {
+		t.Error("did not expect a different key to match")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"valid", "wa_0123abcd_" + strings.Repeat("ab", secretBytes), true},
+		{"wrong tag", "xx_0123abcd_" + strings.Repeat("ab", secretBytes), false},
+		{"short secret", "wa_0123abcd_abcd", false},
+		{"no separators", "wa0123abcd", false},
+		{"empty", "", false},
+	}
+
+	for _, e := range tests {
+		_, err := Prefix(e.key)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %v but got error %v", e.name, e.valid, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package clientip works out which address a request came from when the servers sit behind
+// reverse proxies, believing forwarding headers only as far as they were written by proxies
+// we trust.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers a Resolver can read the forwarding chain from.
+const (
+	XForwardedFor = "X-Forwarded-For"
+	Forwarded     = "Forwarded"
+)
+
+// Resolver finds client addresses. A nil Resolver trusts no proxies, so the client is
+// always the peer that connected.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// New returns a Resolver trusting the proxies in the listed CIDRs, or single addresses, to
+// append the address they were connected from to header, which is X-Forwarded-For or
+// Forwarded. Only one header is read: a proxy that appends to one passes the other on as
+// the client sent it.
+func New(proxies []string, header string) (*Resolver, error) {
+	header = http.CanonicalHeaderKey(header)
+	if header != XForwardedFor && header != Forwarded {
+		return nil, fmt.Errorf("clientip: unsupported header %q", header)
+	}
+
+	res := &Resolver{header: header}
+	for _, p := range proxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		res.trusted = append(res.trusted, prefix)
+	}
+	return res, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("clientip: bad proxy range %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("clientip: bad proxy address %q: %w", s, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	if res == nil {
+		return false
+	}
+	for _, p := range res.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// Middleware resolves the client address once per request, for ClientIP and FromContext.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKey{}, res.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// FromContext returns the address Middleware found, or "" outside it.
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(contextKey{}).(string)
+	return ip
+}
+
+// ClientIP returns the address of the client that sent r, as found by Middleware or worked
+// out now if it did not run. It is "" when not even the peer's address can be read.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+		return ip
+	}
+	return res.resolve(r)
+}
+
+// resolve walks the forwarding chain from the peer back towards the client, stopping at the
+// first hop not added by a trusted proxy. Anything to the left of it could have been made up
+// by the client. A hop that is not a valid address also stops the walk, at the proxy that
+// passed it on, since whatever sent it cannot be identified.
+func (res *Resolver) resolve(r *http.Request) string {
+	client, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !res.isTrusted(client) {
+		return client.String()
+	}
+
+	hops := res.hops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// hops lists the forwarded addresses in the order the proxies added them, across all
+// occurrences of the header.
+func (res *Resolver) hops(h http.Header) []string {
+	var hops []string
+	for _, line := range h.Values(res.header) {
+		for _, hop := range strings.Split(line, ",") {
+			if res.header == Forwarded {
+				hop = forwardedFor(hop)
+			}
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the for parameter of one RFC 7239 forwarded-element, such as
+// for=192.0.2.60;proto=http;by=203.0.113.43, or "" if it has none.
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, "for") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseAddr reads an address with or without a port, IPv6 ones bracketed when there is a
+// port, as found in RemoteAddr and in both forwarding headers. Obfuscated identifiers and
+// "unknown", which RFC 7239 allows, are not addresses.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return addr.Unmap().WithZone(""), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,91 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_ClientIP(t *testing.T) {
+	xff, err := New([]string{"10.0.0.0/8", "192.0.2.1", "fd00::/8"}, "x-forwarded-for")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd, err := New([]string{"10.0.0.0/8"}, "Forwarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		resolver   *Resolver
+		remoteAddr string
+		header     string
+		values     []string
+		expected   string
+	}{
+		{"no proxies", nil, "203.0.113.7:4000", "X-Forwarded-For", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer", xff, "203.0.113.7:4000", "X-Forwarded-For", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"one proxy", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed chain", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"198.51.100.1, 192.0.2.1", "10.1.1.1"}, "198.51.100.1"},
+		{"only proxies", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"10.2.2.2"}, "10.2.2.2"},
+		{"no header", xff, "10.0.0.5:4000", "", nil, "10.0.0.5"},
+		{"garbage hop", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"198.51.100.1, not-an-ip"}, "10.0.0.5"},
+		{"hop with port", xff, "10.0.0.5:4000", "X-Forwarded-For", []string{"198.51.100.1:5555"}, "198.51.100.1"},
+		{"ipv6 peer", xff, "[fd00::1]:4000", "X-Forwarded-For", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"mapped ipv4", xff, "[::ffff:10.0.0.5]:4000", "X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"other header ignored", xff, "10.0.0.5:4000", "Forwarded", []string{"for=198.51.100.1"}, "10.0.0.5"},
+		{"forwarded", fwd, "10.0.0.5:4000", "Forwarded", []string{`for=198.51.100.1;proto=https, for="[2001:db8::17]:4711";by=10.0.0.5`}, "2001:db8::17"},
+		{"forwarded through proxy", fwd, "10.0.0.5:4000", "Forwarded", []string{"for=198.51.100.1", "For=10.3.3.3"}, "198.51.100.1"},
+		{"forwarded obfuscated", fwd, "10.0.0.5:4000", "Forwarded", []string{"for=_hidden"}, "10.0.0.5"},
+		{"forwarded unknown", fwd, "10.0.0.5:4000", "Forwarded", []string{"for=unknown"}, "10.0.0.5"},
+		{"bad remote addr", xff, "hello:world", "", nil, ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for _, v := range e.values {
+			req.Header.Add(e.header, v)
+		}
+
+		if ip := e.resolver.ClientIP(req); ip != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, ip)
+		}
+	}
+}
+
+func TestNew_errors(t *testing.T) {
+	if _, err := New([]string{"10.0.0.0/33"}, XForwardedFor); err == nil {
+		t.Error("expected a bad range to be refused")
+	}
+	if _, err := New([]string{"proxy.internal"}, XForwardedFor); err == nil {
+		t.Error("expected a host name to be refused")
+	}
+	if _, err := New(nil, "X-Real-IP"); err == nil {
+		t.Error("expected an unsupported header to be refused")
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	res, _ := New([]string{"10.0.0.0/8"}, XForwardedFor)
+
+	var fromContext, fromRequest string
+	handler := res.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = FromContext(r.Context())
+		fromRequest = res.ClientIP(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.5:4000"
+	req.Header.Set(XForwardedFor, "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if fromContext != "198.51.100.1" || fromRequest != fromContext {
+		t.Errorf("expected the client in the context, got %q and %q", fromContext, fromRequest)
+	}
+	if FromContext(req.Context()) != "" {
+		t.Error("expected nothing outside the middleware")
+	}
+}
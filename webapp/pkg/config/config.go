@@ -0,0 +1,404 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"webapp/pkg/passwords"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Modes the binaries can run in. Production refuses unsafe defaults.
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// DefaultSecret and DefaultDSN only suit a local development database.
+const (
+	DefaultSecret = "oh_my_how_secret_this_is"
+	DefaultDSN    = "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5"
+)
+
+// minProductionSecretLength is the shortest secret accepted in production.
+const minProductionSecretLength = 32
+
+// Config is the configuration shared by the web, api and cli binaries. Each uses the top
+// level settings and its own section. Fields tagged secret are redacted when printed.
+type Config struct {
+	Env string `yaml:"env"`
+	DSN string `yaml:"dsn" secret:"dsn"`
+	// Secret signs access tokens and emailed links. The web and api must share it, since
+	// links sent by one are opened in the other.
+	Secret            string `yaml:"secret" secret:"true"`
+	BcryptCost        int    `yaml:"bcrypt_cost"`
+	BreachedPasswords string `yaml:"breached_passwords"`
+	ThrottleStore     string `yaml:"throttle_store"`
+	MailDir           string `yaml:"mail_dir"`
+
+	Log     Log     `yaml:"log"`
+	Tracing Tracing `yaml:"tracing"`
+	Server  Server  `yaml:"server"`
+	Web     Web     `yaml:"web"`
+	API     API     `yaml:"api"`
+}
+
+// Log configures the structured logs every binary writes to stderr.
+type Log struct {
+	// Level is the least severe level written: debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is json, for log collectors, or text, for reading in a terminal.
+	Format string `yaml:"format"`
+}
+
+// Tracing configures where request and query spans are sent.
+type Tracing struct {
+	// Exporter is none, stdout, file or otlp.
+	Exporter string `yaml:"exporter"`
+	// File is appended to by the file exporter.
+	File string `yaml:"file"`
+	// Endpoint is a collector's OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Server holds the timeouts both servers use. Durations are written like 30s or 2m.
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests get to finish once asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the CIDRs, or single addresses, of the reverse proxies in front of
+	// the servers. Only hops they added to ClientIPHeader are believed when working out the
+	// client's address for logs, rate limits and lockouts.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// ClientIPHeader is the header those proxies append to: X-Forwarded-For or Forwarded.
+	ClientIPHeader string `yaml:"client_ip_header"`
+}
+
+// Web holds settings only the web binary uses.
+type Web struct {
+	Port         int    `yaml:"port"`
+	BaseURL      string `yaml:"base_url"`
+	MFAIssuer    string `yaml:"mfa_issuer"`
+	SessionStore string `yaml:"session_store"`
+	TLS          TLS    `yaml:"tls"`
+}
+
+// API holds settings only the api binary uses.
+type API struct {
+	Port            int        `yaml:"port"`
+	Domain          string     `yaml:"domain"`
+	ResetURL        string     `yaml:"reset_url"`
+	RevocationStore string     `yaml:"revocation_store"`
+	CookieDomain    string     `yaml:"cookie_domain"`
+	CookieSecure    bool       `yaml:"cookie_secure"`
+	CORSFile        string     `yaml:"cors_file"`
+	RateLimit       RateLimits `yaml:"rate_limit"`
+	TLS             TLS        `yaml:"tls"`
+}
+
+// RateLimits configures the api's per user, or per client IP, request limits.
+type RateLimits struct {
+	Enabled bool `yaml:"enabled"`
+	// Store keeps the buckets; only memory for now, which limits each instance separately.
+	Store string `yaml:"store"`
+	// Default applies to every route without a stricter limit.
+	Default RateLimit `yaml:"default"`
+	// Auth applies to the routes that check passwords or issue tokens.
+	Auth RateLimit `yaml:"auth"`
+}
+
+// RateLimit allows Requests per Period on average, in bursts of up to Burst, which is
+// Requests if zero.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+func (l RateLimit) validate(name string) []string {
+	if l.Requests < 1 || l.Period <= 0 || l.Burst < 0 {
+		return []string{name + " needs positive requests and period, and a burst that is not negative"}
+	}
+	return nil
+}
+
+// TLS configures HTTPS for one server. Certificates are read from CertFile and KeyFile,
+// and read again when the files change, or generated at startup with SelfSigned.
+type TLS struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	SelfSigned bool   `yaml:"self_signed"`
+	// RedirectPort, if set, serves plain HTTP there that redirects to HTTPS.
+	RedirectPort int `yaml:"redirect_port"`
+}
+
+// Enabled reports whether the server should use HTTPS.
+func (t TLS) Enabled() bool {
+	return t.SelfSigned || t.CertFile != ""
+}
+
+// Flags registers flags for t.
+func (t *TLS) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&t.CertFile, "tls-cert", t.CertFile, "certificate file to serve HTTPS with; reloaded when it changes")
+	fs.StringVar(&t.KeyFile, "tls-key", t.KeyFile, "private key file for -tls-cert")
+	fs.BoolVar(&t.SelfSigned, "tls-self-signed", t.SelfSigned, "serve HTTPS with a generated self-signed certificate, for development")
+	fs.IntVar(&t.RedirectPort, "http-redirect-port", t.RedirectPort, "also listen for plain HTTP on this port and redirect it to HTTPS")
+}
+
+func (t TLS) validate(name string, env string) []string {
+	var problems []string
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		problems = append(problems, name+".tls needs both cert_file and key_file")
+	}
+	if t.SelfSigned && t.CertFile != "" {
+		problems = append(problems, name+".tls cannot use a certificate file and self_signed together")
+	}
+	if t.SelfSigned && env == Production {
+		problems = append(problems, "refusing to use a self-signed certificate in production")
+	}
+	if t.RedirectPort != 0 && !t.Enabled() {
+		problems = append(problems, name+".tls.redirect_port needs a certificate")
+	}
+	if t.RedirectPort < 0 || t.RedirectPort > 65535 {
+		problems = append(problems, fmt.Sprintf("%s.tls.redirect_port %d is out of range", name, t.RedirectPort))
+	}
+
+	return problems
+}
+
+// Default returns the settings used for anything that is not configured.
+func Default() Config {
+	return Config{
+		Env:           Development,
+		DSN:           DefaultDSN,
+		Secret:        DefaultSecret,
+		BcryptCost:    passwords.DefaultBcryptCost,
+		ThrottleStore: "memory",
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		Server: Server{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+			TrustedProxies:    []string{},
+			ClientIPHeader:    "X-Forwarded-For",
+		},
+		Web: Web{
+			Port:         8080,
+			BaseURL:      "http://localhost:8080",
+			MFAIssuer:    "webapp",
+			SessionStore: "postgres",
+		},
+		API: API{
+			Port:            8090,
+			Domain:          "example.com",
+			ResetURL:        "http://localhost:8080/reset-password",
+			RevocationStore: "memory",
+			CookieSecure:    true,
+			RateLimit: RateLimits{
+				Enabled: true,
+				Store:   "memory",
+				Default: RateLimit{Requests: 300, Period: time.Minute, Burst: 60},
+				Auth:    RateLimit{Requests: 10, Period: time.Minute, Burst: 5},
+			},
+		},
+	}
+}
+
+// SharedFlags registers flags for the settings both servers use.
+func (c *Config) SharedFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Env, "env", c.Env, "mode to run in: development|production")
+	fs.StringVar(&c.DSN, "dsn", c.DSN, "Postgres Connection")
+	fs.StringVar(&c.ThrottleStore, "throttle-store", c.ThrottleStore, "where to track failed logins: memory|db")
+	fs.StringVar(&c.MailDir, "mail-dir", c.MailDir, "write outgoing mail to files in this directory instead of the log")
+	fs.StringVar(&c.BreachedPasswords, "breached-passwords", c.BreachedPasswords, "hash list file or range directory of breached passwords, instead of the bundled list")
+	fs.IntVar(&c.BcryptCost, "bcrypt-cost", c.BcryptCost, "bcrypt cost for new password hashes; older hashes are upgraded at login")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "least severe log level written: debug|info|warn|error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: json|text")
+	fs.StringVar(&c.Tracing.Exporter, "trace-exporter", c.Tracing.Exporter, "where to send trace spans: none|stdout|file|otlp")
+	fs.StringVar(&c.Tracing.File, "trace-file", c.Tracing.File, "file the file trace exporter appends to")
+	fs.StringVar(&c.Tracing.Endpoint, "trace-endpoint", c.Tracing.Endpoint, "OTLP/HTTP traces URL for the otlp trace exporter")
+	fs.DurationVar(&c.Server.ReadTimeout, "read-timeout", c.Server.ReadTimeout, "how long a client may take to send a request")
+	fs.DurationVar(&c.Server.WriteTimeout, "write-timeout", c.Server.WriteTimeout, "how long a response may take to write")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdown-timeout", c.Server.ShutdownTimeout, "how long in-flight requests get to finish on shutdown")
+	fs.Var(listFlag{&c.Server.TrustedProxies}, "trusted-proxies", "comma separated CIDRs of the reverse proxies whose forwarding headers are believed")
+	fs.StringVar(&c.Server.ClientIPHeader, "client-ip-header", c.Server.ClientIPHeader, "header trusted proxies append the client address to: X-Forwarded-For|Forwarded")
+}
+
+// Validate checks the settings make sense, and in production that no development
+// defaults are left in place.
+func (c *Config) Validate() error {
+	var problems []string
+
+	switch c.Env {
+	case Development, Production:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown env %q", c.Env))
+	}
+
+	if c.Secret == "" {
+		problems = append(problems, "secret is required")
+	}
+
+	if c.Env == Production {
+		if c.Secret == DefaultSecret {
+			problems = append(problems, "refusing to use the default secret in production")
+		} else if len(c.Secret) < minProductionSecretLength {
+			problems = append(problems, fmt.Sprintf("secret must be at least %d characters in production", minProductionSecretLength))
+		}
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown log.level %q", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		problems = append(problems, fmt.Sprintf("unknown log.format %q", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			problems = append(problems, "tracing.file is required for the file exporter")
+		}
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint %q is not an http(s) URL", c.Tracing.Endpoint))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown tracing.exporter %q", c.Tracing.Exporter))
+	}
+
+	if c.Web.Port < 1 || c.Web.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("web.port %d is out of range", c.Web.Port))
+	}
+	if c.API.Port < 1 || c.API.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("api.port %d is out of range", c.API.Port))
+	}
+
+	problems = append(problems, c.Web.TLS.validate("web", c.Env)...)
+	problems = append(problems, c.API.TLS.validate("api", c.Env)...)
+
+	if c.API.RateLimit.Enabled {
+		if c.API.RateLimit.Store != "memory" {
+			problems = append(problems, fmt.Sprintf("unknown api.rate_limit.store %q", c.API.RateLimit.Store))
+		}
+		problems = append(problems, c.API.RateLimit.Default.validate("api.rate_limit.default")...)
+		problems = append(problems, c.API.RateLimit.Auth.validate("api.rate_limit.auth")...)
+	}
+
+	for _, p := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				problems = append(problems, fmt.Sprintf("server.trusted_proxies: %q is not a CIDR or address", p))
+			}
+		}
+	}
+	switch http.CanonicalHeaderKey(c.Server.ClientIPHeader) {
+	case "X-Forwarded-For", "Forwarded":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown server.client_ip_header %q", c.Server.ClientIPHeader))
+	}
+
+	timeouts := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"read_timeout", c.Server.ReadTimeout},
+		{"write_timeout", c.Server.WriteTimeout},
+		{"idle_timeout", c.Server.IdleTimeout},
+		{"shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.d <= 0 {
+			problems = append(problems, fmt.Sprintf("server.%s must be positive", t.name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// splitList reads a comma separated list, as given in flags and environment variables.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// listFlag is a flag holding a comma separated list.
+type listFlag struct{ list *[]string }
+
+func (f listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f listFlag) Set(s string) error {
+	*f.list = splitList(s)
+	return nil
+}
+
+const redacted = "[redacted]"
+
+var dsnPassword = regexp.MustCompile(`(password=)\S+`)
+var urlPassword = regexp.MustCompile(`(://[^:/@]+:)[^@]+@`)
+
+// Redacted returns the config as YAML with secrets hidden, for logging at startup.
+func (c Config) Redacted() string {
+	redact(reflect.ValueOf(&c).Elem())
+
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Struct:
+			redact(f)
+		case f.Kind() != reflect.String || f.String() == "":
+		case t.Field(i).Tag.Get("secret") == "true":
+			f.SetString(redacted)
+		case t.Field(i).Tag.Get("secret") == "dsn":
+			s := dsnPassword.ReplaceAllString(f.String(), "${1}"+redacted)
+			f.SetString(urlPassword.ReplaceAllString(s, "${1}"+redacted+"@"))
+		}
+	}
+}
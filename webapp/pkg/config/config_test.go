@@ -0,0 +1,264 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFlags(fs *flag.FlagSet, c *Config) {
+	c.SharedFlags(fs)
+	fs.StringVar(&c.Secret, "secret", c.Secret, "secret")
+	fs.IntVar(&c.API.Port, "port", c.API.Port, "port")
+}
+
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+secret: from-file
+mail_dir: /tmp/file-mail
+server:
+  write_timeout: 45s
+api:
+  port: 9000
+  domain: file.example.com
+`)
+
+	t.Setenv("WEBAPP_MAIL_DIR", "/tmp/env-mail")
+	t.Setenv("WEBAPP_API_PORT", "9100")
+	t.Setenv("WEBAPP_SERVER_IDLE_TIMEOUT", "90s")
+
+	cfg, err := Load(newFlagSet(), []string{"-config", path, "-port", "9200"}, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"default", cfg.Web.Port, 8080},
+		{"file", cfg.Secret, "from-file"},
+		{"file nested", cfg.API.Domain, "file.example.com"},
+		{"env over file", cfg.MailDir, "/tmp/env-mail"},
+		{"flag over env", cfg.API.Port, 9200},
+		{"env duration", cfg.Server.IdleTimeout, 90 * time.Second},
+		{"file duration", cfg.Server.WriteTimeout, 45 * time.Second},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestLoad_configFromEnv(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+# shared settings
+secret = "from-toml" # trailing comment
+bcrypt_cost = 11
+
+[api]
+cookie_secure = false
+domain = 'toml.example.com'
+`)
+	t.Setenv("WEBAPP_CONFIG", path)
+
+	cfg, err := Load(newFlagSet(), nil, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Secret != "from-toml" || cfg.BcryptCost != 11 || cfg.API.CookieSecure || cfg.API.Domain != "toml.example.com" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+		env  map[string]string
+		args []string
+	}{
+		{"unknown key", "config.yaml", "sekret: x\n", nil, nil},
+		{"unknown extension", "config.ini", "secret=x\n", nil, nil},
+		{"bad toml", "config.toml", "secret = \n", nil, nil},
+		{"bad env int", "", "", map[string]string{"WEBAPP_WEB_PORT": "eighty"}, nil},
+		{"default secret in production", "", "", nil, []string{"-env", "production"}},
+		{"short secret in production", "", "", nil, []string{"-env", "production", "-secret", "short"}},
+		{"unknown env", "", "", nil, []string{"-env", "staging"}},
+		{"bad port", "", "", nil, []string{"-port", "0"}},
+		{"bad env duration", "", "", map[string]string{"WEBAPP_SERVER_IDLE_TIMEOUT": "forever"}, nil},
+		{"unknown log level", "", "", map[string]string{"WEBAPP_LOG_LEVEL": "verbose"}, nil},
+		{"unknown log format", "", "", nil, []string{"-log-format", "xml"}},
+		{"unknown trace exporter", "", "", nil, []string{"-trace-exporter", "jaeger"}},
+		{"file exporter without file", "", "", nil, []string{"-trace-exporter", "file"}},
+		{"otlp exporter without endpoint", "", "", map[string]string{"WEBAPP_TRACING_EXPORTER": "otlp"}, nil},
+		{"zero rate limit", "", "", map[string]string{"WEBAPP_API_RATE_LIMIT_AUTH_REQUESTS": "0"}, nil},
+		{"unknown rate limit store", "", "", map[string]string{"WEBAPP_API_RATE_LIMIT_STORE": "redis"}, nil},
+		{"bad trusted proxy", "", "", map[string]string{"WEBAPP_SERVER_TRUSTED_PROXIES": "10.0.0.0/8,proxy.internal"}, nil},
+		{"unknown client ip header", "", "", nil, []string{"-client-ip-header", "X-Real-IP"}},
+		{"zero timeout", "", "", nil, []string{"-shutdown-timeout", "0s"}},
+		{"cert without key", "", "", map[string]string{"WEBAPP_API_TLS_CERT_FILE": "cert.pem"}, nil},
+		{"cert and self signed", "", "", map[string]string{"WEBAPP_WEB_TLS_CERT_FILE": "cert.pem", "WEBAPP_WEB_TLS_KEY_FILE": "key.pem", "WEBAPP_WEB_TLS_SELF_SIGNED": "true"}, nil},
+		{"redirect without tls", "", "", map[string]string{"WEBAPP_WEB_TLS_REDIRECT_PORT": "80"}, nil},
+		{"self signed in production", "", "", map[string]string{"WEBAPP_API_TLS_SELF_SIGNED": "true"}, []string{"-env", "production", "-secret", strings.Repeat("s", minProductionSecretLength)}},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			args := e.args
+			if e.file != "" {
+				args = append([]string{"-config", writeFile(t, e.file, e.body)}, args...)
+			}
+			for k, v := range e.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := Load(newFlagSet(), args, testFlags)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoad_production(t *testing.T) {
+	secret := strings.Repeat("s", minProductionSecretLength)
+	cfg, err := Load(newFlagSet(), []string{"-env", "production", "-secret", secret}, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != Production {
+		t.Errorf("expected production but got %s", cfg.Env)
+	}
+}
+
+func TestLoad_trustedProxies(t *testing.T) {
+	t.Setenv("WEBAPP_SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+	cfg, err := Load(newFlagSet(), nil, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Server.TrustedProxies, []string{"10.0.0.0/8", "192.0.2.1"}) {
+		t.Errorf("unexpected trusted proxies from env %v", cfg.Server.TrustedProxies)
+	}
+
+	cfg, err = Load(newFlagSet(), []string{"-trusted-proxies", "fd00::/8"}, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Server.TrustedProxies, []string{"fd00::/8"}) {
+		t.Errorf("unexpected trusted proxies from flag %v", cfg.Server.TrustedProxies)
+	}
+}
+
+func TestLoad_otherFlags(t *testing.T) {
+	fs := newFlagSet()
+	action := fs.String("action", "valid", "action")
+
+	cfg, err := Load(fs, []string{"-action", "expired", "-secret", "s3cret"}, testFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *action != "expired" || cfg.Secret != "s3cret" {
+		t.Errorf("expected both flags to be set, got action %s and secret %s", *action, cfg.Secret)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Secret = "super-secret-value"
+	cfg.DSN = "host=db user=app password=hunter2 dbname=users"
+
+	out := cfg.Redacted()
+
+	for _, leaked := range []string{"super-secret-value", "hunter2"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("expected %q to be redacted from:\n%s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, "host=db user=app password=[redacted] dbname=users") {
+		t.Errorf("expected the rest of the dsn to be kept:\n%s", out)
+	}
+	if cfg.Secret != "super-secret-value" {
+		t.Error("Redacted should not change the config")
+	}
+
+	cfg.DSN = "postgres://app:hunter2@db/users"
+	if out := cfg.Redacted(); strings.Contains(out, "hunter2") {
+		t.Errorf("expected url password to be redacted:\n%s", out)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	m, err := parseTOML(`
+title = "a # not a comment"
+count = 1_000
+hex = 0x10
+list = ["a", 'b,c', "d"]
+
+[web.inner]
+"quoted key" = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m["title"] != "a # not a comment" || m["count"] != int64(1000) || m["hex"] != int64(16) {
+		t.Errorf("unexpected values %v", m)
+	}
+
+	list, _ := m["list"].([]interface{})
+	if len(list) != 3 || list[1] != "b,c" {
+		t.Errorf("unexpected list %v", m["list"])
+	}
+
+	web, _ := m["web"].(map[string]interface{})
+	inner, _ := web["inner"].(map[string]interface{})
+	if inner["quoted key"] != true {
+		t.Errorf("unexpected table %v", m["web"])
+	}
+
+	for _, bad := range []string{"a = 1\na = 2", "[[array]]", "a", "a = [1,\n2]", "a = nope", "a = 1\n[a]"} {
+		if _, err := parseTOML(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	cfg := Default()
+	err := loadFile("../../config.example.yaml", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("expected the example file to hold the defaults, got %+v", cfg)
+	}
+}
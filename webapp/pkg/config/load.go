@@ -0,0 +1,149 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable read. Variables are named after
+// the yaml keys, e.g. WEBAPP_SECRET or WEBAPP_API_PORT.
+const EnvPrefix = "WEBAPP"
+
+// Load builds the config from, in increasing order of precedence, the defaults, the file
+// named by -config or WEBAPP_CONFIG, environment variables and flags given in args.
+// register adds the binary's flags to fs, bound to the config it is passed; flags that fs
+// already has are left alone, so binaries can keep options that are not configuration.
+func Load(fs *flag.FlagSet, args []string, register func(fs *flag.FlagSet, c *Config)) (*Config, error) {
+	// parse into a throwaway config first, to find the file and see which flags were set
+	parsed := Default()
+	var path string
+	fs.StringVar(&path, "config", os.Getenv(EnvPrefix+"_CONFIG"), "YAML or TOML config file")
+	register(fs, &parsed)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix); err != nil {
+		return nil, err
+	}
+
+	// set flags again, this time on top of the file and environment
+	final := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	register(final, &cfg)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err == nil && final.Lookup(f.Name) != nil {
+			err = final.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// loadFile reads a YAML or TOML file, chosen by its extension. Unknown keys are errors,
+// so that typos don't silently leave a default in place.
+func loadFile(path string, cfg *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		m, err := parseTOML(string(b))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		// TOML tables decode the same way as YAML maps, so go through YAML to reuse the tags
+		b, err = yaml.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+
+	err = yaml.UnmarshalStrict(b, cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv sets fields of v from environment variables named prefix_KEY, where KEY is the
+// upper cased yaml key, nested sections adding their own key to the prefix.
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		name := prefix + "_" + strings.ToUpper(key)
+		f := v.Field(i)
+
+		if f.Kind() == reflect.Struct {
+			if err := applyEnv(f, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Int64:
+			if f.Type() != reflect.TypeOf(time.Duration(0)) {
+				continue
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetInt(int64(d))
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetBool(b)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.String {
+				f.Set(reflect.ValueOf(splitList(value)))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML a config file needs: [table] and [dotted.table]
+// headers, and key = value lines where the value is a string, integer, boolean or a one
+// line array of those. Comments start with # outside strings.
+func parseTOML(src string) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+
+	for n, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: unsupported table header %q", n+1, line)
+			}
+			var err error
+			table, err = subTable(root, strings.TrimSpace(line[1:len(line)-1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n+1)
+		}
+		key = unquoteKey(strings.TrimSpace(key))
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", n+1)
+		}
+		if _, exists := table[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n+1, key)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		table[key] = value
+	}
+
+	return root, nil
+}
+
+func subTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	table := root
+	for _, part := range strings.Split(name, ".") {
+		part = unquoteKey(strings.TrimSpace(part))
+		if part == "" {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+		next, ok := table[part]
+		if !ok {
+			next = make(map[string]interface{})
+			table[part] = next
+		}
+		t, ok := next.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%q is not a table", name)
+		}
+		table = t
+	}
+	return table, nil
+}
+
+func parseTOMLValue(raw string) (interface{}, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("missing value")
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") || strings.Contains(raw[1:len(raw)-1], "'") {
+			return nil, fmt.Errorf("invalid literal string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return nil, fmt.Errorf("arrays must be on one line")
+		}
+		var values []interface{}
+		for _, item := range splitArray(raw[1 : len(raw)-1]) {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+
+	n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s", raw)
+	}
+	return n, nil
+}
+
+// stripComment removes a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitArray splits the inside of an array on commas that are not inside strings.
+func splitArray(s string) []string {
+	var parts []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteKey(key string) string {
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
@@ -0,0 +1,347 @@
+package cors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy says which cross origin requests browsers may make and what they may see.
+type Policy struct {
+	// AllowedOrigins are exact origins such as https://app.example.com, patterns with a
+	// wildcard subdomain such as https://*.example.com, or "*" for any origin. Neither "*"
+	// nor a wildcard can be combined with AllowCredentials: "*" would let any site act as the
+	// signed in user, and a pattern can not tell example.com from a public suffix like co.uk.
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers"`
+	// ExposedHeaders are response headers scripts may read beyond the CORS safelisted ones.
+	ExposedHeaders []string `json:"exposed_headers"`
+	// AllowCredentials lets browsers send cookies and read responses to credentialed requests.
+	AllowCredentials bool `json:"allow_credentials"`
+	// MaxAge is how many seconds browsers may cache a preflight result; zero leaves it to them.
+	MaxAge int `json:"max_age"`
+}
+
+// Config is the default Policy plus overrides for paths that need a different one. A route
+// is a path prefix, matched on whole segments, and the longest matching route wins. Routes
+// without a version cover every version of the api as well, so /users also matches
+// /v1/users and /v2/users/7, while /v1/users matches only version 1. Route policies replace
+// the default entirely rather than adding to it.
+type Config struct {
+	Policy
+	Routes map[string]Policy `json:"routes"`
+}
+
+// DefaultConfig allows the local front end to make credentialed requests and
+// read the headers the api sets on its responses.
+func DefaultConfig() Config {
+	return Config{
+		Policy: Policy{
+			AllowedOrigins: []string{"http://localhost:8090"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Accept", "Content-Type", "X-CSRF-Token", "Authorization", "X-API-Key"},
+			ExposedHeaders: []string{
+				"Location", "Link", "Retry-After", "X-Request-ID",
+				"RateLimit-Limit", "RateLimit-Policy", "RateLimit-Remaining", "RateLimit-Reset",
+				"Deprecation", "Sunset", "API-Version",
+			},
+			AllowCredentials: true,
+			MaxAge:           300,
+		},
+	}
+}
+
+// LoadFile reads a JSON Config from path. Fields left out keep their DefaultConfig values.
+func LoadFile(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// CORS is a compiled Config.
+type CORS struct {
+	def    *policy
+	routes []route
+}
+
+type route struct {
+	prefix string
+	policy *policy
+}
+
+type policy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   []wildcard
+	methods     map[string]bool
+	headers     map[string]bool
+	anyHeader   bool
+	credentials bool
+	// prepared header values
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// wildcard matches any origin with scheme and a host ending in suffix, such as
+// ".example.com", and with the given port, if any.
+type wildcard struct {
+	scheme string
+	suffix string
+	port   string
+}
+
+// New checks cfg and compiles it.
+func New(cfg Config) (*CORS, error) {
+	def, err := compile(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CORS{def: def}
+	for prefix, p := range cfg.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("cors route %q must start with /", prefix)
+		}
+		compiled, err := compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("cors route %s: %w", prefix, err)
+		}
+		c.routes = append(c.routes, route{prefix: strings.TrimSuffix(prefix, "/"), policy: compiled})
+	}
+
+	// longest prefix first
+	sort.Slice(c.routes, func(i, j int) bool {
+		return len(c.routes[i].prefix) > len(c.routes[j].prefix)
+	})
+
+	return c, nil
+}
+
+func compile(p Policy) (*policy, error) {
+	c := &policy{
+		origins:     make(map[string]bool),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: p.AllowCredentials,
+	}
+
+	for _, o := range p.AllowedOrigins {
+		switch {
+		case o == "*":
+			c.anyOrigin = true
+		case strings.Contains(o, "*"):
+			w, err := parseWildcard(o)
+			if err != nil {
+				return nil, err
+			}
+			c.wildcards = append(c.wildcards, w)
+		default:
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				return nil, fmt.Errorf("invalid origin %q", o)
+			}
+			c.origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+		}
+	}
+
+	var methods []string
+	for _, m := range p.AllowedMethods {
+		m = strings.ToUpper(m)
+		c.methods[m] = true
+		methods = append(methods, m)
+	}
+	c.allowMethods = strings.Join(methods, ", ")
+
+	var headers []string
+	for _, h := range p.AllowedHeaders {
+		if h == "*" {
+			c.anyHeader = true
+			continue
+		}
+		h = http.CanonicalHeaderKey(h)
+		c.headers[h] = true
+		headers = append(headers, h)
+	}
+	c.allowHeaders = strings.Join(headers, ", ")
+
+	var exposed []string
+	for _, h := range p.ExposedHeaders {
+		exposed = append(exposed, http.CanonicalHeaderKey(h))
+	}
+	c.exposeHeaders = strings.Join(exposed, ", ")
+
+	if c.anyOrigin && c.credentials {
+		return nil, errors.New(`allowed origin "*" can not be used with allow_credentials`)
+	}
+	if len(c.wildcards) > 0 && c.credentials {
+		return nil, errors.New("wildcard origins can not be used with allow_credentials")
+	}
+
+	if p.MaxAge < 0 {
+		return nil, fmt.Errorf("invalid max age %d", p.MaxAge)
+	}
+	if p.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(p.MaxAge)
+	}
+
+	return c, nil
+}
+
+// parseWildcard accepts patterns like https://*.example.com and https://*.example.com:8443.
+func parseWildcard(o string) (wildcard, error) {
+	scheme, rest, ok := strings.Cut(o, "://")
+	if !ok || !strings.HasPrefix(rest, "*.") || strings.Count(rest, "*") != 1 {
+		return wildcard{}, fmt.Errorf("invalid origin pattern %q", o)
+	}
+
+	host, port, _ := strings.Cut(rest[1:], ":")
+	if strings.ContainsAny(host, "/") || strings.Count(host, ".") < 2 {
+		// *.com and the like would match far too much
+		return wildcard{}, fmt.Errorf("invalid origin pattern %q", o)
+	}
+
+	return wildcard{scheme: strings.ToLower(scheme), suffix: strings.ToLower(host), port: port}, nil
+}
+
+func (p *policy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+
+	scheme, rest, ok := strings.Cut(origin, "://")
+	if !ok {
+		return false
+	}
+	host, port, _ := strings.Cut(rest, ":")
+	for _, w := range p.wildcards {
+		if w.scheme == scheme && w.port == port && strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *policy) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !p.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOrigin sets the headers every allowed response needs. compile makes sure a policy
+// for any origin is never credentialed, as browsers refuse "*" on credentialed responses.
+func (p *policy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) policyFor(path string) *policy {
+	unversioned := withoutVersion(path)
+	for _, r := range c.routes {
+		if hasPrefix(path, r.prefix) || hasPrefix(unversioned, r.prefix) {
+			return r.policy
+		}
+	}
+	return c.def
+}
+
+// hasPrefix reports whether prefix is path or a run of whole segments at its start.
+func hasPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == ""
+}
+
+// withoutVersion drops a leading version segment such as /v1 from path.
+func withoutVersion(path string) string {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if len(segment) < 2 || segment[0] != 'v' || strings.Trim(segment[1:], "0123456789") != "" {
+		return path
+	}
+	return "/" + rest
+}
+
+// Handler answers preflight requests itself and adds CORS headers to the responses of
+// next. Requests from origins that are not allowed get no CORS headers, so browsers will
+// not let scripts see the response.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := c.policyFor(r.URL.Path)
+		origin := r.Header.Get("Origin")
+		h := w.Header()
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Origin")
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			if origin == "" || !p.allowsOrigin(origin) || !p.methods[method] || !p.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			p.allowOrigin(h, origin)
+			h.Set("Access-Control-Allow-Methods", p.allowMethods)
+			if p.anyHeader {
+				h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			} else if p.allowHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+			}
+			if p.maxAge != "" {
+				h.Set("Access-Control-Max-Age", p.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// the response depends on Origin unless every origin gets the same "*"
+		if !p.anyOrigin {
+			h.Add("Vary", "Origin")
+		}
+
+		if origin != "" && p.allowsOrigin(origin) {
+			p.allowOrigin(h, origin)
+			if p.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,221 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Next", "called")
+})
+
+func testCORS(t *testing.T) *CORS {
+	cfg := Config{
+		Policy: Policy{
+			AllowedOrigins:   []string{"https://app.example.com"},
+			AllowedMethods:   []string{"GET", "POST"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization"},
+			ExposedHeaders:   []string{"location"},
+			AllowCredentials: true,
+			MaxAge:           600,
+		},
+		Routes: map[string]Policy{
+			"/v2/widgets": {
+				AllowedOrigins: []string{"https://*.example.org"},
+				AllowedMethods: []string{"GET"},
+			},
+			"/public": {
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET"},
+			},
+		},
+	}
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHandler(t *testing.T) {
+	c := testCORS(t)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		origin        string
+		requestMethod string
+		expectOrigin  string
+		expectNext    bool
+	}{
+		{"exact origin", "GET", "/users", "https://app.example.com", "", "https://app.example.com", true},
+		{"wildcard subdomain", "GET", "/v2/widgets", "https://a.b.example.org", "", "https://a.b.example.org", true},
+		{"wildcard needs a subdomain", "GET", "/v2/widgets", "https://example.org", "", "", true},
+		{"wildcard scheme", "GET", "/v2/widgets", "http://a.example.org", "", "", true},
+		{"suffix trickery", "GET", "/v2/widgets", "https://evilexample.org", "", "", true},
+		{"wildcard on another route", "GET", "/users", "https://a.example.org", "", "", true},
+		{"unknown origin", "GET", "/users", "https://evil.example", "", "", true},
+		{"no origin", "GET", "/users", "", "", "", true},
+		{"preflight", "OPTIONS", "/users", "https://app.example.com", "POST", "https://app.example.com", false},
+		{"preflight method not allowed", "OPTIONS", "/users", "https://app.example.com", "DELETE", "", false},
+		{"plain options", "OPTIONS", "/users", "https://app.example.com", "", "https://app.example.com", true},
+		{"route override", "GET", "/public/x", "https://anyone.example", "", "*", true},
+		{"route override method", "OPTIONS", "/public", "https://anyone.example", "POST", "", false},
+		{"route prefix is a whole segment", "GET", "/publicity", "https://anyone.example", "", "", true},
+		{"route in a version", "GET", "/v1/public/x", "https://anyone.example", "", "*", true},
+		{"route in a later version", "GET", "/v12/public", "https://anyone.example", "", "*", true},
+		{"versioned route", "GET", "/v2/widgets", "https://a.example.org", "", "https://a.example.org", true},
+		{"versioned route in another version", "GET", "/v1/widgets", "https://a.example.org", "", "", true},
+		{"not a version", "GET", "/vip/public", "https://anyone.example", "", "", true},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.path, nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", e.requestMethod)
+		}
+		rr := httptest.NewRecorder()
+
+		c.Handler(next).ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != e.expectOrigin {
+			t.Errorf("%s: expected allowed origin %q but got %q", e.name, e.expectOrigin, got)
+		}
+
+		if called := rr.Header().Get("X-Next") != ""; called != e.expectNext {
+			t.Errorf("%s: expected next to be called %v but was %v", e.name, e.expectNext, called)
+		}
+	}
+}
+
+func TestHandler_headers(t *testing.T) {
+	c := testCORS(t)
+
+	req := httptest.NewRequest("OPTIONS", "/users", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+	rr := httptest.NewRecorder()
+	c.Handler(next).ServeHTTP(rr, req)
+
+	h := rr.Header()
+	if h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("expected credentials to be allowed")
+	}
+	if h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("expected max age 600 but got %q", h.Get("Access-Control-Max-Age"))
+	}
+	if h.Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" {
+		t.Errorf("unexpected allowed headers %q", h.Get("Access-Control-Allow-Headers"))
+	}
+	if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Origin") {
+		t.Error("expected preflight to vary on Origin")
+	}
+
+	// a header that is not allowed fails the preflight
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	rr = httptest.NewRecorder()
+	c.Handler(next).ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected preflight with a disallowed header to fail")
+	}
+
+	// actual requests expose headers and vary on Origin, even when the origin is refused
+	for _, origin := range []string{"https://app.example.com", "https://evil.example"} {
+		req = httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("Origin", origin)
+		rr = httptest.NewRecorder()
+		c.Handler(next).ServeHTTP(rr, req)
+		if rr.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin", origin)
+		}
+	}
+	if rr.Header().Get("Access-Control-Expose-Headers") != "" {
+		t.Error("did not expect exposed headers for a refused origin")
+	}
+
+	req.Header.Set("Origin", "https://app.example.com")
+	rr = httptest.NewRecorder()
+	c.Handler(next).ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Expose-Headers") != "Location" {
+		t.Errorf("expected Location to be exposed but got %q", rr.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	c, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("Origin", "http://localhost:8090")
+	rr := httptest.NewRecorder()
+	c.Handler(next).ServeHTTP(rr, req)
+
+	exposed := rr.Header().Get("Access-Control-Expose-Headers")
+	for _, h := range []string{"Location", "Retry-After", "X-Request-Id", "Ratelimit-Remaining", "Sunset", "Api-Version"} {
+		if !strings.Contains(exposed, h) {
+			t.Errorf("expected %s to be exposed but got %q", h, exposed)
+		}
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"bad origin", Config{Policy: Policy{AllowedOrigins: []string{"example.com"}}}},
+		{"origin with path", Config{Policy: Policy{AllowedOrigins: []string{"https://example.com/app"}}}},
+		{"top level wildcard", Config{Policy: Policy{AllowedOrigins: []string{"https://*.com"}}}},
+		{"wildcard in the middle", Config{Policy: Policy{AllowedOrigins: []string{"https://app.*.com"}}}},
+		{"negative max age", Config{Policy: Policy{MaxAge: -1}}},
+		{"any origin with credentials", Config{Policy: Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}}},
+		{"wildcard with credentials", Config{Policy: Policy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}}},
+		{"route for any origin with credentials", Config{Routes: map[string]Policy{"/public": {AllowedOrigins: []string{"*"}, AllowCredentials: true}}}},
+		{"relative route", Config{Routes: map[string]Policy{"users": {}}}},
+	}
+
+	for _, e := range tests {
+		if _, err := New(e.cfg); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cors.json")
+	err := os.WriteFile(path, []byte(`{"allowed_origins": ["https://app.example.com"], "routes": {"/public": {"allowed_origins": ["*"]}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.AllowedOrigins) != 1 || cfg.AllowedOrigins[0] != "https://app.example.com" {
+		t.Errorf("unexpected origins %v", cfg.AllowedOrigins)
+	}
+	if len(cfg.AllowedMethods) == 0 || !cfg.AllowCredentials {
+		t.Error("expected fields left out to keep their defaults")
+	}
+	if _, ok := cfg.Routes["/public"]; !ok {
+		t.Error("expected a /public route")
+	}
+
+	if _, err := New(cfg); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,41 @@
+package data
+
+import "time"
+
+// LoginAttempts tracks failed logins for one throttling key, such as an account or client IP.
+type LoginAttempts struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// LoginFailure is a failed login to add to the attempts for Key. Stores add it in a single
+// operation, so that failures made at the same time are all counted.
+type LoginFailure struct {
+	Key string
+	At  time.Time
+	// ResetBefore starts the count again if the last failure was before it.
+	ResetBefore time.Time
+	// MaxFailures locks the key until LockUntil once there have been that many failures.
+	MaxFailures int
+	LockUntil   time.Time
+}
+
+// Add returns a with f added. The count also starts again when a lockout has ended.
+func (a LoginAttempts) Add(f LoginFailure) LoginAttempts {
+	expiredLock := !a.LockedUntil.IsZero() && !f.At.Before(a.LockedUntil)
+	if expiredLock || a.LastFailure.Before(f.ResetBefore) {
+		a.Failures = 0
+		a.LockedUntil = time.Time{}
+	}
+
+	a.Key = f.Key
+	a.Failures++
+	a.LastFailure = f.At
+	if a.Failures >= f.MaxFailures {
+		a.LockedUntil = f.LockUntil
+	}
+
+	return a
+}
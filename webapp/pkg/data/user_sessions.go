@@ -0,0 +1,15 @@
+package data
+
+import "time"
+
+// UserSession indexes a logged in web session by user, so that people can see where they
+// are logged in and log out other devices.
+type UserSession struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Token     string    `json:"-"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -1,39 +1,24 @@
 package data
 
-import (
-	"errors"
-	"time"
-
-	"golang.org/x/crypto/bcrypt"
-)
+import "time"
 
 // User describes the data for the User type.
 type User struct {
-	ID         int       `json:"id"`
-	FirstName  string    `json:"first_name"`
-	LastName   string    `json:"last_name"`
-	Email      string    `json:"email"`
-	Password   string    `json:"-"`
-	IsAdmin    int       `json:"is_admin"`
-	CreatedAt  time.Time `json:"-"`
-	UpdatedAt  time.Time `json:"-"`
-	ProfilePic UserImage `json:"-"`
-}
-
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+	ID          int       `json:"id"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Email       string    `json:"email"`
+	Password    string    `json:"-"`
+	IsAdmin     int       `json:"is_admin"`
+	TOTPSecret  string    `json:"-"`
+	TOTPEnabled bool      `json:"-"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+	ProfilePic  UserImage `json:"-"`
 
-	return true, nil
+	// PasswordChangedAt is zero until the password is first reset; sessions and
+	// refresh tokens issued before it are no longer honoured.
+	PasswordChangedAt time.Time `json:"-"`
+	// EmailVerifiedAt is zero until the user follows the link in their verification email.
+	EmailVerifiedAt time.Time `json:"-"`
 }
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// DefaultTimeout bounds how long readiness checks may take altogether.
+const DefaultTimeout = 2 * time.Second
+
+// Check reports whether something the service depends on is usable.
+type Check func(ctx context.Context) error
+
+// Checker serves the health endpoints for a service.
+type Checker struct {
+	// Checks are run by Readyz, by name.
+	Checks  map[string]Check
+	Timeout time.Duration
+}
+
+// New returns a Checker with no checks.
+func New() *Checker {
+	return &Checker{Checks: make(map[string]Check), Timeout: DefaultTimeout}
+}
+
+// Add registers check under name.
+func (c *Checker) Add(name string, check Check) {
+	c.Checks[name] = check
+}
+
+// Status is the body of the health endpoints.
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Healthz reports that the process is up and serving. It checks nothing else, so that a
+// database outage does not get healthy processes restarted.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Status{Status: statusOK})
+}
+
+// Readyz runs every check at once and reports 503 if any fails or does not answer in time,
+// so traffic is only sent to instances that can serve it. The endpoint is public, so a
+// failed check is only reported as unavailable and its error goes to the log.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.Timeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(c.Checks))
+	for name, check := range c.Checks {
+		go func(name string, check Check) {
+			results <- result{name, check(ctx)}
+		}(name, check)
+	}
+
+	status := Status{Status: statusOK, Checks: make(map[string]string)}
+	for name := range c.Checks {
+		status.Checks[name] = "timed out"
+	}
+
+	code := http.StatusOK
+collect:
+	for range c.Checks {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				slog.ErrorContext(r.Context(), "readiness check failed", "check", res.name, "error", res.err)
+				status.Checks[res.name] = statusUnavailable
+				code = http.StatusServiceUnavailable
+			} else {
+				status.Checks[res.name] = statusOK
+			}
+		case <-ctx.Done():
+			// whatever has not answered yet stays "timed out"
+			code = http.StatusServiceUnavailable
+			break collect
+		}
+	}
+
+	if code != http.StatusOK {
+		status.Status = statusUnavailable
+	}
+
+	writeJSON(w, code, status)
+}
+
+// Database checks that db answers a ping.
+func Database(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		if db == nil {
+			return errors.New("no database connection")
+		}
+		return db.PingContext(ctx)
+	}
+}
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified"`
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo returns what the Go toolchain recorded in the binary. The VCS settings are
+// only there for binaries built with go build inside a checkout.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: "unknown", GoVersion: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.Module = bi.Main.Path
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+
+	return info
+}
+
+// Version serves ReadBuildInfo.
+func Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ReadBuildInfo())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
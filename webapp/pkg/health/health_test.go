@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	hanging := func(ctx context.Context) error { <-ctx.Done(); time.Sleep(10 * time.Millisecond); return ctx.Err() }
+
+	tests := []struct {
+		name           string
+		checks         map[string]Check
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{"no checks", nil, http.StatusOK, map[string]string{}},
+		{"all ok", map[string]Check{"db": ok, "cache": ok}, http.StatusOK, map[string]string{"db": "ok", "cache": "ok"}},
+		{"one failing", map[string]Check{"db": failing, "cache": ok}, http.StatusServiceUnavailable, map[string]string{"db": "unavailable", "cache": "ok"}},
+		{"one hanging", map[string]Check{"db": hanging, "cache": ok}, http.StatusServiceUnavailable, map[string]string{"db": "timed out", "cache": "ok"}},
+	}
+
+	for _, e := range tests {
+		c := New()
+		c.Timeout = 50 * time.Millisecond
+		for name, check := range e.checks {
+			c.Add(name, check)
+		}
+
+		rr := httptest.NewRecorder()
+		c.Readyz(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if strings.Contains(rr.Body.String(), "connection refused") {
+			t.Errorf("%s: check error leaked into the response", e.name)
+		}
+
+		var status Status
+		_ = json.NewDecoder(rr.Body).Decode(&status)
+		for name, expected := range e.expectedChecks {
+			if status.Checks[name] != expected {
+				t.Errorf("%s: expected %s to be %q but got %q", e.name, name, expected, status.Checks[name])
+			}
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	c := New()
+	c.Add("db", func(ctx context.Context) error { return errors.New("down") })
+
+	rr := httptest.NewRecorder()
+	c.Healthz(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected liveness to ignore checks, got status %d", rr.Code)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	if err := Database(nil)(context.Background()); err == nil {
+		t.Error("expected an error without a connection")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Version(rr, httptest.NewRequest("GET", "/version", nil))
+
+	var info BuildInfo
+	err := json.NewDecoder(rr.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK || info.GoVersion == "" || info.Version == "" {
+		t.Errorf("unexpected build info %+v", info)
+	}
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"webapp/pkg/tracing"
+)
+
+// New returns a logger writing records at level or above to w, as JSON or as text. Records
+// logged with a request's context carry its request ID, the user ID once it is known, and
+// the trace and span the record was written in.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// contextHandler adds the request attributes stored in the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := infoFromContext(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+		if id := info.userID.Load(); id != 0 {
+			r.AddAttrs(slog.Int64("user_id", id))
+		}
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		r.AddAttrs(slog.String("trace_id", span.TraceID.String()), slog.String("span_id", span.SpanID.String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
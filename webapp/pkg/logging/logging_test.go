@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/tracing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped")
+	logger.Warn("kept", "n", 1)
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected a single JSON record but got %q", buf.String())
+	}
+	if rec["msg"] != "kept" || rec["level"] != "WARN" {
+		t.Errorf("unexpected record %v", rec)
+	}
+
+	for _, e := range []struct{ format, level string }{{"xml", "info"}, {"json", "loud"}} {
+		if _, err := New(&buf, e.format, e.level); err == nil {
+			t.Errorf("expected an error for format %s and level %s", e.format, e.level)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"from client", "abc-123", "abc-123"},
+		{"none", "", ""},
+		{"unsafe", "abc\nlevel=ERROR", ""},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), ""},
+	}
+
+	for _, e := range tests {
+		var fromContext string
+		handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fromContext = RequestIDFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if e.header != "" {
+			req.Header.Set(RequestIDHeader, e.header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		id := rr.Header().Get(RequestIDHeader)
+		if id != fromContext {
+			t.Errorf("%s: response has %q but context has %q", e.name, id, fromContext)
+		}
+		if e.expected != "" && id != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, id)
+		}
+		if e.expected == "" && (id == e.header || len(id) != 32) {
+			t.Errorf("%s: expected a generated id but got %q", e.name, id)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, "json", "debug")
+
+	mux := chi.NewRouter()
+	mux.Use(RequestID)
+	mux.Use(AccessLog(logger))
+	mux.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), 7)
+		logger.InfoContext(r.Context(), "in handler")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("oops"))
+	})
+
+	req := httptest.NewRequest("GET", "/users/7", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records but got %d: %s", len(lines), buf.String())
+	}
+
+	var handlerRec, accessRec map[string]interface{}
+	_ = json.Unmarshal([]byte(lines[0]), &handlerRec)
+	_ = json.Unmarshal([]byte(lines[1]), &accessRec)
+
+	if handlerRec["request_id"] != "req-1" || handlerRec["user_id"] != float64(7) {
+		t.Errorf("expected the handler's record to carry the request, got %v", handlerRec)
+	}
+
+	expected := map[string]interface{}{
+		"msg":        "request",
+		"level":      "ERROR",
+		"request_id": "req-1",
+		"user_id":    float64(7),
+		"method":     "GET",
+		"path":       "/users/7",
+		"route":      "/users/{id}",
+		"status":     float64(500),
+		"bytes":      float64(4),
+	}
+	for k, v := range expected {
+		if accessRec[k] != v {
+			t.Errorf("expected %s to be %v but got %v", k, v, accessRec[k])
+		}
+	}
+	if _, ok := accessRec["duration_ms"]; !ok {
+		t.Error("expected a duration")
+	}
+}
+
+func TestNew_traceIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := New(&buf, "json", "info")
+
+	ctx, span := tracing.New("test", nil).Start(context.Background(), "request", tracing.KindServer)
+	logger.InfoContext(ctx, "traced")
+
+	var rec map[string]interface{}
+	_ = json.Unmarshal(buf.Bytes(), &rec)
+	if rec["trace_id"] != span.TraceID.String() || rec["span_id"] != span.SpanID.String() {
+		t.Errorf("expected the record to carry the span, got %v", rec)
+	}
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Swap in an SMTP or provider backed implementation for production.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// LogMailer writes each message to W instead of sending it, for local development.
+type LogMailer struct {
+	mu sync.Mutex
+	W  io.Writer
+}
+
+// NewLogMailer returns a LogMailer writing to stderr.
+func NewLogMailer() *LogMailer {
+	return &LogMailer{W: os.Stderr}
+}
+
+// Send writes m to the log.
+func (l *LogMailer) Send(m Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := fmt.Fprintf(l.W, "--- mail to %s\nSubject: %s\n\n%s\n---\n", m.To, m.Subject, m.Body)
+	return err
+}
+
+// FileMailer writes each message to its own file in Dir, for local development.
+type FileMailer struct {
+	Dir string
+}
+
+// Send writes m to a new file in the mail directory.
+func (f *FileMailer) Send(m Message) error {
+	err := os.MkdirAll(f.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(m.To))
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.To, m.Subject, m.Body)
+
+	return os.WriteFile(filepath.Join(f.Dir, name), []byte(content), 0o600)
+}
+
+// MemoryMailer keeps sent messages in memory, which is handy in tests.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	Messages []Message
+}
+
+// Send records m.
+func (mm *MemoryMailer) Send(m Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.Messages = append(mm.Messages, m)
+	return nil
+}
+
+// Last returns the most recently sent message, if any.
+func (mm *MemoryMailer) Last() (Message, bool) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if len(mm.Messages) == 0 {
+		return Message{}, false
+	}
+	return mm.Messages[len(mm.Messages)-1], true
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMessage = Message{To: "admin@example.com", Subject: "Hello", Body: "Some body"}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{W: &buf}
+
+	if err := m.Send(testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{"admin@example.com", "Subject: Hello", "Some body"} {
+		if !strings.Contains(buf.String(), part) {
+			t.Errorf("log output missing %q", part)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: filepath.Join(dir, "mail")}
+
+	if err := m.Send(Message{To: "../evil/admin@example.com", Subject: "Hello", Body: "Some body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := os.ReadDir(m.Dir)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file in mail dir but got %d", len(files))
+	}
+
+	content, _ := os.ReadFile(filepath.Join(m.Dir, files[0].Name()))
+	if !strings.Contains(string(content), "Some body") {
+		t.Error("mail file missing body")
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+
+	if _, ok := m.Last(); ok {
+		t.Error("empty mailer returned a message")
+	}
+
+	_ = m.Send(testMessage)
+
+	last, ok := m.Last()
+	if !ok || last.To != testMessage.To {
+		t.Error("did not get back sent message")
+	}
+}
@@ -0,0 +1,272 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit request and query latencies, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// Handler serves every metric, for /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Write writes every metric, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for i, m := range metrics {
+		m.write(w, names[i])
+	}
+}
+
+// series is the labelled values of a metric.
+type series struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]*value
+}
+
+type value struct {
+	labels []string
+	n      float64
+	// buckets, sum and count are only used by histograms
+	buckets []uint64
+	sum     float64
+}
+
+func newSeries(labels []string) series {
+	return series{labels: labels, values: make(map[string]*value)}
+}
+
+// get returns the value for labelValues, which the caller must hold mu for.
+func (s *series) get(labelValues []string, buckets int) *value {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("expected %d label values but got %d", len(s.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	v, ok := s.values[key]
+	if !ok {
+		v = &value{labels: append([]string(nil), labelValues...), buckets: make([]uint64, buckets)}
+		s.values[key] = v
+	}
+	return v
+}
+
+// sorted returns the values in a stable order, which the caller must hold mu for.
+func (s *series) sorted() []*value {
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := make([]*value, len(keys))
+	for i, k := range keys {
+		values[i] = s.values[k]
+	}
+	return values
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	help string
+	series
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{help: help, series: newSeries(labels)}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the counter for labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n, which must not be negative, to the counter for labelValues.
+func (c *Counter) Add(n float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues, 0).n += n
+}
+
+// Value returns the counter for labelValues.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(labelValues, 0).n
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, name, c.help, "counter")
+	for _, v := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.labels, v.labels), formatFloat(v.n))
+	}
+}
+
+// Histogram counts observations, such as latencies, into buckets.
+type Histogram struct {
+	help    string
+	buckets []float64
+	series
+}
+
+// NewHistogram registers a histogram with the given upper bounds and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{help: help, buckets: b, series: newSeries(labels)}
+	r.register(name, h)
+	return h
+}
+
+// Observe records x for labelValues.
+func (h *Histogram) Observe(x float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v := h.get(labelValues, len(h.buckets))
+	for i, upper := range h.buckets {
+		if x <= upper {
+			v.buckets[i]++
+			break
+		}
+	}
+	v.sum += x
+	v.n++
+}
+
+// Count returns how many observations there have been for labelValues.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return uint64(h.get(labelValues, len(h.buckets)).n)
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, name, h.help, "histogram")
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, v := range h.sorted() {
+		values := make([]string, len(labels))
+		copy(values, v.labels)
+		le := len(values) - 1
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += v.buckets[i]
+			values[le] = formatFloat(upper)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, values), cumulative)
+		}
+		values[le] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, values), uint64(v.n))
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(h.labels, v.labels), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(h.labels, v.labels), uint64(v.n))
+	}
+}
+
+// funcMetric reads its value when the metrics are written.
+type funcMetric struct {
+	help string
+	kind string
+	f    func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is f().
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{help: help, kind: "gauge", f: f})
+}
+
+// NewCounterFunc registers a counter whose value is f(), for counters kept elsewhere.
+func (r *Registry) NewCounterFunc(name, help string, f func() float64) {
+	r.register(name, &funcMetric{help: help, kind: "counter", f: f})
+}
+
+func (m *funcMetric) write(w io.Writer, name string) {
+	writeHeader(w, name, m.help, m.kind)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(m.f()))
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.", "path")
+	c.Inc("/b")
+	c.Add(2, `/a"quoted"`)
+
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	r.NewGaugeFunc("up", "Always one.", func() float64 { return 1 })
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{path="/a\"quoted\""} 2
+requests_total{path="/b"} 1
+# HELP up Always one.
+# TYPE up gauge
+up 1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+
+	r := NewRegistry()
+	r.NewCounter("x", "")
+	r.NewCounter("x", "")
+}
+
+func TestHTTP_Middleware(t *testing.T) {
+	s := NewService()
+
+	mux := chi.NewRouter()
+	mux.Use(s.HTTP.Middleware)
+	mux.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/missing"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if n := s.HTTP.requests.Value("GET", "/users/{id}", "418"); n != 2 {
+		t.Errorf("expected 2 requests for the route pattern but got %v", n)
+	}
+	if n := s.HTTP.requests.Value("GET", "unmatched", "404"); n != 1 {
+		t.Errorf("expected 1 unmatched request but got %v", n)
+	}
+	if n := s.HTTP.duration.Count("GET", "/users/{id}"); n != 2 {
+		t.Errorf("expected 2 latency observations but got %d", n)
+	}
+}
+
+func TestAuth_Record(t *testing.T) {
+	var nilAuth *Auth
+	nilAuth.Record("password", true)
+
+	a := NewAuth(NewRegistry())
+	a.Record("password", true)
+	a.Record("password", false)
+	a.Record("password", false)
+
+	if n := a.attempts.Value("password", "failure"); n != 2 {
+		t.Errorf("expected 2 failures but got %v", n)
+	}
+	if n := a.attempts.Value("password", "success"); n != 1 {
+		t.Errorf("expected 1 success but got %v", n)
+	}
+}
+
+func TestDB_Observe(t *testing.T) {
+	var nilDB *DB
+	nilDB.Observe("select users", time.Now(), nil)
+
+	d := NewDB(NewRegistry())
+	d.Observe("select users", time.Now(), nil)
+	d.Observe("select users", time.Now(), sql.ErrNoRows)
+	d.Observe("select users", time.Now(), errors.New("connection refused"))
+
+	if n := d.duration.Count("select users"); n != 3 {
+		t.Errorf("expected 3 observations but got %d", n)
+	}
+	if n := d.errors.Value("select users"); n != 1 {
+		t.Errorf("expected only the real failure to count but got %v", n)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("x_total", "X.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "x_total 1") {
+		t.Errorf("unexpected body %s", rr.Body.String())
+	}
+}
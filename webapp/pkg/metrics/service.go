@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Service is the set of metrics both servers expose.
+type Service struct {
+	Registry *Registry
+	HTTP     *HTTP
+	Auth     *Auth
+	DB       *DB
+}
+
+// NewService registers the standard metrics in a new registry.
+func NewService() *Service {
+	r := NewRegistry()
+	return &Service{
+		Registry: r,
+		HTTP:     NewHTTP(r),
+		Auth:     NewAuth(r),
+		DB:       NewDB(r),
+	}
+}
+
+// HTTP records requests by route pattern, so that /users/1 and /users/2 count together.
+type HTTP struct {
+	requests *Counter
+	duration *Histogram
+}
+
+// NewHTTP registers the request metrics in r.
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		requests: r.NewCounter("http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status"),
+		duration: r.NewHistogram("http_request_duration_seconds", "HTTP request latency by method and route.", DefaultBuckets, "method", "route"),
+	}
+}
+
+// Middleware records every request. It must wrap the chi router, so the route is known by
+// the time the handler returns.
+func (h *HTTP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		h.requests.Inc(r.Method, route, strconv.Itoa(sw.status))
+		h.duration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Auth counts login and credential checks.
+type Auth struct {
+	attempts *Counter
+}
+
+// NewAuth registers the authentication metrics in r.
+func NewAuth(r *Registry) *Auth {
+	return &Auth{
+		attempts: r.NewCounter("auth_attempts_total", "Authentication attempts by method and result.", "method", "result"),
+	}
+}
+
+// Record counts an attempt to authenticate with method, such as password or mfa. It does
+// nothing on a nil Auth, so tests need not set one up.
+func (a *Auth) Record(method string, ok bool) {
+	if a == nil {
+		return
+	}
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+	a.attempts.Inc(method, result)
+}
+
+// DB records how long repository queries take and how often they fail.
+type DB struct {
+	duration *Histogram
+	errors   *Counter
+}
+
+// NewDB registers the query metrics in r.
+func NewDB(r *Registry) *DB {
+	return &DB{
+		duration: r.NewHistogram("db_query_duration_seconds", "Database query latency by operation.", DefaultBuckets, "operation"),
+		errors:   r.NewCounter("db_query_errors_total", "Failed database queries by operation.", "operation"),
+	}
+}
+
+// Observe records a query for operation that started at start and ended with err. It does
+// nothing on a nil DB.
+func (d *DB) Observe(operation string, start time.Time, err error) {
+	if d == nil {
+		return
+	}
+	d.duration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil && err != sql.ErrNoRows {
+		d.errors.Inc(operation)
+	}
+}
+
+// RegisterPoolStats registers gauges reading the connection pool statistics of db.
+func RegisterPoolStats(r *Registry, db *sql.DB) {
+	stats := func(f func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return f(db.Stats()) }
+	}
+
+	r.NewGaugeFunc("db_pool_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("db_pool_open_connections", "Established connections, in use or idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("db_pool_in_use_connections", "Connections currently in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("db_pool_idle_connections", "Idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("db_pool_wait_count_total", "Times a connection had to be waited for.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("db_pool_wait_duration_seconds_total", "Time spent waiting for connections.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("db_pool_max_idle_closed_total", "Connections closed because of the idle limit.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.NewCounterFunc("db_pool_max_lifetime_closed_total", "Connections closed because of their maximum lifetime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
@@ -0,0 +1,106 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret, truncated to 6 digits
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateCode(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, e := range tests {
+		code, err := GenerateCode(rfcSecret, time.Unix(e.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name  string
+		code  string
+		at    time.Time
+		valid bool
+	}{
+		{"current", "081804", now, true},
+		{"previous period", "081804", now.Add(30 * time.Second), true},
+		{"too old", "081804", now.Add(90 * time.Second), false},
+		{"wrong code", "123456", now, false},
+		{"wrong length", "81804", now, false},
+	}
+
+	for _, e := range tests {
+		if ValidateCode(rfcSecret, e.code, e.at) != e.valid {
+			t.Errorf("%s: expected valid to be %t", e.name, e.valid)
+		}
+	}
+
+	if ValidateCode("not base32!", "081804", now) {
+		t.Error("invalid secret should never validate")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := GenerateCode(secret, time.Now())
+	if !ValidateCode(secret, code, time.Now()) {
+		t.Error("generated secret does not round trip")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("webapp", "admin@example.com", "ABC")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/webapp:admin@example.com?") {
+		t.Errorf("unexpected uri prefix: %s", uri)
+	}
+
+	for _, part := range []string{"secret=ABC", "issuer=webapp", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("uri %s missing %s", uri, part)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Errorf("expected %d codes but got %d", RecoveryCodeCount, len(codes))
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(codes[0])+" ") {
+		t.Error("recovery code hash should ignore case and whitespace")
+	}
+
+	if HashRecoveryCode(codes[0]) == HashRecoveryCode(codes[1]) {
+		t.Error("different codes hashed to the same value")
+	}
+}
@@ -0,0 +1,31 @@
+package mfa
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// Store records the codes that have been used, so that none is accepted twice.
+type Store interface {
+	// UseTOTPStep records step as the last time step userID gave a code for, reporting
+	// false if it is not later than the one recorded before.
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	// UseRecoveryCode marks an unused recovery code as used, reporting whether one matched.
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+}
+
+// Verify accepts either a current TOTP code or one of user's unused recovery codes, at
+// time t. A TOTP code must be for a later time step than the last one accepted for the
+// user, so that a code cannot be replayed while it is still valid.
+func Verify(ctx context.Context, store Store, user *data.User, code string, t time.Time) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, nil
+	}
+
+	if step, ok := MatchCode(user.TOTPSecret, code, t); ok {
+		return store.UseTOTPStep(ctx, user.ID, step)
+	}
+
+	return store.UseRecoveryCode(ctx, user.ID, HashRecoveryCode(code))
+}
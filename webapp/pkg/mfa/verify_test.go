@@ -0,0 +1,66 @@
+package mfa
+
+import (
+	"context"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+)
+
+type testStore struct {
+	lastStep int64
+	recovery map[string]bool
+}
+
+func (s *testStore) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if step <= s.lastStep {
+		return false, nil
+	}
+	s.lastStep = step
+	return true, nil
+}
+
+func (s *testStore) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	if !s.recovery[hash] {
+		return false, nil
+	}
+	delete(s.recovery, hash)
+	return true, nil
+}
+
+func TestVerify(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	user := &data.User{ID: 1, TOTPSecret: secret, TOTPEnabled: true}
+	store := &testStore{recovery: map[string]bool{HashRecoveryCode("abcd-efgh"): true}}
+
+	now := time.Now()
+	code, _ := GenerateCode(secret, now)
+	previous, _ := GenerateCode(secret, now.Add(-period*time.Second))
+	next, _ := GenerateCode(secret, now.Add(period*time.Second))
+
+	tests := []struct {
+		name     string
+		user     *data.User
+		code     string
+		expected bool
+	}{
+		{"current code", user, code, true},
+		{"replayed code", user, code, false},
+		{"earlier code", user, previous, false},
+		{"later code", user, next, true},
+		{"wrong code", user, "000000", false},
+		{"recovery code", user, "ABCD-EFGH", true},
+		{"used recovery code", user, "abcd-efgh", false},
+		{"two-factor not enabled", &data.User{ID: 1, TOTPSecret: secret}, code, false},
+	}
+
+	for _, e := range tests {
+		ok, err := Verify(context.Background(), store, e.user, e.code, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+		if ok != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.name, e.expected, ok)
+		}
+	}
+}
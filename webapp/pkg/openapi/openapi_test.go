@@ -0,0 +1,138 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testEmbedded struct {
+	Role string `json:"role" validate:"oneof=admin user"`
+}
+
+type testUser struct {
+	testEmbedded
+	ID       int            `json:"id" validate:"required,min=1"`
+	Email    string         `json:"email" validate:"required,email,max=255"`
+	Level    int            `json:"level" validate:"oneof=0 1"`
+	Tags     []string       `json:"tags" validate:"max=2"`
+	Nickname *string        `json:"nickname,omitempty" validate:"min=3"`
+	Address  testAddress    `json:"address"`
+	Extra    map[string]int `json:"extra"`
+	Created  time.Time      `json:"created"`
+	Secret   string         `json:"-"`
+	Untagged bool
+	hidden   string
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestSchema(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+
+	s := doc.Schema(testUser{})
+	if s.Ref != "#/components/schemas/testUser" {
+		t.Fatalf("expected a reference to testUser but got %+v", s)
+	}
+
+	expected := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"role":     {Type: "string", Enum: []interface{}{"admin", "user"}},
+			"id":       {Type: "integer", Minimum: intPtr(1)},
+			"email":    {Type: "string", Format: "email", MaxLength: intPtr(255)},
+			"level":    {Type: "integer", Enum: []interface{}{0, 1}},
+			"tags":     {Type: "array", Items: &Schema{Type: "string"}, MaxItems: intPtr(2)},
+			"nickname": {Type: "string", MinLength: intPtr(3), Nullable: true},
+			"address":  {Ref: "#/components/schemas/testAddress"},
+			"extra":    {Type: "object", AdditionalProperties: &Schema{Type: "integer"}},
+			"created":  {Type: "string", Format: "date-time"},
+			"Untagged": {Type: "boolean"},
+		},
+		Required: []string{"id", "email"},
+	}
+	if got := doc.Components.Schemas["testUser"]; !reflect.DeepEqual(got, expected) {
+		a, _ := json.Marshal(got)
+		b, _ := json.Marshal(expected)
+		t.Errorf("expected\n%s\nbut got\n%s", b, a)
+	}
+
+	address := &Schema{Type: "object", Properties: map[string]*Schema{"city": {Type: "string"}}, Required: []string{"city"}}
+	if !reflect.DeepEqual(doc.Components.Schemas["testAddress"], address) {
+		t.Errorf("unexpected schema for a field's type: %+v", doc.Components.Schemas["testAddress"])
+	}
+
+	list := doc.Schema([]testAddress{})
+	if list.Type != "array" || list.Items.Ref != "#/components/schemas/testAddress" {
+		t.Errorf("unexpected schema for a list: %+v", list)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+
+	s := doc.Define("Address", testAddress{})
+	if s.Ref != "#/components/schemas/Address" {
+		t.Errorf("unexpected reference %q", s.Ref)
+	}
+
+	// the type keeps the name wherever it is used
+	doc.Schema(testUser{})
+	if ref := doc.Components.Schemas["testUser"].Properties["address"].Ref; ref != "#/components/schemas/Address" {
+		t.Errorf("expected the defined name, but got %q", ref)
+	}
+	if _, ok := doc.Components.Schemas["testAddress"]; ok {
+		t.Error("the type was added under its own name as well")
+	}
+
+	given := &Schema{Type: "string"}
+	doc.Define("Name", given)
+	if doc.Components.Schemas["Name"] != given {
+		t.Error("a *Schema was not added as it is")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+	doc.Add("GET", "/users/{userID}", &Operation{
+		Summary:   "Get a user",
+		Responses: map[string]*Response{"200": doc.Response("The user", "application/json", testAddress{})},
+	})
+	doc.Add("post", "/users/{userID}", &Operation{
+		RequestBody: doc.Body("application/json", testAddress{}),
+		Responses:   map[string]*Response{"204": doc.Response("Done", "", nil)},
+	})
+
+	if doc.Operation("get", "/users/{userID}") == nil || doc.Operation("POST", "/users/{userID}") == nil {
+		t.Error("operations are not found whatever the case of the method")
+	}
+	if doc.Operation("DELETE", "/users/{userID}") != nil || doc.Operation("GET", "/users") != nil {
+		t.Error("found an operation that was not added")
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	_ = json.Unmarshal(out, &got)
+	if got["openapi"] != Version {
+		t.Errorf("unexpected openapi %v", got["openapi"])
+	}
+	item := got["paths"].(map[string]interface{})["/users/{userID}"].(map[string]interface{})
+	if _, ok := item["get"]; !ok {
+		t.Errorf("methods are not lower case: %v", item)
+	}
+	noContent := item["post"].(map[string]interface{})["responses"].(map[string]interface{})["204"]
+	if !reflect.DeepEqual(noContent, map[string]interface{}{"description": "Done"}) {
+		t.Errorf("unexpected response without a body: %v", noContent)
+	}
+}
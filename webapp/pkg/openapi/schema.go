@@ -0,0 +1,218 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON schema, as OpenAPI 3.0 has it, or with Ref set a reference to one in
+// Components.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Define adds the schema of v's type to the components as name, and returns a reference
+// to it. The type is referred to by that name wherever it appears afterwards, which is
+// how unexported types get a presentable one. v may be a *Schema, which is added as it is.
+func (d *Document) Define(name string, v interface{}) *Schema {
+	if s, ok := v.(*Schema); ok {
+		d.Components.Schemas[name] = s
+		return ref(name)
+	}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	d.names[t] = name
+	d.Components.Schemas[name] = d.structSchema(t)
+	return ref(name)
+}
+
+// Schema returns the schema of v's type. Named struct types are added to the components
+// under their type name, unless Define has named them already, and referred to. Fields
+// are named by their json tags and described by their validate tags, the rules the api
+// checks requests against; embedded structs add their fields, as encoding/json has it.
+// A *Schema is returned as it is.
+func (d *Document) Schema(v interface{}) *Schema {
+	if s, ok := v.(*Schema); ok {
+		return s
+	}
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := d.schemaOf(t.Elem())
+		if s.Ref != "" {
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name, ok := d.names[t]
+		if !ok {
+			name = t.Name()
+			d.names[t] = name
+			d.Components.Schemas[name] = d.structSchema(t)
+		}
+		return ref(name)
+	}
+	panic(fmt.Sprintf("openapi: no schema for %s", t))
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	d.addFields(s, t)
+	return s
+}
+
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			d.addFields(s, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := d.schemaOf(field.Type)
+		if rules, ok := field.Tag.Lookup("validate"); ok {
+			if prop.Ref == "" {
+				applyRules(prop, field.Type, rules)
+			}
+			if hasRule(rules, "required") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		s.Properties[name] = prop
+	}
+}
+
+// applyRules describes the validate rules that a schema can express. required is up to
+// the struct the field is in.
+func applyRules(s *Schema, t reflect.Type, rules string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "email":
+			s.Format = "email"
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				continue
+			}
+			setBound(s, t, name, n)
+		case "oneof":
+			for _, option := range strings.Fields(arg) {
+				s.Enum = append(s.Enum, enumValue(t, option))
+			}
+		}
+	}
+}
+
+func setBound(s *Schema, t reflect.Type, rule string, n int) {
+	var bound **int
+	switch t.Kind() {
+	case reflect.String:
+		bound = &s.MinLength
+		if rule == "max" {
+			bound = &s.MaxLength
+		}
+	case reflect.Slice, reflect.Array:
+		bound = &s.MinItems
+		if rule == "max" {
+			bound = &s.MaxItems
+		}
+	default:
+		bound = &s.Minimum
+		if rule == "max" {
+			bound = &s.Maximum
+		}
+	}
+	*bound = &n
+}
+
+// enumValue is option as the JSON value a field of type t would hold.
+func enumValue(t reflect.Type, option string) interface{} {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.Atoi(option); err == nil {
+			return n
+		}
+	}
+	return option
+}
+
+func hasRule(rules, name string) bool {
+	for _, rule := range strings.Split(rules, ",") {
+		if strings.TrimSpace(rule) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
@@ -0,0 +1,175 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is how many hex characters of the SHA-1 hash select a range, the same
+// split the Have I Been Pwned range API uses.
+const prefixLength = 5
+
+//go:embed breached.txt
+var bundled string
+
+// Checker reports whether a password is known to have been breached.
+type Checker interface {
+	Breached(password string) (bool, error)
+}
+
+// hashParts returns the upper case SHA-1 of password split into range prefix and suffix.
+// Only the prefix is ever used to find a range, in the style of k-anonymity lookups, so
+// a checker backed by a remote service could be swapped in without sending it passwords.
+func hashParts(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return h[:prefixLength], h[prefixLength:]
+}
+
+// HashList is an in memory set of breached password hashes, grouped by range prefix.
+type HashList struct {
+	ranges map[string]map[string]struct{}
+}
+
+// DefaultList returns the list bundled with the binary, which holds the most common
+// passwords from public breach corpora.
+func DefaultList() *HashList {
+	l, err := LoadList(strings.NewReader(bundled))
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// LoadList reads full SHA-1 hashes, one per line. Each may be followed by ":count", as in
+// the downloadable Pwned Passwords files; blank lines and lines starting with # are skipped.
+func LoadList(r io.Reader) (*HashList, error) {
+	l := &HashList{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if !isSHA1(hash) {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash: %q", line, hash)
+		}
+
+		l.add(hash[:prefixLength], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// LoadListFile is LoadList for a file on disk.
+func LoadListFile(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadList(f)
+}
+
+func (l *HashList) add(prefix, suffix string) {
+	r, ok := l.ranges[prefix]
+	if !ok {
+		r = make(map[string]struct{})
+		l.ranges[prefix] = r
+	}
+	r[suffix] = struct{}{}
+}
+
+// Len returns the number of hashes in the list.
+func (l *HashList) Len() int {
+	n := 0
+	for _, r := range l.ranges {
+		n += len(r)
+	}
+	return n
+}
+
+func (l *HashList) Breached(password string) (bool, error) {
+	prefix, suffix := hashParts(password)
+	_, ok := l.ranges[prefix][suffix]
+	return ok, nil
+}
+
+// RangeDir checks passwords against a directory of range files, one per hash prefix and
+// named after it (e.g. 21BD1 or 21BD1.txt), each holding "SUFFIX:count" lines. This is the
+// layout produced by the Pwned Passwords downloader, so the full corpus can be used offline
+// without loading it into memory.
+type RangeDir struct {
+	Dir string
+}
+
+func (d RangeDir) Breached(password string) (bool, error) {
+	prefix, suffix := hashParts(password)
+
+	f, err := d.open(prefix)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(s, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func (d RangeDir) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(d.Dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	}
+	return f, err
+}
+
+// Load returns a checker for path, which may be a hash list file or a range directory.
+func Load(path string) (Checker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return RangeDir{Dir: path}, nil
+	}
+
+	return LoadListFile(path)
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,118 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the cost used when none is configured.
+const DefaultBcryptCost = 12
+
+// ErrUnknownHash is returned when no hasher recognizes a stored hash.
+var ErrUnknownHash = errors.New("unrecognized password hash")
+
+// Hasher hashes and verifies passwords for one algorithm.
+type Hasher interface {
+	// Hash returns a hash of password to store.
+	Hash(password string) (string, error)
+	// Recognizes reports whether hash was produced by this algorithm.
+	Recognizes(hash string) bool
+	// Verify reports whether password matches hash.
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether hash is weaker than what Hash would produce now.
+	NeedsRehash(hash string) bool
+}
+
+// Bcrypt hashes passwords with bcrypt at Cost.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt returns a bcrypt hasher, falling back to DefaultBcryptCost if cost is out of range.
+func NewBcrypt(cost int) Bcrypt {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = DefaultBcryptCost
+	}
+	return Bcrypt{Cost: cost}
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (b Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < b.Cost
+}
+
+// Hashers hashes new passwords with Current and still verifies hashes made by any of
+// Legacy, so the algorithm can be changed without locking anybody out. Hashes from a
+// legacy hasher always need rehashing.
+type Hashers struct {
+	Current Hasher
+	Legacy  []Hasher
+}
+
+func (h Hashers) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+func (h Hashers) Recognizes(hash string) bool {
+	return h.find(hash) != nil
+}
+
+func (h Hashers) Verify(hash, password string) (bool, error) {
+	hasher := h.find(hash)
+	if hasher == nil {
+		return false, ErrUnknownHash
+	}
+	return hasher.Verify(hash, password)
+}
+
+func (h Hashers) NeedsRehash(hash string) bool {
+	if h.Current.Recognizes(hash) {
+		return h.Current.NeedsRehash(hash)
+	}
+	return true
+}
+
+func (h Hashers) find(hash string) Hasher {
+	if h.Current.Recognizes(hash) {
+		return h.Current
+	}
+	for _, legacy := range h.Legacy {
+		if legacy.Recognizes(hash) {
+			return legacy
+		}
+	}
+	return nil
+}
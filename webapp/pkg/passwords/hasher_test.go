@@ -0,0 +1,80 @@
+package passwords
+
+import (
+	"testing"
+)
+
+// fakeHasher stands in for a future algorithm, e.g. argon2id.
+type fakeHasher struct{}
+
+func (fakeHasher) Hash(password string) (string, error)       { return "$fake$" + password, nil }
+func (fakeHasher) Recognizes(hash string) bool                { return len(hash) > 6 && hash[:6] == "$fake$" }
+func (fakeHasher) Verify(hash, password string) (bool, error) { return hash == "$fake$"+password, nil }
+func (fakeHasher) NeedsRehash(hash string) bool               { return false }
+
+func TestBcrypt(t *testing.T) {
+	b := NewBcrypt(4)
+
+	hash, err := b.Hash("Correct-Horse-9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !b.Recognizes(hash) {
+		t.Error("expected bcrypt to recognize its own hash")
+	}
+
+	if ok, _ := b.Verify(hash, "Correct-Horse-9"); !ok {
+		t.Error("expected password to verify")
+	}
+	if ok, _ := b.Verify(hash, "wrong"); ok {
+		t.Error("did not expect wrong password to verify")
+	}
+
+	if b.NeedsRehash(hash) {
+		t.Error("did not expect a hash at the current cost to need rehashing")
+	}
+	if !NewBcrypt(5).NeedsRehash(hash) {
+		t.Error("expected a hash below the current cost to need rehashing")
+	}
+
+	if NewBcrypt(100).Cost != DefaultBcryptCost {
+		t.Error("expected an out of range cost to fall back to the default")
+	}
+}
+
+func TestHashers(t *testing.T) {
+	bcryptHash, _ := NewBcrypt(4).Hash("Correct-Horse-9")
+
+	h := Hashers{Current: fakeHasher{}, Legacy: []Hasher{NewBcrypt(4)}}
+
+	tests := []struct {
+		name        string
+		hash        string
+		password    string
+		matches     bool
+		needsRehash bool
+	}{
+		{"current", "$fake$Correct-Horse-9", "Correct-Horse-9", true, false},
+		{"legacy", bcryptHash, "Correct-Horse-9", true, true},
+		{"legacy wrong password", bcryptHash, "wrong", false, true},
+	}
+
+	for _, e := range tests {
+		ok, err := h.Verify(e.hash, e.password)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if ok != e.matches {
+			t.Errorf("%s: expected match %v but got %v", e.name, e.matches, ok)
+		}
+		if h.NeedsRehash(e.hash) != e.needsRehash {
+			t.Errorf("%s: expected needs rehash %v", e.name, e.needsRehash)
+		}
+	}
+
+	_, err := h.Verify("plaintext", "plaintext")
+	if err != ErrUnknownHash {
+		t.Errorf("expected ErrUnknownHash but got %v", err)
+	}
+}
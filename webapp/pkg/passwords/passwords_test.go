@@ -0,0 +1,104 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	p := DefaultPolicy()
+	owner := Owner{Email: "jack.smith@example.com", FirstName: "Jack", LastName: "Smith"}
+
+	tests := []struct {
+		name     string
+		password string
+		problems int
+	}{
+		{"good", "Correct-Horse-9", 0},
+		{"empty", "", 2},
+		{"too short", "Ab1-", 1},
+		{"too long", "Correct-Horse-9" + strings.Repeat("x", 58), 1},
+		{"longest", "Correct-Horse-9" + strings.Repeat("x", 57), 0},
+		{"too few classes", "correcthorsebattery", 1},
+		{"contains name", "Smith-Horse-9", 1},
+		{"contains email", "X1-jack.smith-x", 1},
+		{"breached", "Password123", 1},
+		{"breached and short", "P@ssw0rd", 2},
+	}
+
+	for _, e := range tests {
+		problems, err := p.Validate(e.password, owner)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if len(problems) != e.problems {
+			t.Errorf("%s: expected %d problems but got %d: %v", e.name, e.problems, len(problems), problems)
+		}
+	}
+}
+
+func TestDefaultList(t *testing.T) {
+	l := DefaultList()
+	if l.Len() == 0 {
+		t.Fatal("bundled list is empty")
+	}
+
+	for _, pw := range []string{"password", "123456", "Password1", "qwerty123"} {
+		if ok, _ := l.Breached(pw); !ok {
+			t.Errorf("expected %q to be breached", pw)
+		}
+	}
+
+	if ok, _ := l.Breached("Correct-Horse-9"); ok {
+		t.Error("did not expect Correct-Horse-9 to be breached")
+	}
+}
+
+func TestLoadList(t *testing.T) {
+	// sha1("hunter2") = F3BBBD66A63D4BF1747940578EC3D0103530E21D
+	l, err := LoadList(strings.NewReader("# comment\n\nf3bbbd66a63d4bf1747940578ec3d0103530e21d:17\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := l.Breached("hunter2"); !ok {
+		t.Error("expected hunter2 to be breached")
+	}
+
+	_, err = LoadList(strings.NewReader("not-a-hash\n"))
+	if err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "F3BBB.txt"), []byte("0000000000000000000000000000000000A:1\r\nD66A63D4BF1747940578EC3D0103530E21D:17\r\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		expected bool
+	}{
+		{"hunter2", true},
+		{"hunter3", false},
+	}
+
+	for _, e := range tests {
+		ok, err := c.Breached(e.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.password, e.expected, ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Policy describes what a new password has to look like.
+type Policy struct {
+	// MinLength is the minimum number of characters (not bytes).
+	MinLength int
+	// MaxBytes is the maximum length in bytes, as bcrypt refuses passwords over 72.
+	MaxBytes int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols must appear.
+	MinClasses int
+	// Breached, if set, rejects passwords known to have appeared in a breach.
+	Breached Checker
+}
+
+// DefaultPolicy returns the policy used by the web and api servers, checking against
+// the bundled list of common breached passwords.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:  10,
+		MaxBytes:   72,
+		MinClasses: 3,
+		Breached:   DefaultList(),
+	}
+}
+
+// NewPolicy returns DefaultPolicy, checking against the hash list file or range directory
+// at breachedPath instead of the bundled list if it is not empty.
+func NewPolicy(breachedPath string) (*Policy, error) {
+	p := DefaultPolicy()
+	if breachedPath == "" {
+		return p, nil
+	}
+
+	checker, err := Load(breachedPath)
+	if err != nil {
+		return nil, err
+	}
+	p.Breached = checker
+
+	return p, nil
+}
+
+// Owner is what we know about the person choosing the password. A password that
+// contains their email address or name is easy to guess.
+type Owner struct {
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// Validate returns a message for every rule the password breaks, or nil if it is
+// acceptable. An error is only returned if the breached password list could not be read.
+func (p *Policy) Validate(password string, owner Owner) ([]string, error) {
+	var problems []string
+
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, fmt.Sprintf("Password must be at most %d bytes long", p.MaxBytes))
+	}
+
+	if classes(password) < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("Password must contain at least %d of: lowercase letters, uppercase letters, digits and symbols", p.MinClasses))
+	}
+
+	if containsPersonal(password, owner) {
+		problems = append(problems, "Password must not contain your name or email address")
+	}
+
+	if p.Breached != nil && password != "" {
+		breached, err := p.Breached.Breached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			problems = append(problems, "Password has appeared in a data breach, please choose another")
+		}
+	}
+
+	return problems, nil
+}
+
+func classes(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	n := 0
+	for _, b := range []bool{lower, upper, digit, symbol} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// containsPersonal reports whether the password contains the local part of the owner's
+// email address or either of their names. Very short values are ignored, otherwise
+// someone called "Al" could not have an "l" in their password.
+func containsPersonal(password string, owner Owner) bool {
+	password = strings.ToLower(password)
+
+	local, _, _ := strings.Cut(owner.Email, "@")
+	for _, s := range []string{local, owner.FirstName, owner.LastName} {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len([]rune(s)) >= 3 && strings.Contains(password, s) {
+			return true
+		}
+	}
+	return false
+}
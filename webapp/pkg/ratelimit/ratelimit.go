@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/config"
+)
+
+// Limit is a token bucket holding up to Burst tokens, or Requests if Burst is zero, and
+// refilling at Requests per Period. Each request takes a token.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// FromConfig converts a configured limit.
+func FromConfig(l config.RateLimit) Limit {
+	return Limit{Requests: l.Requests, Period: l.Period, Burst: l.Burst}
+}
+
+// Capacity is how many requests can be made at once after a quiet spell.
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// perSecond is the refill rate.
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a token is available, when none was.
+	RetryAfter time.Duration
+}
+
+// Store keeps buckets. MemoryStore keeps them in process; a shared store lets several
+// instances enforce one limit.
+type Store interface {
+	// Take takes a token from the bucket for key, which is created full if it is new.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Limiter applies one limit to requests, keyed by whoever made them.
+type Limiter struct {
+	Store Store
+	Limit Limit
+	// Name keeps the buckets of limiters sharing a store apart.
+	Name string
+}
+
+// Take takes a token for key, such as a user or client IP.
+func (l *Limiter) Take(ctx context.Context, key string) (Result, error) {
+	return l.Store.Take(ctx, l.Name+"|"+key, l.Limit)
+}
+
+// SetHeaders adds the RateLimit headers from the IETF draft, and Retry-After when the
+// request was refused.
+func (l *Limiter) SetHeaders(h http.Header, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(l.Limit.Capacity()))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", seconds(res.Reset))
+	h.Set("RateLimit-Policy", strconv.Itoa(l.Limit.Requests)+";w="+seconds(l.Limit.Period)+";burst="+strconv.Itoa(l.Limit.Capacity()))
+	if !res.Allowed {
+		h.Set("Retry-After", seconds(res.RetryAfter))
+	}
+}
+
+// seconds rounds d up to whole seconds, so clients never come back too early.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
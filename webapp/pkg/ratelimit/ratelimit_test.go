@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemoryStore()
+	m.Now = func() time.Time { return now }
+
+	ctx := context.Background()
+	limit := Limit{Requests: 60, Period: time.Minute, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		res, _ := m.Take(ctx, "a", limit)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("take %d: unexpected result %+v", i+1, res)
+		}
+	}
+
+	res, _ := m.Take(ctx, "a", limit)
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("expected the burst to be used up, got %+v", res)
+	}
+
+	// keys do not share buckets
+	if res, _ := m.Take(ctx, "b", limit); !res.Allowed {
+		t.Error("expected a new key to start full")
+	}
+
+	// one token a second comes back
+	now = now.Add(1500 * time.Millisecond)
+	if res, _ := m.Take(ctx, "a", limit); !res.Allowed {
+		t.Error("expected a token after a second")
+	}
+	if res, _ := m.Take(ctx, "a", limit); res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected half a token left, got %+v", res)
+	}
+
+	// buckets that have refilled are forgotten
+	now = now.Add(2 * time.Minute)
+	m.Take(ctx, "c", limit)
+	if len(m.buckets) != 1 {
+		t.Errorf("expected refilled buckets to be swept, have %d", len(m.buckets))
+	}
+}
+
+func TestLimiter_SetHeaders(t *testing.T) {
+	l := &Limiter{Limit: Limit{Requests: 10, Period: time.Minute, Burst: 5}}
+
+	h := make(http.Header)
+	l.SetHeaders(h, Result{Remaining: 0, Reset: 29500 * time.Millisecond, RetryAfter: 5900 * time.Millisecond})
+
+	expected := map[string]string{
+		"RateLimit-Limit":     "5",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "30",
+		"RateLimit-Policy":    "10;w=60;burst=5",
+		"Retry-After":         "6",
+	}
+	for k, v := range expected {
+		if h.Get(k) != v {
+			t.Errorf("expected %s %q but got %q", k, v, h.Get(k))
+		}
+	}
+
+	h = make(http.Header)
+	l.SetHeaders(h, Result{Allowed: true, Remaining: 4})
+	if h.Get("Retry-After") != "" {
+		t.Error("expected no Retry-After when allowed")
+	}
+}
@@ -0,0 +1,128 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+	"webapp/pkg/metrics"
+	"webapp/pkg/tracing"
+)
+
+// instrumentedDB runs statements on a pool, recording each one in metrics, which may be nil,
+// in the log with the request it was made for, and as a span of the request's trace.
+type instrumentedDB struct {
+	db      *sql.DB
+	metrics *metrics.DB
+}
+
+func (d instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := begin(ctx, d.metrics, operation(query))
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+// QueryRowContext records the query when it has run; errors from Scan are not counted,
+// which keeps sql.ErrNoRows out of the error count.
+func (d instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := begin(ctx, d.metrics, operation(query))
+	row := d.db.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+func (d instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := begin(ctx, d.metrics, operation(query))
+	res, err := d.db.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+func (d instrumentedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (instrumentedTx, error) {
+	tx, err := d.db.BeginTx(ctx, opts)
+	return instrumentedTx{tx: tx, ctx: ctx, metrics: d.metrics}, err
+}
+
+// instrumentedTx is instrumentedDB for a transaction.
+type instrumentedTx struct {
+	tx *sql.Tx
+	// ctx is the one the transaction was begun with, for recording the commit.
+	ctx     context.Context
+	metrics *metrics.DB
+}
+
+func (t instrumentedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := begin(ctx, t.metrics, operation(query))
+	res, err := t.tx.ExecContext(ctx, query, args...)
+	done(err)
+	return res, err
+}
+
+func (t instrumentedTx) Commit() error {
+	_, done := begin(t.ctx, t.metrics, "commit")
+	err := t.tx.Commit()
+	done(err)
+	return err
+}
+
+func (t instrumentedTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// begin starts recording a statement for operation, and returns the context to run it with
+// and the function to call with its outcome. Failures are logged as errors; with debug
+// logging on, every statement is logged.
+func begin(ctx context.Context, m *metrics.DB, operation string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, operation, tracing.KindClient,
+		tracing.Attr{Key: "db.system", Value: "postgresql"},
+		tracing.Attr{Key: "db.operation", Value: operation},
+	)
+
+	return ctx, func(err error) {
+		defer span.End()
+		m.Observe(operation, start, err)
+
+		ms := float64(time.Since(start).Microseconds()) / 1000
+		if err != nil && err != sql.ErrNoRows {
+			span.SetError(err)
+			slog.ErrorContext(ctx, "query failed", "operation", operation, "duration_ms", ms, "error", err)
+			return
+		}
+		slog.DebugContext(ctx, "query", "operation", operation, "duration_ms", ms)
+	}
+}
+
+// operation names a statement by its verb and table, e.g. "select users", so metrics are
+// grouped by what a query does without a series for every distinct statement.
+func operation(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown"
+	}
+
+	verb := fields[0]
+	var after string
+	switch verb {
+	case "select", "delete":
+		after = "from"
+	case "insert":
+		after = "into"
+	case "update":
+		if len(fields) > 1 {
+			return verb + " " + fields[1]
+		}
+		return verb
+	default:
+		return verb
+	}
+
+	for i, f := range fields[:len(fields)-1] {
+		if f == after {
+			return verb + " " + strings.Trim(fields[i+1], "(),;")
+		}
+	}
+	return verb
+}
@@ -0,0 +1,155 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+	"webapp/pkg/metrics"
+)
+
+// PostgresSessionStore is an scs session store using the same sessions table as scs's
+// postgresstore, so either can be used against the same database. It implements
+// scs.CtxStore, so its queries run with the request's context and are recorded like the
+// repository's.
+type PostgresSessionStore struct {
+	DB *sql.DB
+	// Metrics records query latency and errors, if set.
+	Metrics     *metrics.DB
+	stopCleanup chan bool
+}
+
+// NewPostgresSessionStore returns a store recording its queries in m, which may be nil, that
+// deletes expired sessions every cleanupInterval, or never if cleanupInterval is zero.
+func NewPostgresSessionStore(db *sql.DB, m *metrics.DB, cleanupInterval time.Duration) *PostgresSessionStore {
+	p := &PostgresSessionStore{DB: db, Metrics: m}
+	if cleanupInterval > 0 {
+		p.stopCleanup = make(chan bool)
+		go p.startCleanup(cleanupInterval)
+	}
+	return p
+}
+
+// conn is the pool with each statement recorded in Metrics.
+func (p *PostgresSessionStore) conn() instrumentedDB {
+	return instrumentedDB{db: p.DB, metrics: p.Metrics}
+}
+
+// Find returns the data for a session token, with found false if it does not exist or has expired.
+func (p *PostgresSessionStore) Find(token string) ([]byte, bool, error) {
+	return p.FindCtx(context.Background(), token)
+}
+
+// FindCtx is Find for a request's context.
+func (p *PostgresSessionStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var b []byte
+	err := p.conn().QueryRowContext(ctx, `select data from sessions where token = $1 and current_timestamp < expiry`, token).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// Commit adds or replaces the data for a session token.
+func (p *PostgresSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	return p.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// CommitCtx is Commit for a request's context.
+func (p *PostgresSessionStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+
+	_, err := p.conn().ExecContext(ctx, stmt, token, b, expiry)
+	return err
+}
+
+// Delete removes a session token and its data.
+func (p *PostgresSessionStore) Delete(token string) error {
+	return p.DeleteCtx(context.Background(), token)
+}
+
+// DeleteCtx is Delete for a request's context.
+func (p *PostgresSessionStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := p.conn().ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+// All returns the data for every session that has not expired.
+func (p *PostgresSessionStore) All() (map[string][]byte, error) {
+	return p.AllCtx(context.Background())
+}
+
+// AllCtx is All for a request's context.
+func (p *PostgresSessionStore) AllCtx(ctx context.Context) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := p.conn().QueryContext(ctx, `select token, data from sessions where current_timestamp < expiry`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var b []byte
+		if err := rows.Scan(&token, &b); err != nil {
+			return nil, err
+		}
+		sessions[token] = b
+	}
+
+	return sessions, rows.Err()
+}
+
+// StopCleanup stops the background cleanup, if it was started.
+func (p *PostgresSessionStore) StopCleanup() {
+	if p.stopCleanup != nil {
+		p.stopCleanup <- true
+	}
+}
+
+func (p *PostgresSessionStore) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.deleteExpired(); err != nil {
+				slog.Error("deleting expired sessions", "error", err)
+			}
+		case <-p.stopCleanup:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// deleteExpired removes expired sessions along with their entries in the per user index.
+func (p *PostgresSessionStore) deleteExpired() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := p.conn().ExecContext(ctx, `delete from sessions where expiry < current_timestamp`)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.conn().ExecContext(ctx, `delete from user_sessions where expires_at < $1`, time.Now())
+	return err
+}
@@ -3,17 +3,39 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
+	"strings"
 	"time"
 	"webapp/pkg/data"
-
-	"golang.org/x/crypto/bcrypt"
+	"webapp/pkg/metrics"
+	"webapp/pkg/passwords"
 )
 
 const dbTimeout = time.Second * 3
 
+// errEmptyPassword guards against storing a hash of nothing. Handlers are expected to
+// have applied the password policy already.
+var errEmptyPassword = errors.New("password must not be empty")
+
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// Hasher hashes new passwords, bcrypt at the default cost if nil.
+	Hasher passwords.Hasher
+	// Metrics records query latency and errors, if set.
+	Metrics *metrics.DB
+}
+
+// conn is the pool with each statement recorded in Metrics.
+func (m *PostgresDBRepo) conn() instrumentedDB {
+	return instrumentedDB{db: m.DB, metrics: m.Metrics}
+}
+
+func (m *PostgresDBRepo) hasher() passwords.Hasher {
+	if m.Hasher == nil {
+		return passwords.NewBcrypt(passwords.DefaultBcryptCost)
+	}
+	return m.Hasher
 }
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
@@ -21,14 +43,14 @@ func (m *PostgresDBRepo) Connection() *sql.DB {
 }
 
 // AllUsers returns all users as a slice of *data.User
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
 	from users order by last_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +71,7 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.UpdatedAt,
 		)
 		if err != nil {
-			log.Println("Error scanning", err)
+			slog.ErrorContext(ctx, "scanning user", "error", err)
 			return nil, err
 		}
 
@@ -60,13 +82,15 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 }
 
 // GetUser returns one user by id
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `
 		select
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin,
+			coalesce(u.totp_secret, ''), u.totp_enabled, u.created_at, u.updated_at,
+			coalesce(u.password_changed_at, '0001-01-01'), coalesce(u.email_verified_at, '0001-01-01'),
 			coalesce(ui.file_name, '')
 		from
 			users u
@@ -75,7 +99,7 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		    u.id = $1`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.conn().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -84,8 +108,12 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordChangedAt,
+		&user.EmailVerifiedAt,
 		&user.ProfilePic.FileName,
 	)
 
@@ -97,13 +125,15 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 }
 
 // GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `
 		select
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin,
+			coalesce(u.totp_secret, ''), u.totp_enabled, u.created_at, u.updated_at,
+			coalesce(u.password_changed_at, '0001-01-01'), coalesce(u.email_verified_at, '0001-01-01'),
 			coalesce(ui.file_name, '')
 		from
 			users u
@@ -112,7 +142,7 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		    u.email = $1`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.conn().QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -121,8 +151,12 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordChangedAt,
+		&user.EmailVerifiedAt,
 		&user.ProfilePic.FileName,
 	)
 
@@ -134,8 +168,8 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 }
 
 // UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
@@ -147,7 +181,7 @@ func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 		where id = $6
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	_, err := m.conn().ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
@@ -164,13 +198,13 @@ func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 }
 
 // DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	_, err := m.conn().ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
@@ -179,25 +213,30 @@ func (m *PostgresDBRepo) DeleteUser(id int) error {
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	if user.Password == "" {
+		return 0, errEmptyPassword
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := m.hasher().Hash(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, is_admin, email_verified_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = m.conn().QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		hashedPassword,
 		user.IsAdmin,
+		sql.NullTime{Time: user.EmailVerifiedAt, Valid: !user.EmailVerifiedAt.IsZero()},
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -210,17 +249,62 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	if password == "" {
+		return errEmptyPassword
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1, password_changed_at = $2, updated_at = $2 where id = $3`
+	_, err = m.conn().ExecContext(ctx, stmt, hashedPassword, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RehashPassword stores a fresh hash of a user's current password, after a login showed
+// oldHash was made with weaker settings. Unlike ResetPassword it leaves
+// password_changed_at alone, so existing sessions and tokens stay valid. Nothing changes
+// if the stored hash is no longer oldHash, so that a password reset made since the login
+// is not undone.
+func (m *PostgresDBRepo) RehashPassword(ctx context.Context, id int, oldHash, password string) error {
+	if password == "" {
+		return errEmptyPassword
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1 where id = $2 and password = $3`
+	_, err = m.conn().ExecContext(ctx, stmt, hashedPassword, id, oldHash)
 	if err != nil {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	return nil
+}
+
+// VerifyEmail records that a user has confirmed they own their email address.
+func (m *PostgresDBRepo) VerifyEmail(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set email_verified_at = $1, updated_at = $1 where id = $2`
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -229,12 +313,12 @@ func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 }
 
 // InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	_, err := m.conn().ExecContext(ctx, stmt, i.UserID)
 	if err != nil {
 		return 0, err
 	}
@@ -243,7 +327,7 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
 		values ($1, $2, $3, $4) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = m.conn().QueryRowContext(ctx, stmt,
 		i.UserID,
 		i.FileName,
 		time.Now(),
@@ -256,3 +340,483 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 
 	return newID, nil
 }
+
+// SetTOTPSecret stores a new TOTP secret for a user. Two-factor stays disabled until
+// the user confirms they can generate codes with it, see EnableTOTP.
+func (m *PostgresDBRepo) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_secret = $1, totp_enabled = false, updated_at = $2 where id = $3`
+	_, err := m.conn().ExecContext(ctx, stmt, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication for a user with a stored secret.
+func (m *PostgresDBRepo) EnableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_enabled = true, updated_at = $1 where id = $2 and totp_secret is not null`
+	res, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("no totp secret to enable")
+	}
+
+	return nil
+}
+
+// DisableTOTP turns off two-factor authentication, removing the secret and any recovery codes.
+func (m *PostgresDBRepo) DisableTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.conn().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update users set totp_secret = null, totp_enabled = false, updated_at = $1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `delete from user_recovery_codes where user_id = $1`
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards a user's existing recovery codes and stores the given hashes.
+func (m *PostgresDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.conn().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `delete from user_recovery_codes where user_id = $1`
+	_, err = tx.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into user_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code as used, reporting whether one matched.
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+	res, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// UseTOTPStep records step as the last TOTP time step the user gave a code for, reporting
+// false if it is not later than the one recorded before. The check and the update are one
+// statement, so that the same code sent twice at once is only accepted once.
+func (m *PostgresDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1
+		where id = $2 and (totp_last_step is null or totp_last_step < $1)`
+	res, err := m.conn().ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// GetLoginAttempts returns the failed login record for a throttling key, or an empty record if there is none.
+func (m *PostgresDBRepo) GetLoginAttempts(ctx context.Context, key string) (*data.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select key, failures, last_failure, locked_until from login_attempts where key = $1`
+
+	a := data.LoginAttempts{Key: key}
+	err := m.conn().QueryRowContext(ctx, query, key).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailure,
+		&a.LockedUntil,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// AddLoginFailure adds a failed login to the record for a throttling key, in one statement
+// so that concurrent failures are all counted, and returns the record. It follows
+// data.LoginAttempts.Add: the count starts again after ResetBefore or an ended lockout,
+// and the key is locked once it reaches MaxFailures.
+func (m *PostgresDBRepo) AddLoginFailure(ctx context.Context, f data.LoginFailure) (*data.LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	// $6 is the zero time, which stands for no lockout. A count that starts again is the
+	// same as a new record, which is what excluded holds.
+	stmt := `insert into login_attempts as a (key, failures, last_failure, locked_until)
+		values ($1, 1, $2, case when $4 <= 1 then $5 else $6 end)
+		on conflict (key) do update set
+			failures = case
+				when a.last_failure < $3 or (a.locked_until <> $6 and a.locked_until <= $2) then excluded.failures
+				else a.failures + 1 end,
+			last_failure = $2,
+			locked_until = case
+				when a.last_failure < $3 or (a.locked_until <> $6 and a.locked_until <= $2) then excluded.locked_until
+				when a.failures + 1 >= $4 then $5
+				else a.locked_until end
+		returning key, failures, last_failure, locked_until`
+
+	var a data.LoginAttempts
+	err := m.conn().QueryRowContext(ctx, stmt, f.Key, f.At, f.ResetBefore, f.MaxFailures, f.LockUntil, time.Time{}).Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailure,
+		&a.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// DeleteLoginAttempts removes the failed login record for a throttling key.
+func (m *PostgresDBRepo) DeleteLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from login_attempts where key = $1`
+
+	_, err := m.conn().ExecContext(ctx, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertAPIKey stores a new api key and returns its id.
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.conn().QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		strings.Join(k.Scopes, ","),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes,
+	coalesce(last_used_at, '0001-01-01'), coalesce(revoked_at, '0001-01-01'), created_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*data.APIKey, error) {
+	var k data.APIKey
+	var scopes string
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		&scopes,
+		&k.LastUsedAt,
+		&k.RevokedAt,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+
+	return &k, nil
+}
+
+// GetAPIKeyByPrefix returns the api key with the given visible prefix, including revoked keys.
+func (m *PostgresDBRepo) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where prefix = $1`
+
+	return scanAPIKey(m.conn().QueryRowContext(ctx, query, prefix))
+}
+
+// AllAPIKeys returns a user's api keys that have not been revoked, newest first.
+func (m *PostgresDBRepo) AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys
+		where user_id = $1 and revoked_at is null order by created_at desc, id desc`
+
+	rows, err := m.conn().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*data.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops one of a user's api keys from working. It returns sql.ErrNoRows if
+// the user has no such active key.
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and user_id = $3 and revoked_at is null`
+
+	res, err := m.conn().ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that an api key has just been used.
+func (m *PostgresDBRepo) TouchAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $1 where id = $2`
+
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertUserSession adds a logged in session to the per user index.
+func (m *PostgresDBRepo) InsertUserSession(ctx context.Context, s data.UserSession) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_sessions (user_id, token, ip, user_agent, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (token) do update set user_id = excluded.user_id, expires_at = excluded.expires_at`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		s.UserID,
+		s.Token,
+		s.IP,
+		s.UserAgent,
+		time.Now(),
+		s.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllUserSessions returns a user's sessions that have not expired, newest first.
+func (m *PostgresDBRepo) AllUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token, ip, user_agent, created_at, expires_at from user_sessions
+		where user_id = $1 and expires_at > $2 order by created_at desc, id desc`
+
+	rows, err := m.conn().QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*data.UserSession
+	for rows.Next() {
+		var s data.UserSession
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.Token,
+			&s.IP,
+			&s.UserAgent,
+			&s.CreatedAt,
+			&s.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteUserSession removes one of a user's sessions from the index and returns its token,
+// so that the session itself can be destroyed. It returns sql.ErrNoRows if there is no such session.
+func (m *PostgresDBRepo) DeleteUserSession(ctx context.Context, userID, id int) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var token string
+	err := m.conn().QueryRowContext(ctx, `delete from user_sessions where id = $1 and user_id = $2 returning token`, id, userID).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// DeleteOtherUserSessions removes all of a user's sessions except keepToken from the index
+// and returns their tokens. Pass an empty keepToken to remove them all.
+func (m *PostgresDBRepo) DeleteOtherUserSessions(ctx context.Context, userID int, keepToken string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := m.conn().QueryContext(ctx, `delete from user_sessions where user_id = $1 and token <> $2 returning token`, userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteAllUserSessions logs a user out of the web app everywhere, removing their sessions
+// from the index and the sessions themselves from the table PostgresSessionStore keeps
+// them in. Sessions the web app keeps in memory are out of reach.
+func (m *PostgresDBRepo) DeleteAllUserSessions(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `with deleted as (delete from user_sessions where user_id = $1 returning token)
+		delete from sessions where token in (select token from deleted)`
+
+	_, err := m.conn().ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// RevokeToken records that the token with id jti may not be used, until it expires anyway.
+// Tokens that have since expired are cleared out at the same time.
+func (m *PostgresDBRepo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.conn().ExecContext(ctx, `delete from revoked_tokens where expires_at < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into revoked_tokens (jti, expires_at) values ($1, $2) on conflict (jti) do nothing`
+	_, err = m.conn().ExecContext(ctx, stmt, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether the token with id jti has been revoked and not yet expired.
+func (m *PostgresDBRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var revoked bool
+	query := `select exists(select 1 from revoked_tokens where jti = $1 and expires_at >= $2)`
+	err := m.conn().QueryRowContext(ctx, query, jti, time.Now()).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
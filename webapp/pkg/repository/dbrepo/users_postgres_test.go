@@ -3,15 +3,20 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/passwords"
 	"webapp/pkg/repository"
 
+	"github.com/alexedwards/scs/v2"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -33,6 +38,12 @@ var pool *dockertest.Pool
 var testDB *sql.DB
 var testRepo repository.DatabaseRepo
 
+// ctx is what every repository call in these tests runs with.
+var ctx = context.Background()
+
+// testHasher checks the hashes testRepo stores, which uses the default.
+var testHasher = passwords.NewBcrypt(passwords.DefaultBcryptCost)
+
 func TestMain(m *testing.M) {
 
 	// connect to docker;
@@ -136,7 +147,7 @@ func TestPostgresDBRepo_InsertUser(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	id, err := testRepo.InsertUser(testUser)
+	id, err := testRepo.InsertUser(ctx, testUser)
 	if err != nil {
 		t.Errorf("insert user returned an error: %s", err)
 	}
@@ -147,7 +158,7 @@ func TestPostgresDBRepo_InsertUser(t *testing.T) {
 }
 
 func TestPostgresDBRepo_AllUsers(t *testing.T) {
-	users, err := testRepo.AllUsers()
+	users, err := testRepo.AllUsers(ctx)
 	if err != nil {
 		t.Errorf("failed to list users in database: %s", err)
 	}
@@ -166,9 +177,9 @@ func TestPostgresDBRepo_AllUsers(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	_, _ = testRepo.InsertUser(testUser)
+	_, _ = testRepo.InsertUser(ctx, testUser)
 
-	users, err = testRepo.AllUsers()
+	users, err = testRepo.AllUsers(ctx)
 	if err != nil {
 		t.Errorf("failed to list users in database: %s", err)
 	}
@@ -181,7 +192,7 @@ func TestPostgresDBRepo_AllUsers(t *testing.T) {
 
 func TestPostgresDBRepo_GetUser(t *testing.T) {
 
-	user, err := testRepo.GetUser(1)
+	user, err := testRepo.GetUser(ctx, 1)
 	if err != nil {
 		t.Errorf("error getting user by id: %s", err)
 	}
@@ -189,7 +200,7 @@ func TestPostgresDBRepo_GetUser(t *testing.T) {
 	if user.Email != "admin@example.com" {
 		t.Errorf("wrong email returned by GetUser, expected admin@example.com, got: %s", user.Email)
 	}
-	_, err = testRepo.GetUser(3)
+	_, err = testRepo.GetUser(ctx, 3)
 	if err == nil {
 		t.Error("no error reported when getting non existant user by id")
 	}
@@ -197,7 +208,7 @@ func TestPostgresDBRepo_GetUser(t *testing.T) {
 
 func TestPostgresDBRepo_GetUserByEmail(t *testing.T) {
 
-	user, err := testRepo.GetUserByEmail("Jack@example.com")
+	user, err := testRepo.GetUserByEmail(ctx, "Jack@example.com")
 	if err != nil {
 		t.Errorf("error getting user by id: %s", err)
 	}
@@ -206,23 +217,23 @@ func TestPostgresDBRepo_GetUserByEmail(t *testing.T) {
 		t.Errorf("wrong name returned by GetUserByEmail, expected Jack, got: %s", user.FirstName)
 	}
 
-	_, err = testRepo.GetUserByEmail("fake@email.com")
+	_, err = testRepo.GetUserByEmail(ctx, "fake@email.com")
 	if err == nil {
 		t.Error("no error reported when getting non existant user by email")
 	}
 }
 
 func TestPostgresDBRepo_UpdateUser(t *testing.T) {
-	user, _ := testRepo.GetUser(2)
+	user, _ := testRepo.GetUser(ctx, 2)
 	user.FirstName = "Jacky"
 	user.Email = "Jacky@example.com"
 
-	err := testRepo.UpdateUser(*user)
+	err := testRepo.UpdateUser(ctx, *user)
 	if err != nil {
 		t.Errorf("error updating user %d: %s", 2, err)
 	}
 
-	user, _ = testRepo.GetUser(2)
+	user, _ = testRepo.GetUser(ctx, 2)
 
 	if user.FirstName != "Jacky" {
 		t.Errorf("update to user failed, expected firstname of Jacky but got %s", user.FirstName)
@@ -233,26 +244,26 @@ func TestPostgresDBRepo_UpdateUser(t *testing.T) {
 }
 
 func TestPosgresDBRepo_DeleteUser(t *testing.T) {
-	err := testRepo.DeleteUser(2)
+	err := testRepo.DeleteUser(ctx, 2)
 	if err != nil {
 		t.Errorf("error deleting user: %s", err)
 	}
 
-	_, err = testRepo.GetUser(2)
+	_, err = testRepo.GetUser(ctx, 2)
 	if err == nil {
 		t.Error("no error returned when retrieving deleted user from database")
 	}
 }
 
 func TestPostgresDBRepo_ResetPassword(t *testing.T) {
-	err := testRepo.ResetPassword(1, "newPassword")
+	err := testRepo.ResetPassword(ctx, 1, "newPassword")
 	if err != nil {
 		t.Errorf("error updating user password: %s", err)
 	}
 
-	user, _ := testRepo.GetUser(1)
+	user, _ := testRepo.GetUser(ctx, 1)
 
-	matches, err := user.PasswordMatches("newPassword")
+	matches, err := testHasher.Verify(user.Password, "newPassword")
 	if err != nil {
 		t.Error(err)
 	}
@@ -260,6 +271,50 @@ func TestPostgresDBRepo_ResetPassword(t *testing.T) {
 	if !matches {
 		t.Error("password does not match newPassword")
 	}
+
+	if user.PasswordChangedAt.IsZero() {
+		t.Error("password_changed_at not set by ResetPassword")
+	}
+
+	err = testRepo.ResetPassword(ctx, 1, "")
+	if err == nil {
+		t.Error("expected an error resetting to an empty password")
+	}
+}
+
+func TestPostgresDBRepo_RehashPassword(t *testing.T) {
+	before, _ := testRepo.GetUser(ctx, 1)
+
+	err := testRepo.RehashPassword(ctx, 1, before.Password, "newPassword")
+	if err != nil {
+		t.Errorf("error rehashing user password: %s", err)
+	}
+
+	user, _ := testRepo.GetUser(ctx, 1)
+
+	if user.Password == before.Password {
+		t.Error("expected the stored hash to change")
+	}
+
+	matches, _ := testHasher.Verify(user.Password, "newPassword")
+	if !matches {
+		t.Error("password does not match newPassword after rehash")
+	}
+
+	if !user.PasswordChangedAt.Equal(before.PasswordChangedAt) {
+		t.Error("expected RehashPassword to leave password_changed_at alone")
+	}
+
+	// a reset since the login was checked, which replaced the hash, wins
+	err = testRepo.RehashPassword(ctx, 1, before.Password, "oldPassword")
+	if err != nil {
+		t.Errorf("error rehashing with a stale hash: %s", err)
+	}
+
+	after, _ := testRepo.GetUser(ctx, 1)
+	if after.Password != user.Password {
+		t.Error("expected a rehash of a stale hash to change nothing")
+	}
 }
 
 func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
@@ -269,7 +324,7 @@ func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	newID, err := testRepo.InsertUserImage(image)
+	newID, err := testRepo.InsertUserImage(ctx, image)
 	if err != nil {
 		t.Errorf("failed to insert user image: %s", err)
 	}
@@ -280,8 +335,386 @@ func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
 
 	image.UserID = -1
 
-	_, err = testRepo.InsertUserImage(image)
+	_, err = testRepo.InsertUserImage(ctx, image)
 	if err == nil {
 		t.Error("inserted a user image with non existant user id")
 	}
 }
+
+func TestPostgresDBRepo_TOTP(t *testing.T) {
+	err := testRepo.EnableTOTP(ctx, 1)
+	if err == nil {
+		t.Error("enabled totp for a user without a secret")
+	}
+
+	err = testRepo.SetTOTPSecret(ctx, 1, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Errorf("error setting totp secret: %s", err)
+	}
+
+	user, _ := testRepo.GetUser(ctx, 1)
+	if user.TOTPSecret != "JBSWY3DPEHPK3PXP" || user.TOTPEnabled {
+		t.Errorf("expected pending secret, got secret %q enabled %t", user.TOTPSecret, user.TOTPEnabled)
+	}
+
+	err = testRepo.EnableTOTP(ctx, 1)
+	if err != nil {
+		t.Errorf("error enabling totp: %s", err)
+	}
+
+	user, _ = testRepo.GetUserByEmail(ctx, "admin@example.com")
+	if !user.TOTPEnabled {
+		t.Error("totp not enabled after EnableTOTP")
+	}
+
+	err = testRepo.DisableTOTP(ctx, 1)
+	if err != nil {
+		t.Errorf("error disabling totp: %s", err)
+	}
+
+	user, _ = testRepo.GetUser(ctx, 1)
+	if user.TOTPSecret != "" || user.TOTPEnabled {
+		t.Error("totp still set after DisableTOTP")
+	}
+}
+
+func TestPostgresDBRepo_RecoveryCodes(t *testing.T) {
+	err := testRepo.ReplaceRecoveryCodes(ctx, 1, []string{"hash-one", "hash-two"})
+	if err != nil {
+		t.Errorf("error storing recovery codes: %s", err)
+	}
+
+	used, err := testRepo.UseRecoveryCode(ctx, 1, "hash-one")
+	if err != nil {
+		t.Errorf("error using recovery code: %s", err)
+	}
+	if !used {
+		t.Error("valid recovery code was not accepted")
+	}
+
+	used, _ = testRepo.UseRecoveryCode(ctx, 1, "hash-one")
+	if used {
+		t.Error("recovery code accepted twice")
+	}
+
+	_ = testRepo.ReplaceRecoveryCodes(ctx, 1, []string{"hash-three"})
+
+	used, _ = testRepo.UseRecoveryCode(ctx, 1, "hash-two")
+	if used {
+		t.Error("replaced recovery code still accepted")
+	}
+}
+
+func TestPostgresDBRepo_UseTOTPStep(t *testing.T) {
+	for _, e := range []struct {
+		step     int64
+		expected bool
+	}{
+		{100, true},
+		{100, false},
+		{99, false},
+		{101, true},
+	} {
+		ok, err := testRepo.UseTOTPStep(ctx, 1, e.step)
+		if err != nil {
+			t.Errorf("error using step %d: %s", e.step, err)
+		}
+		if ok != e.expected {
+			t.Errorf("step %d: expected %t but got %t", e.step, e.expected, ok)
+		}
+	}
+}
+
+func TestPostgresDBRepo_LoginAttempts(t *testing.T) {
+	a, err := testRepo.GetLoginAttempts(ctx, "account:admin@example.com")
+	if err != nil {
+		t.Errorf("error getting missing login attempts: %s", err)
+	}
+	if a.Failures != 0 {
+		t.Errorf("expected no failures, got %d", a.Failures)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	failure := data.LoginFailure{
+		Key:         "account:admin@example.com",
+		At:          now,
+		ResetBefore: now.Add(-time.Hour),
+		MaxFailures: 5,
+		LockUntil:   now.Add(time.Minute),
+	}
+
+	// failures made at the same time are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := testRepo.AddLoginFailure(ctx, failure)
+			if err != nil {
+				t.Errorf("error adding login failure: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	a, _ = testRepo.GetLoginAttempts(ctx, "account:admin@example.com")
+	if a.Failures != 4 || !a.LockedUntil.IsZero() {
+		t.Errorf("expected 4 failures and no lockout, got %d until %s", a.Failures, a.LockedUntil)
+	}
+
+	a, err = testRepo.AddLoginFailure(ctx, failure)
+	if err != nil {
+		t.Errorf("error adding login failure: %s", err)
+	}
+	if a.Failures != 5 || !a.LockedUntil.Equal(failure.LockUntil) {
+		t.Errorf("expected a lockout at 5 failures, got %d until %s", a.Failures, a.LockedUntil)
+	}
+
+	// once the lockout has ended, the count starts again
+	later := failure
+	later.At = failure.LockUntil
+	a, _ = testRepo.AddLoginFailure(ctx, later)
+	if a.Failures != 1 || !a.LockedUntil.IsZero() {
+		t.Errorf("expected a fresh count after the lockout, got %d until %s", a.Failures, a.LockedUntil)
+	}
+
+	err = testRepo.DeleteLoginAttempts(ctx, "account:admin@example.com")
+	if err != nil {
+		t.Errorf("error deleting login attempts: %s", err)
+	}
+
+	a, _ = testRepo.GetLoginAttempts(ctx, "account:admin@example.com")
+	if a.Failures != 0 {
+		t.Errorf("expected deleted record to have no failures, got %d", a.Failures)
+	}
+}
+
+func TestPostgresDBRepo_VerifyEmail(t *testing.T) {
+	user, _ := testRepo.GetUser(ctx, 1)
+	if !user.EmailVerifiedAt.IsZero() {
+		t.Error("new user should not have a verified email")
+	}
+
+	err := testRepo.VerifyEmail(ctx, 1)
+	if err != nil {
+		t.Errorf("error verifying email: %s", err)
+	}
+
+	user, _ = testRepo.GetUser(ctx, 1)
+	if user.EmailVerifiedAt.IsZero() {
+		t.Error("email_verified_at not set by VerifyEmail")
+	}
+}
+
+func TestPostgresDBRepo_APIKeys(t *testing.T) {
+	id, err := testRepo.InsertAPIKey(ctx, data.APIKey{
+		UserID: 1,
+		Name:   "backup script",
+		Prefix: "wa_12345678",
+		Hash:   "somehash",
+		Scopes: []string{"read", "write"},
+	})
+	if err != nil {
+		t.Fatalf("error inserting api key: %s", err)
+	}
+
+	k, err := testRepo.GetAPIKeyByPrefix(ctx, "wa_12345678")
+	if err != nil {
+		t.Fatalf("error getting api key: %s", err)
+	}
+	if k.ID != id || k.Hash != "somehash" || len(k.Scopes) != 2 || !k.LastUsedAt.IsZero() {
+		t.Errorf("unexpected api key %+v", k)
+	}
+
+	err = testRepo.TouchAPIKey(ctx, id)
+	if err != nil {
+		t.Errorf("error touching api key: %s", err)
+	}
+
+	keys, _ := testRepo.AllAPIKeys(ctx, 1)
+	if len(keys) != 1 || keys[0].LastUsedAt.IsZero() {
+		t.Errorf("expected one used api key, got %+v", keys)
+	}
+
+	err = testRepo.RevokeAPIKey(ctx, 1, id)
+	if err != nil {
+		t.Errorf("error revoking api key: %s", err)
+	}
+
+	err = testRepo.RevokeAPIKey(ctx, 1, id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows revoking twice, got %v", err)
+	}
+
+	keys, _ = testRepo.AllAPIKeys(ctx, 1)
+	if len(keys) != 0 {
+		t.Errorf("expected revoked key to be left out, got %d keys", len(keys))
+	}
+}
+
+func TestPostgresDBRepo_UserSessions(t *testing.T) {
+	for _, token := range []string{"token-one", "token-two", "token-three"} {
+		err := testRepo.InsertUserSession(ctx, data.UserSession{
+			UserID:    1,
+			Token:     token,
+			IP:        "192.0.2.1",
+			UserAgent: "Firefox",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("error inserting user session: %s", err)
+		}
+	}
+
+	_ = testRepo.InsertUserSession(ctx, data.UserSession{UserID: 1, Token: "expired", ExpiresAt: time.Now().Add(-time.Hour)})
+
+	sessions, err := testRepo.AllUserSessions(ctx, 1)
+	if err != nil {
+		t.Fatalf("error listing user sessions: %s", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expected 3 active sessions but got %d", len(sessions))
+	}
+
+	token, err := testRepo.DeleteUserSession(ctx, 1, sessions[0].ID)
+	if err != nil || token != sessions[0].Token {
+		t.Errorf("expected to delete session with token %s, got %s, %v", sessions[0].Token, token, err)
+	}
+
+	_, err = testRepo.DeleteUserSession(ctx, 2, sessions[1].ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows deleting another user's session, got %v", err)
+	}
+
+	tokens, err := testRepo.DeleteOtherUserSessions(ctx, 1, sessions[1].Token)
+	if err != nil {
+		t.Errorf("error deleting other sessions: %s", err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 deleted tokens (including the expired one) but got %v", tokens)
+	}
+
+	sessions, _ = testRepo.AllUserSessions(ctx, 1)
+	if len(sessions) != 1 {
+		t.Errorf("expected only the kept session to remain, got %d", len(sessions))
+	}
+}
+
+func TestPostgresDBRepo_DeleteAllUserSessions(t *testing.T) {
+	store := NewPostgresSessionStore(testRepo.Connection(), nil, 0)
+
+	for _, s := range []data.UserSession{
+		{UserID: 1, Token: "all-one", ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 2, Token: "all-two", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		_ = store.Commit(s.Token, []byte("data"), s.ExpiresAt)
+		err := testRepo.InsertUserSession(ctx, s)
+		if err != nil {
+			t.Fatalf("error inserting user session: %s", err)
+		}
+	}
+
+	err := testRepo.DeleteAllUserSessions(ctx, 1)
+	if err != nil {
+		t.Fatalf("error deleting sessions: %s", err)
+	}
+
+	if _, found, _ := store.Find("all-one"); found {
+		t.Error("expected the user's session to be deleted")
+	}
+	if sessions, _ := testRepo.AllUserSessions(ctx, 1); len(sessions) != 0 {
+		t.Errorf("expected the user's sessions to be gone from the index, got %d", len(sessions))
+	}
+	if _, found, _ := store.Find("all-two"); !found {
+		t.Error("another user's session was deleted")
+	}
+}
+
+func TestPostgresSessionStore(t *testing.T) {
+	store := NewPostgresSessionStore(testRepo.Connection(), nil, 0)
+
+	var _ scs.CtxStore = store
+	var _ scs.IterableCtxStore = store
+
+	err := store.Commit("session-token", []byte("data"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error committing session: %s", err)
+	}
+
+	err = store.Commit("session-token", []byte("new data"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error recommitting session: %s", err)
+	}
+
+	_ = store.Commit("expired-token", []byte("old"), time.Now().Add(-time.Hour))
+
+	b, found, err := store.Find("session-token")
+	if err != nil || !found || string(b) != "new data" {
+		t.Errorf("expected to find new data, got %q %v %v", b, found, err)
+	}
+
+	_, found, _ = store.Find("expired-token")
+	if found {
+		t.Error("did not expect to find an expired session")
+	}
+
+	all, err := store.All()
+	if err != nil || len(all) != 1 {
+		t.Errorf("expected one active session, got %d %v", len(all), err)
+	}
+
+	err = store.deleteExpired()
+	if err != nil {
+		t.Errorf("error deleting expired sessions: %s", err)
+	}
+
+	err = store.Delete("session-token")
+	if err != nil {
+		t.Errorf("error deleting session: %s", err)
+	}
+
+	_, found, _ = store.Find("session-token")
+	if found {
+		t.Error("did not expect to find a deleted session")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := store.FindCtx(cancelled, "session-token"); err == nil {
+		t.Error("expected FindCtx to stop with the request's context")
+	}
+}
+
+func TestPostgresDBRepo_RevokedTokens(t *testing.T) {
+	err := testRepo.RevokeToken(ctx, "jti-one", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error revoking token: %s", err)
+	}
+
+	// revoking twice is harmless
+	err = testRepo.RevokeToken(ctx, "jti-one", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("error revoking token again: %s", err)
+	}
+
+	_ = testRepo.RevokeToken(ctx, "jti-expired", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		jti      string
+		expected bool
+	}{
+		{"jti-one", true},
+		{"jti-expired", false},
+		{"jti-unknown", false},
+	}
+
+	for _, e := range tests {
+		revoked, err := testRepo.IsTokenRevoked(ctx, e.jti)
+		if err != nil {
+			t.Errorf("%s: %s", e.jti, err)
+		}
+		if revoked != e.expected {
+			t.Errorf("%s: expected revoked %v but got %v", e.jti, e.expected, revoked)
+		}
+	}
+}
@@ -1,25 +1,109 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
+	"webapp/pkg/apikey"
 	"webapp/pkg/data"
+	"webapp/pkg/mfa"
 )
 
-type TestDBRepo struct{}
+// TestTOTPSecret and TestRecoveryCode belong to the two-factor enabled test user, mfa@example.com.
+const (
+	TestTOTPSecret   = "JBSWY3DPEHPK3PXP"
+	TestRecoveryCode = "abcd-efgh"
+)
+
+// Api keys belonging to admin@example.com: a read only key, a key with every scope and a
+// key that has been revoked.
+const (
+	TestReadAPIKey    = "wa_00000001_000000000000000000000000000000000000000000000001"
+	TestAdminAPIKey   = "wa_00000002_000000000000000000000000000000000000000000000002"
+	TestRevokedAPIKey = "wa_00000003_000000000000000000000000000000000000000000000003"
+)
+
+// TestSessionToken is the token of admin@example.com's first indexed web session; they
+// have a second one on another device.
+const TestSessionToken = "test-session-1"
+
+func testUserSessions() []*data.UserSession {
+	return []*data.UserSession{
+		{ID: 1, UserID: 1, Token: TestSessionToken, IP: "192.0.2.1", UserAgent: "Firefox",
+			CreatedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: 2, UserID: 1, Token: "test-session-2", IP: "198.51.100.7", UserAgent: "Safari",
+			CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(time.Hour)},
+	}
+}
+
+func testAPIKeys() []*data.APIKey {
+	return []*data.APIKey{
+		{ID: 1, UserID: 1, Name: "read only", Prefix: "wa_00000001", Hash: apikey.Hash(TestReadAPIKey), Scopes: []string{apikey.ScopeRead}},
+		{ID: 2, UserID: 1, Name: "everything", Prefix: "wa_00000002", Hash: apikey.Hash(TestAdminAPIKey),
+			Scopes: []string{apikey.ScopeRead, apikey.ScopeWrite, apikey.ScopeAdmin}},
+		{ID: 3, UserID: 1, Name: "revoked", Prefix: "wa_00000003", Hash: apikey.Hash(TestRevokedAPIKey),
+			Scopes: []string{apikey.ScopeRead}, RevokedAt: time.Now().Add(-time.Hour)},
+	}
+}
+
+type TestDBRepo struct {
+	// Rehashed holds the ids passed to RehashPassword.
+	Rehashed []int
+	// TouchedAPIKeys holds the ids passed to TouchAPIKey.
+	TouchedAPIKeys []int
+	// Sessions holds the sessions passed to InsertUserSession.
+	Sessions []data.UserSession
+	// Inserted holds the users passed to InsertUser.
+	Inserted []data.User
+	// LoggedOut holds the ids passed to DeleteAllUserSessions.
+	LoggedOut []int
+
+	// totpSteps holds the last step passed to UseTOTPStep for each user.
+	totpSteps map[int]int64
+}
+
+func testMFAUser() *data.User {
+	return &data.User{
+		ID:          3,
+		FirstName:   "MFA",
+		LastName:    "User",
+		Email:       "mfa@example.com",
+		Password:    "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+		TOTPSecret:  TestTOTPSecret,
+		TOTPEnabled: true,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+
+		PasswordChangedAt: time.Now().Add(-time.Hour),
+		EmailVerifiedAt:   time.Now().Add(-time.Hour),
+	}
+}
 
 func (m *TestDBRepo) Connection() *sql.DB {
 	return nil
 }
 
+// testUnverifiedUser has signed up but not yet followed their verification link.
+func testUnverifiedUser() *data.User {
+	return &data.User{
+		ID:        4,
+		FirstName: "Unverified",
+		LastName:  "User",
+		Email:     "unverified@example.com",
+		Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
 // AllUsers returns all users as a slice of *data.User
-func (m *TestDBRepo) AllUsers() ([]*data.User, error) {
+func (m *TestDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
 	return []*data.User{}, nil
 }
 
 // GetUser returns one user by id
-func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
+func (m *TestDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
 	var user = data.User{}
 	if id == 1 {
 		user = data.User{
@@ -27,14 +111,24 @@ func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
 			FirstName: "Admin",
 			LastName:  "User",
 			Email:     "admin@example.com",
+			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			IsAdmin:   1,
+
+			EmailVerifiedAt: time.Now().Add(-time.Hour),
 		}
 		return &user, nil
 	}
+	if id == 3 {
+		return testMFAUser(), nil
+	}
+	if id == 4 {
+		return testUnverifiedUser(), nil
+	}
 	return nil, errors.New("user not found")
 }
 
 // GetUserByEmail returns one user by email address
-func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
+func (m *TestDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
 	if email == "admin@example.com" {
 		user := &data.User{
 			ID:        1,
@@ -44,36 +138,194 @@ func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
 			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
 			IsAdmin:   1,
 			CreatedAt: time.Now(),
-			UpdatedAt: time.Now()}
+			UpdatedAt: time.Now(),
+
+			EmailVerifiedAt: time.Now().Add(-time.Hour),
+		}
 		return user, nil
 	}
+	if email == "mfa@example.com" {
+		return testMFAUser(), nil
+	}
+	if email == "unverified@example.com" {
+		return testUnverifiedUser(), nil
+	}
 	return nil, errors.New("not found")
 }
 
 // UpdateUser updates one user in the database
-func (m *TestDBRepo) UpdateUser(u data.User) error {
-	if u.ID == 1 {
+func (m *TestDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	if u.ID == 1 || u.ID == 3 || u.ID == 4 {
 		return nil
 	}
 	return errors.New("update failed - no user found")
 }
 
 // DeleteUser deletes one user from the database, by id
-func (m *TestDBRepo) DeleteUser(id int) error {
+func (m *TestDBRepo) DeleteUser(ctx context.Context, id int) error {
 	return nil
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *TestDBRepo) InsertUser(user data.User) (int, error) {
+func (m *TestDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	m.Inserted = append(m.Inserted, user)
 	return -1, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *TestDBRepo) ResetPassword(id int, password string) error {
+func (m *TestDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	return nil
+}
+
+func (m *TestDBRepo) RehashPassword(ctx context.Context, id int, oldHash, password string) error {
+	m.Rehashed = append(m.Rehashed, id)
+	return nil
+}
+
+// VerifyEmail records that a user has confirmed they own their email address.
+func (m *TestDBRepo) VerifyEmail(ctx context.Context, id int) error {
 	return nil
 }
 
 // InsertUserImage inserts a user profile image into the database.
-func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
+func (m *TestDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
 	return -2, nil
 }
+
+// SetTOTPSecret stores a new TOTP secret for a user.
+func (m *TestDBRepo) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication for a user with a stored secret.
+func (m *TestDBRepo) EnableTOTP(ctx context.Context, userID int) error {
+	return nil
+}
+
+// DisableTOTP turns off two-factor authentication, removing the secret and any recovery codes.
+func (m *TestDBRepo) DisableTOTP(ctx context.Context, userID int) error {
+	return nil
+}
+
+// ReplaceRecoveryCodes discards a user's existing recovery codes and stores the given hashes.
+func (m *TestDBRepo) ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, reporting whether one matched.
+func (m *TestDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	return userID == 3 && hash == mfa.HashRecoveryCode(TestRecoveryCode), nil
+}
+
+// UseTOTPStep records the last TOTP time step a user gave a code for, reporting false if
+// step is not later.
+func (m *TestDBRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if m.totpSteps == nil {
+		m.totpSteps = make(map[int]int64)
+	}
+	if last, ok := m.totpSteps[userID]; ok && step <= last {
+		return false, nil
+	}
+	m.totpSteps[userID] = step
+	return true, nil
+}
+
+// GetLoginAttempts returns the failed login record for a throttling key.
+func (m *TestDBRepo) GetLoginAttempts(ctx context.Context, key string) (*data.LoginAttempts, error) {
+	return &data.LoginAttempts{Key: key}, nil
+}
+
+// AddLoginFailure adds a failed login to the record for a throttling key.
+func (m *TestDBRepo) AddLoginFailure(ctx context.Context, f data.LoginFailure) (*data.LoginAttempts, error) {
+	a := data.LoginAttempts{}.Add(f)
+	return &a, nil
+}
+
+// DeleteLoginAttempts removes the failed login record for a throttling key.
+func (m *TestDBRepo) DeleteLoginAttempts(ctx context.Context, key string) error {
+	return nil
+}
+
+func (m *TestDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	return 4, nil
+}
+
+func (m *TestDBRepo) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error) {
+	for _, k := range testAPIKeys() {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *TestDBRepo) AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error) {
+	var keys []*data.APIKey
+	for _, k := range testAPIKeys() {
+		if k.UserID == userID && k.RevokedAt.IsZero() {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *TestDBRepo) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	for _, k := range testAPIKeys() {
+		if k.ID == id && k.UserID == userID && k.RevokedAt.IsZero() {
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *TestDBRepo) TouchAPIKey(ctx context.Context, id int) error {
+	m.TouchedAPIKeys = append(m.TouchedAPIKeys, id)
+	return nil
+}
+
+func (m *TestDBRepo) InsertUserSession(ctx context.Context, s data.UserSession) error {
+	m.Sessions = append(m.Sessions, s)
+	return nil
+}
+
+func (m *TestDBRepo) AllUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error) {
+	var sessions []*data.UserSession
+	for _, s := range testUserSessions() {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *TestDBRepo) DeleteUserSession(ctx context.Context, userID, id int) (string, error) {
+	for _, s := range testUserSessions() {
+		if s.ID == id && s.UserID == userID {
+			return s.Token, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (m *TestDBRepo) DeleteOtherUserSessions(ctx context.Context, userID int, keepToken string) ([]string, error) {
+	var tokens []string
+	for _, s := range testUserSessions() {
+		if s.UserID == userID && s.Token != keepToken {
+			tokens = append(tokens, s.Token)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *TestDBRepo) DeleteAllUserSessions(ctx context.Context, userID int) error {
+	m.LoggedOut = append(m.LoggedOut, userID)
+	return nil
+}
+
+func (m *TestDBRepo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *TestDBRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, nil
+}
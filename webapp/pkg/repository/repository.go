@@ -1,18 +1,43 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 	"webapp/pkg/data"
 )
 
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	InsertUser(user data.User) (int, error)
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
+	AllUsers(ctx context.Context) ([]*data.User, error)
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	UpdateUser(ctx context.Context, u data.User) error
+	DeleteUser(ctx context.Context, id int) error
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+	RehashPassword(ctx context.Context, id int, oldHash, password string) error
+	VerifyEmail(ctx context.Context, id int) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int) error
+	DisableTOTP(ctx context.Context, userID int) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	GetLoginAttempts(ctx context.Context, key string) (*data.LoginAttempts, error)
+	AddLoginFailure(ctx context.Context, f data.LoginFailure) (*data.LoginAttempts, error)
+	DeleteLoginAttempts(ctx context.Context, key string) error
+	InsertAPIKey(ctx context.Context, k data.APIKey) (int, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error)
+	AllAPIKeys(ctx context.Context, userID int) ([]*data.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id int) error
+	TouchAPIKey(ctx context.Context, id int) error
+	InsertUserSession(ctx context.Context, s data.UserSession) error
+	AllUserSessions(ctx context.Context, userID int) ([]*data.UserSession, error)
+	DeleteUserSession(ctx context.Context, userID, id int) (string, error)
+	DeleteOtherUserSessions(ctx context.Context, userID int, keepToken string) ([]string, error)
+	DeleteAllUserSessions(ctx context.Context, userID int) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
 }
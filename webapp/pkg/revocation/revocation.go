@@ -0,0 +1,52 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store records the ids (jti) of tokens that were revoked before they expired. Entries
+// only need to be kept until then. Both MemoryStore and the database repositories implement it.
+type Store interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// MemoryStore keeps revoked token ids in process. They are lost on restart and are not
+// shared between instances; use the database store when running more than one.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	Now    func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]time.Time), Now: time.Now}
+}
+
+// RevokeToken records jti as revoked until expiresAt, and forgets tokens that have expired.
+func (m *MemoryStore) RevokeToken(_ context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	for id, exp := range m.tokens {
+		if exp.Before(now) {
+			delete(m.tokens, id)
+		}
+	}
+
+	m.tokens[jti] = expiresAt
+	return nil
+}
+
+// IsTokenRevoked reports whether jti has been revoked and has not yet expired.
+func (m *MemoryStore) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exp, ok := m.tokens[jti]
+	return ok && !exp.Before(m.Now()), nil
+}
@@ -0,0 +1,45 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	m := NewMemoryStore()
+	m.Now = func() time.Time { return now }
+
+	_ = m.RevokeToken(context.Background(), "short", now.Add(time.Minute))
+	_ = m.RevokeToken(context.Background(), "long", now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		jti      string
+		after    time.Duration
+		expected bool
+	}{
+		{"revoked", "short", 0, true},
+		{"not revoked", "other", 0, false},
+		{"expired", "short", 2 * time.Minute, false},
+		{"still revoked", "long", 2 * time.Minute, true},
+	}
+
+	for _, e := range tests {
+		now = time.Now().Add(e.after)
+		revoked, err := m.IsTokenRevoked(context.Background(), e.jti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, revoked)
+		}
+	}
+
+	// expired entries are dropped on the next revocation
+	_ = m.RevokeToken(context.Background(), "another", now.Add(time.Hour))
+	if _, ok := m.tokens["short"]; ok {
+		t.Error("expected expired token to be forgotten")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"webapp/pkg/config"
+)
+
+// Runner serves HTTP until the process is asked to stop, then stops taking new requests,
+// lets the ones in flight finish and closes whatever the handlers were using.
+type Runner struct {
+	Server *http.Server
+	// ShutdownTimeout is how long in-flight requests get to finish before their connections
+	// are closed.
+	ShutdownTimeout time.Duration
+	// Signals stop the server; SIGINT and SIGTERM unless set otherwise.
+	Signals []os.Signal
+
+	closers []func() error
+	// redirect sends plain HTTP to the HTTPS server, if ConfigureTLS set it up.
+	redirect *http.Server
+}
+
+// New returns a Runner for handler on addr with the timeouts in cfg.
+func New(addr string, handler http.Handler, cfg config.Server) *Runner {
+	return &Runner{
+		Server: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		Signals:         []os.Signal{os.Interrupt, syscall.SIGTERM},
+	}
+}
+
+// OnShutdown adds f to what is closed once the server has stopped. Like deferred calls,
+// they run in the reverse of the order they were added, so things should be added right
+// after they are opened.
+func (r *Runner) OnShutdown(f func() error) {
+	r.closers = append(r.closers, f)
+}
+
+// Run listens on the server's address and serves until ctx is done or a signal arrives.
+func (r *Runner) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", r.Server.Addr)
+	if err != nil {
+		r.close()
+		return err
+	}
+	return r.Serve(ctx, ln)
+}
+
+// Serve is Run on a listener that is already open. It serves HTTPS if the server has a
+// TLS config, and returns nil after a clean shutdown.
+func (r *Runner) Serve(ctx context.Context, ln net.Listener) error {
+	ctx, stop := signal.NotifyContext(ctx, r.Signals...)
+	defer stop()
+
+	servers := []*http.Server{r.Server}
+	if r.redirect != nil {
+		redirectLn, err := net.Listen("tcp", r.redirect.Addr)
+		if err != nil {
+			ln.Close()
+			r.close()
+			return err
+		}
+		servers = append(servers, r.redirect)
+		go func() {
+			err := r.redirect.Serve(redirectLn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("redirect listener stopped", "addr", r.redirect.Addr, "error", err)
+			}
+		}()
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if r.Server.TLSConfig != nil {
+			errs <- r.Server.ServeTLS(ln, "", "")
+		} else {
+			errs <- r.Server.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		// the server failed by itself; there is nothing to drain
+		for _, s := range servers[1:] {
+			_ = s.Close()
+		}
+		r.close()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, waiting for requests in flight", "timeout", r.ShutdownTimeout)
+
+	err := r.shutdown(servers)
+	if cerr := r.close(); err == nil {
+		err = cerr
+	}
+
+	if serr := <-errs; err == nil && !errors.Is(serr, http.ErrServerClosed) {
+		err = serr
+	}
+
+	return err
+}
+
+func (r *Runner) shutdown(servers []*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.ShutdownTimeout)
+	defer cancel()
+
+	var err error
+	for _, s := range servers {
+		if serr := s.Shutdown(ctx); err == nil {
+			err = serr
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		// give up on the stragglers
+		for _, s := range servers {
+			_ = s.Close()
+		}
+		return fmt.Errorf("requests still running after %s were cut off", r.ShutdownTimeout)
+	}
+	return err
+}
+
+// close runs the closers, returning the first error after trying them all.
+func (r *Runner) close() error {
+	var first error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		err := r.closers[i]()
+		if err != nil {
+			slog.Error("closing on shutdown", "error", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	r.closers = nil
+	return first
+}
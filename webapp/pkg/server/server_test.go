@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+	"webapp/pkg/config"
+)
+
+func startRunner(t *testing.T, handler http.Handler, shutdownTimeout time.Duration) (*Runner, string, context.CancelFunc, chan error) {
+	cfg := config.Default().Server
+	cfg.ShutdownTimeout = shutdownTimeout
+	r := New("127.0.0.1:0", handler, cfg)
+
+	ln, err := net.Listen("tcp", r.Server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve(ctx, ln)
+	}()
+
+	return r, "http://" + ln.Addr().String(), cancel, done
+}
+
+func TestRunner_drainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	r, url, cancel, done := startRunner(t, handler, 5*time.Second)
+
+	var order []string
+	r.OnShutdown(func() error { order = append(order, "db"); return nil })
+	r.OnShutdown(func() error { order = append(order, "sessions"); return nil })
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	cancel()
+
+	if code := <-status; code != http.StatusTeapot {
+		t.Errorf("expected the request in flight to finish, got status %d", code)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("expected a clean shutdown but got %v", err)
+	}
+
+	if len(order) != 2 || order[0] != "sessions" || order[1] != "db" {
+		t.Errorf("expected closers to run in reverse order, got %v", order)
+	}
+
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected the server to have stopped listening")
+	}
+}
+
+func TestRunner_shutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	r, url, cancel, done := startRunner(t, handler, 50*time.Millisecond)
+
+	closed := false
+	r.OnShutdown(func() error { closed = true; return nil })
+
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error when requests outlast the deadline")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not respect its deadline")
+	}
+
+	if !closed {
+		t.Error("expected closers to run after a forced shutdown")
+	}
+}
+
+func TestRunner_listenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	r := New(ln.Addr().String(), http.NotFoundHandler(), config.Default().Server)
+	closed := false
+	r.OnShutdown(func() error { closed = true; return nil })
+
+	if err := r.Run(context.Background()); err == nil {
+		t.Error("expected an error for an address in use")
+	}
+	if !closed {
+		t.Error("expected closers to run when the server cannot start")
+	}
+}
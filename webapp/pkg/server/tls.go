@@ -0,0 +1,211 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"webapp/pkg/config"
+)
+
+// ConfigureTLS makes the server use HTTPS as t says, and adds the redirect listener if
+// there is one. It does nothing if t is not enabled. HTTP/2 is negotiated automatically.
+func (r *Runner) ConfigureTLS(t config.TLS) error {
+	if !t.Enabled() {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if t.SelfSigned {
+		cert, err := SelfSigned("localhost", "127.0.0.1", "::1")
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else {
+		reloader, err := NewCertReloader(t.CertFile, t.KeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	}
+
+	r.Server.TLSConfig = tlsConfig
+
+	if t.RedirectPort != 0 {
+		_, port, err := net.SplitHostPort(r.Server.Addr)
+		if err != nil {
+			return err
+		}
+		httpsPort, err := strconv.Atoi(port)
+		if err != nil {
+			return err
+		}
+		r.redirect = &http.Server{
+			Addr:              ":" + strconv.Itoa(t.RedirectPort),
+			Handler:           RedirectHandler(httpsPort),
+			ReadHeaderTimeout: r.Server.ReadHeaderTimeout,
+			ReadTimeout:       r.Server.ReadTimeout,
+			WriteTimeout:      r.Server.WriteTimeout,
+			IdleTimeout:       r.Server.IdleTimeout,
+		}
+	}
+
+	return nil
+}
+
+// RedirectHandler sends every request to the same host and path over HTTPS on httpsPort.
+// 308 keeps the method and body, so forms posted to the old address still work.
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// certCheckInterval limits how often CertReloader looks at the files.
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves a certificate from files that may be replaced while the server runs,
+// as they are by certificate renewal tools. If the new files cannot be loaded, it keeps
+// serving the last good certificate.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// CheckInterval is the least time between looking for changed files.
+	CheckInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificate in certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{CertFile: certFile, KeyFile: keyFile, CheckInterval: certCheckInterval}
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate is for tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= c.CheckInterval {
+		c.lastCheck = time.Now()
+
+		modTime, err := c.latestModTime()
+		if err != nil {
+			slog.Error("checking certificate", "cert_file", c.CertFile, "error", err)
+		} else if modTime.After(c.modTime) {
+			err = c.load(modTime)
+			if err != nil {
+				slog.Error("reloading certificate", "cert_file", c.CertFile, "error", err)
+			} else {
+				slog.Info("reloaded certificate", "cert_file", c.CertFile)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// latestModTime returns when the certificate or key last changed; renewals replace both
+// but not necessarily at the same moment.
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.CertFile, c.KeyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// SelfSigned generates a certificate for hosts, which may be names or IP addresses. It is
+// only meant for development; browsers will warn about it.
+func SelfSigned(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"webapp development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webapp/pkg/config"
+)
+
+func writeCert(t *testing.T, dir string, modTime time.Time) *x509.Certificate {
+	cert, err := SelfSigned("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"cert.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		"key.pem":  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+	}
+	for name, b := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cert.Leaf
+}
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("expected certificate to be valid for %s: %v", host, err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	first := writeCert(t, dir, time.Now().Add(-time.Hour))
+
+	c, err := NewCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CheckInterval = 0
+
+	serial := func() string {
+		cert, _ := c.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.String()
+	}
+
+	if serial() != first.SerialNumber.String() {
+		t.Error("expected the first certificate")
+	}
+
+	second := writeCert(t, dir, time.Now())
+	if serial() != second.SerialNumber.String() {
+		t.Error("expected the renewed certificate to be picked up")
+	}
+
+	// a broken renewal keeps the last good certificate
+	err = os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(filepath.Join(dir, "cert.pem"), future, future)
+
+	if serial() != second.SerialNumber.String() {
+		t.Error("expected the last good certificate to be kept")
+	}
+}
+
+func TestRunner_TLS(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, time.Now())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+	r := New(ln.Addr().String(), handler, config.Default().Server)
+	err = r.ConfigureTLS(config.TLS{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Serve(ctx, ln)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 but got %s", resp.Proto)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		expected string
+	}{
+		{"default port", "example.com:80", 443, "https://example.com/path?q=1"},
+		{"other port", "localhost:8079", 8080, "https://localhost:8080/path?q=1"},
+		{"no port in host", "example.com", 443, "https://example.com/path?q=1"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/path?q=1", nil)
+		req.Host = e.host
+		rr := httptest.NewRecorder()
+
+		RedirectHandler(e.port).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusPermanentRedirect, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expected {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expected, loc)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package signedtoken
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Now()
+	s := New("secret")
+	s.Now = func() time.Time { return now }
+
+	token := s.Generate("reset", 7, "hash-one", time.Hour)
+
+	userID, err := UserID(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 7 {
+		t.Errorf("expected user id 7 but got %d", userID)
+	}
+
+	tests := []struct {
+		name     string
+		signer   *Signer
+		purpose  string
+		token    string
+		binding  string
+		after    time.Duration
+		expected error
+	}{
+		{"valid", s, "reset", token, "hash-one", 0, nil},
+		{"changed binding", s, "reset", token, "hash-two", 0, ErrInvalidToken},
+		{"wrong purpose", s, "verify", token, "hash-one", 0, ErrInvalidToken},
+		{"expired", s, "reset", token, "hash-one", 2 * time.Hour, ErrExpiredToken},
+		{"tampered", s, "reset", "eDE." + token, "hash-one", 0, ErrInvalidToken},
+		{"wrong secret", New("other"), "reset", token, "hash-one", 0, ErrInvalidToken},
+		{"garbage", s, "reset", "not a token", "hash-one", 0, ErrInvalidToken},
+	}
+
+	for _, e := range tests {
+		now = time.Now().Add(e.after)
+		err := e.signer.Verify(e.purpose, e.token, e.binding)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+	"webapp/pkg/data"
+)
+
+// sweepInterval is how often MemoryStore forgets attempts that no longer count.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps login attempts in process. Counts are lost on restart and are not
+// shared between instances; use the database store when running more than one.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]memoryAttempts
+	lastSweep time.Time
+}
+
+// memoryAttempts are attempts with when they stop counting, once the failures would start
+// again and any lockout has ended. After that they are no different from none.
+type memoryAttempts struct {
+	data.LoginAttempts
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]memoryAttempts)}
+}
+
+// GetLoginAttempts returns the attempts for key, or an empty record if there are none.
+func (m *MemoryStore) GetLoginAttempts(_ context.Context, key string) (*data.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a.Key = key
+	}
+	return &a.LoginAttempts, nil
+}
+
+// AddLoginFailure adds f to the attempts for its key and returns them.
+func (m *MemoryStore) AddLoginFailure(_ context.Context, f data.LoginFailure) (*data.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(f.At)
+
+	a := m.attempts[f.Key].Add(f)
+	expires := f.At.Add(f.At.Sub(f.ResetBefore))
+	if a.LockedUntil.After(expires) {
+		expires = a.LockedUntil
+	}
+	m.attempts[f.Key] = memoryAttempts{LoginAttempts: a, expires: expires}
+	return &a, nil
+}
+
+// DeleteLoginAttempts removes the record for key.
+func (m *MemoryStore) DeleteLoginAttempts(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// sweep drops attempts that no longer count once in a while, so that memory does not grow
+// with every address and account name ever tried. The caller must hold mu.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, a := range m.attempts {
+		if !now.Before(a.expires) {
+			delete(m.attempts, key)
+		}
+	}
+}
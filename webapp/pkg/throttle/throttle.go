@@ -0,0 +1,174 @@
+package throttle
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// Store persists failed login attempts. Both MemoryStore and the database repositories implement it.
+type Store interface {
+	GetLoginAttempts(ctx context.Context, key string) (*data.LoginAttempts, error)
+	// AddLoginFailure adds f to the attempts for its key, as LoginAttempts.Add does, in a
+	// single operation, and returns the attempts afterwards.
+	AddLoginFailure(ctx context.Context, f data.LoginFailure) (*data.LoginAttempts, error)
+	DeleteLoginAttempts(ctx context.Context, key string) error
+}
+
+// Policy describes how quickly failed attempts slow down, and eventually lock out, a key.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before any backoff applies.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past FreeAttempts; it doubles with each further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay.
+	MaxDelay time.Duration
+	// MaxFailures is the number of failures that triggers a lockout.
+	MaxFailures int
+	// LockoutDuration is how long a lockout lasts.
+	LockoutDuration time.Duration
+	// ResetAfter forgets failures once there have been none for this long.
+	ResetAfter time.Duration
+}
+
+// DefaultAccountPolicy applies to failed logins against a single account.
+var DefaultAccountPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	MaxFailures:     10,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// DefaultIPPolicy applies to failed logins from a single client IP, which may be shared by many users.
+var DefaultIPPolicy = Policy{
+	FreeAttempts:    10,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	MaxFailures:     50,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// Guard applies per-account and per-IP login throttling.
+type Guard struct {
+	Store   Store
+	Account Policy
+	IP      Policy
+	Now     func() time.Time
+}
+
+// New returns a Guard using the default policies.
+func New(store Store) *Guard {
+	return &Guard{
+		Store:   store,
+		Account: DefaultAccountPolicy,
+		IP:      DefaultIPPolicy,
+		Now:     time.Now,
+	}
+}
+
+// AccountKey returns the store key used for an account.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the store key used for a client IP.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Allow reports how long the caller must wait before another login attempt for this account
+// and IP will be considered. A zero duration means the attempt may go ahead.
+func (g *Guard) Allow(ctx context.Context, email, ip string) (time.Duration, error) {
+	var wait time.Duration
+
+	for _, k := range g.keys(email, ip) {
+		a, err := g.Store.GetLoginAttempts(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if w := k.policy.wait(a, g.Now()); w > wait {
+			wait = w
+		}
+	}
+
+	return wait, nil
+}
+
+// Failure records a failed login for this account and IP. Attempts are checked by Allow
+// before the password is, so a burst of guesses can all get past it; each is still counted.
+func (g *Guard) Failure(ctx context.Context, email, ip string) error {
+	now := g.Now()
+
+	for _, k := range g.keys(email, ip) {
+		_, err := g.Store.AddLoginFailure(ctx, data.LoginFailure{
+			Key:         k.key,
+			At:          now,
+			ResetBefore: now.Add(-k.policy.ResetAfter),
+			MaxFailures: k.policy.MaxFailures,
+			LockUntil:   now.Add(k.policy.LockoutDuration),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Success clears failures for the account after a good login. The IP count is left alone,
+// so that one valid account cannot be used to reset the count for a guessing client.
+func (g *Guard) Success(ctx context.Context, email string) error {
+	return g.Store.DeleteLoginAttempts(ctx, AccountKey(email))
+}
+
+// Unlock clears any backoff or lockout on an account.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.Store.DeleteLoginAttempts(ctx, AccountKey(email))
+}
+
+type policyKey struct {
+	key    string
+	policy Policy
+}
+
+func (g *Guard) keys(email, ip string) []policyKey {
+	keys := []policyKey{{AccountKey(email), g.Account}}
+	if ip != "" && ip != "unknown" {
+		keys = append(keys, policyKey{IPKey(ip), g.IP})
+	}
+	return keys
+}
+
+// wait returns the remaining lockout or backoff for a, or zero if an attempt is allowed.
+func (p Policy) wait(a *data.LoginAttempts, now time.Time) time.Duration {
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	if a.Failures <= p.FreeAttempts || now.Sub(a.LastFailure) > p.ResetAfter {
+		return 0
+	}
+
+	delay := p.MaxDelay
+	if shift := a.Failures - p.FreeAttempts - 1; shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	if next := a.LastFailure.Add(delay); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// RetryAfter formats d as whole seconds, rounded up, for a Retry-After header.
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
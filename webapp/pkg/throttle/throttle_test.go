@@ -0,0 +1,207 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestGuard(now *time.Time) *Guard {
+	g := New(NewMemoryStore())
+	g.Account = Policy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		MaxFailures:     6,
+		LockoutDuration: time.Minute,
+		ResetAfter:      time.Hour,
+	}
+	g.Now = func() time.Time { return *now }
+	return g
+}
+
+func TestGuard_backoff(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+
+	expected := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, time.Minute}
+
+	for i, e := range expected {
+		_ = g.Failure(context.Background(), "admin@example.com", "")
+
+		wait, err := g.Allow(context.Background(), "admin@example.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != e {
+			t.Errorf("after %d failures: expected wait of %s but got %s", i+1, e, wait)
+		}
+	}
+
+	// the sixth failure hit MaxFailures and locked the account
+	now = now.Add(30 * time.Second)
+	wait, _ := g.Allow(context.Background(), "ADMIN@example.com ", "")
+	if wait != 30*time.Second {
+		t.Errorf("expected 30s of lockout left but got %s", wait)
+	}
+
+	now = now.Add(31 * time.Second)
+	wait, _ = g.Allow(context.Background(), "admin@example.com", "")
+	if wait != 0 {
+		t.Errorf("expected lockout to have expired but got wait of %s", wait)
+	}
+
+	// a failure after the lockout expires starts counting again
+	_ = g.Failure(context.Background(), "admin@example.com", "")
+	wait, _ = g.Allow(context.Background(), "admin@example.com", "")
+	if wait != 0 {
+		t.Errorf("expected fresh count after lockout but got wait of %s", wait)
+	}
+}
+
+func TestGuard_ipIsTrackedSeparately(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+	g.IP = Policy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: time.Second, MaxFailures: 10, LockoutDuration: time.Minute, ResetAfter: time.Hour}
+
+	_ = g.Failure(context.Background(), "one@example.com", "10.0.0.1")
+
+	wait, _ := g.Allow(context.Background(), "two@example.com", "10.0.0.1")
+	if wait != time.Second {
+		t.Errorf("expected IP backoff to apply to other accounts, got %s", wait)
+	}
+
+	wait, _ = g.Allow(context.Background(), "two@example.com", "10.0.0.2")
+	if wait != 0 {
+		t.Errorf("expected no wait from a different IP, got %s", wait)
+	}
+}
+
+func TestGuard_successAndUnlock(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+
+	for i := 0; i < 6; i++ {
+		_ = g.Failure(context.Background(), "admin@example.com", "10.0.0.1")
+	}
+
+	if wait, _ := g.Allow(context.Background(), "admin@example.com", ""); wait == 0 {
+		t.Fatal("expected account to be locked")
+	}
+
+	_ = g.Unlock(context.Background(), "admin@example.com")
+
+	if wait, _ := g.Allow(context.Background(), "admin@example.com", ""); wait != 0 {
+		t.Errorf("expected unlock to clear lockout, got wait of %s", wait)
+	}
+
+	_ = g.Failure(context.Background(), "admin@example.com", "10.0.0.1")
+	_ = g.Success(context.Background(), "admin@example.com")
+
+	a, _ := g.Store.GetLoginAttempts(context.Background(), AccountKey("admin@example.com"))
+	if a.Failures != 0 {
+		t.Errorf("expected success to clear account failures, got %d", a.Failures)
+	}
+
+	a, _ = g.Store.GetLoginAttempts(context.Background(), IPKey("10.0.0.1"))
+	if a.Failures != 7 {
+		t.Errorf("expected success to leave IP failures alone, got %d", a.Failures)
+	}
+}
+
+func TestGuard_resetAfter(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+
+	for i := 0; i < 4; i++ {
+		_ = g.Failure(context.Background(), "admin@example.com", "")
+	}
+
+	now = now.Add(2 * time.Hour)
+
+	if wait, _ := g.Allow(context.Background(), "admin@example.com", ""); wait != 0 {
+		t.Errorf("expected old failures to be forgotten, got wait of %s", wait)
+	}
+
+	_ = g.Failure(context.Background(), "admin@example.com", "")
+	a, _ := g.Store.GetLoginAttempts(context.Background(), AccountKey("admin@example.com"))
+	if a.Failures != 1 {
+		t.Errorf("expected failure count to restart, got %d", a.Failures)
+	}
+}
+
+func TestMemoryStore_sweep(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+	store := g.Store.(*MemoryStore)
+
+	// someone trying many accounts from many addresses
+	for i := 0; i < 100; i++ {
+		_ = g.Failure(context.Background(), fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("192.0.2.%d", i))
+	}
+
+	now = now.Add(2 * time.Hour)
+	_ = g.Failure(context.Background(), "admin@example.com", "")
+
+	if n := len(store.attempts); n != 1 {
+		t.Errorf("expected the old attempts to be forgotten, but %d are kept", n)
+	}
+
+	// a lockout is kept for as long as it lasts, even past ResetAfter
+	g.Account.LockoutDuration = 3 * time.Hour
+	for i := 0; i < g.Account.MaxFailures; i++ {
+		_ = g.Failure(context.Background(), "locked@example.com", "")
+	}
+
+	now = now.Add(2 * time.Hour)
+	_ = g.Failure(context.Background(), "admin@example.com", "")
+
+	if wait, _ := g.Allow(context.Background(), "locked@example.com", ""); wait != time.Hour {
+		t.Errorf("expected the lockout to be kept, but got wait of %s", wait)
+	}
+}
+
+func TestGuard_concurrentFailures(t *testing.T) {
+	now := time.Now()
+	g := newTestGuard(&now)
+
+	// guesses made at once all get past Allow before any fails; each must still count
+	var wg sync.WaitGroup
+	for i := 0; i < g.Account.MaxFailures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = g.Failure(context.Background(), "admin@example.com", "192.0.2.1")
+		}()
+	}
+	wg.Wait()
+
+	a, _ := g.Store.GetLoginAttempts(context.Background(), AccountKey("admin@example.com"))
+	if a.Failures != g.Account.MaxFailures {
+		t.Errorf("expected %d failures but got %d", g.Account.MaxFailures, a.Failures)
+	}
+
+	wait, _ := g.Allow(context.Background(), "admin@example.com", "")
+	if wait != g.Account.LockoutDuration {
+		t.Errorf("expected the account to be locked out, but got wait of %s", wait)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait     time.Duration
+		expected string
+	}{
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+
+	for _, e := range tests {
+		if got := RetryAfter(e.wait); got != e.expected {
+			t.Errorf("RetryAfter(%s): expected %s but got %s", e.wait, e.expected, got)
+		}
+	}
+}
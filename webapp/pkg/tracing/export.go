@@ -0,0 +1,338 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"webapp/pkg/config"
+)
+
+// Exporter sends finished spans somewhere. Export must not block the request that ended
+// the span for long.
+type Exporter interface {
+	Export(s SpanData)
+	// Shutdown sends anything still buffered and releases the exporter.
+	Shutdown(ctx context.Context) error
+}
+
+// NewExporter returns the exporter cfg asks for, or nil for none.
+func NewExporter(cfg config.Tracing) (Exporter, error) {
+	switch cfg.Exporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return NewWriterExporter(os.Stdout), nil
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		e := NewWriterExporter(f)
+		e.closer = f
+		return e, nil
+	case "otlp":
+		return NewOTLPExporter(cfg.Endpoint), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+}
+
+// Shutdown flushes the tracer's exporter, for when the server stops.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.Exporter == nil {
+		return nil
+	}
+	return t.Exporter.Shutdown(ctx)
+}
+
+// WriterExporter writes each span as a line of OTLP JSON, to read or to feed to a collector later.
+type WriterExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriterExporter returns an exporter writing to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// Export writes s.
+func (e *WriterExporter) Export(s SpanData) {
+	b, err := json.Marshal(resourceSpans([]SpanData{s}))
+	if err != nil {
+		slog.Warn("encoding span", "error", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(b, '\n')); err != nil {
+		slog.Warn("writing span", "error", err)
+	}
+}
+
+// Shutdown closes the file being written to, if the exporter opened one.
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// OTLPExporter sends spans in batches to a collector's OTLP/HTTP endpoint, using the JSON
+// encoding. Spans are dropped, rather than held without limit, while the collector is down.
+type OTLPExporter struct {
+	Endpoint string
+	Client   *http.Client
+	// BatchSize spans are sent together; fewer are sent after Interval.
+	BatchSize int
+	Interval  time.Duration
+	// MaxQueue bounds the spans waiting to be sent.
+	MaxQueue int
+
+	mu      sync.Mutex
+	pending []SpanData
+	dropped int
+	kick    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewOTLPExporter returns an exporter sending to endpoint, e.g.
+// http://localhost:4318/v1/traces, and starts sending in the background.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	e := &OTLPExporter{
+		Endpoint:  endpoint,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		BatchSize: 512,
+		Interval:  5 * time.Second,
+		MaxQueue:  2048,
+		kick:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues s to be sent.
+func (e *OTLPExporter) Export(s SpanData) {
+	e.mu.Lock()
+	if len(e.pending) >= e.MaxQueue {
+		e.dropped++
+		e.mu.Unlock()
+		return
+	}
+	e.pending = append(e.pending, s)
+	full := len(e.pending) >= e.BatchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Shutdown sends what is queued and stops the background sender.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.kick:
+		case <-e.stop:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+// flush sends everything queued, a batch at a time.
+func (e *OTLPExporter) flush() {
+	for {
+		e.mu.Lock()
+		n := len(e.pending)
+		if n > e.BatchSize {
+			n = e.BatchSize
+		}
+		batch := e.pending[:n:n]
+		e.pending = e.pending[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			slog.Warn("dropped spans while the collector was behind", "spans", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := e.send(batch); err != nil {
+			slog.Warn("exporting spans", "endpoint", e.Endpoint, "spans", len(batch), "error", err)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []SpanData) error {
+	b, err := json.Marshal(resourceSpans(batch))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.Client.Post(e.Endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the OTLP JSON encoding of ExportTraceServiceRequest, as far as it is used here.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              Kind       `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	// Code is 0 for unset and 2 for error; spans are never explicitly marked ok.
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// scopeName is the instrumentation scope spans are reported under.
+const scopeName = "webapp/pkg/tracing"
+
+// resourceSpans groups spans by service, in the order the services first appear.
+func resourceSpans(spans []SpanData) otlpRequest {
+	var req otlpRequest
+	index := make(map[string]int)
+
+	for _, s := range spans {
+		i, ok := index[s.Service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[s.Service] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: otlpAttrs([]Attr{{"service.name", s.Service}})},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}}},
+			})
+		}
+
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, toOTLP(s))
+	}
+
+	return req
+}
+
+func toOTLP(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID.String(),
+		SpanID:            s.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        otlpAttrs(s.Attrs),
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.String()
+	}
+	if s.Failed {
+		span.Status = otlpStatus{Code: 2, Message: s.ErrorText}
+	}
+	return span
+}
+
+func otlpAttrs(attrs []Attr) []otlpAttr {
+	out := make([]otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]interface{}
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": x}
+		case bool:
+			v = map[string]interface{}{"boolValue": x}
+		case int:
+			// OTLP JSON carries 64 bit integers as strings
+			v = map[string]interface{}{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": x}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, otlpAttr{Key: a.Key, Value: v})
+	}
+	return out
+}
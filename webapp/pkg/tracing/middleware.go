@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware starts a server span for each request, continuing the caller's trace when the
+// request has a valid traceparent header. Like the metrics, it must wrap the chi router for
+// the span to be named after the route.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if traceID, spanID, sampled, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			ctx = context.WithValue(ctx, remoteKey{}, remoteParent{traceID, spanID, sampled})
+		}
+
+		ctx, span := t.Start(ctx, r.Method, KindServer,
+			Attr{"http.request.method", r.Method},
+			Attr{"url.path", r.URL.Path},
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(Attr{"http.response.status_code", status})
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(Attr{"http.route", rctx.RoutePattern()})
+		}
+	})
+}
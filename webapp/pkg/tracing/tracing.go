@@ -0,0 +1,287 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID identify traces and spans as in W3C Trace Context and OpenTelemetry.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether t is not all zeros, which the spec reserves for invalid.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// Kind says what part a span plays, using the OpenTelemetry kinds.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr is a span attribute. Values are strings, ints, float64s or bools.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is one timed operation in a trace. Its methods do nothing on a nil span, so code
+// can record to whatever span is in its context without checking there is one.
+type Span struct {
+	tracer  *Tracer
+	TraceID TraceID
+	SpanID  SpanID
+	Parent  SpanID
+	// Sampled spans are exported; the flag comes from the caller's traceparent if there is one.
+	Sampled bool
+	Kind    Kind
+
+	mu        sync.Mutex
+	name      string
+	start     time.Time
+	end       time.Time
+	attrs     []Attr
+	errorText string
+	failed    bool
+	ended     bool
+}
+
+// Tracer starts spans for one service and hands them to its exporter when they end.
+type Tracer struct {
+	Service  string
+	Exporter Exporter
+	now      func() time.Time
+}
+
+// New returns a Tracer for service. A nil exporter still creates spans, so their IDs can be
+// logged and passed on, but sends them nowhere.
+func New(service string, exporter Exporter) *Tracer {
+	return &Tracer{Service: service, Exporter: exporter, now: time.Now}
+}
+
+// Start begins a span that is a child of the span in ctx, or the root of a new trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	s := &Span{
+		tracer:  t,
+		SpanID:  newSpanID(),
+		Sampled: true,
+		Kind:    kind,
+		name:    name,
+		start:   t.now(),
+		attrs:   attrs,
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID, s.Parent, s.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		s.TraceID, s.Parent, s.Sampled = remote.traceID, remote.spanID, remote.sampled
+	} else {
+		s.TraceID = newTraceID()
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start begins a child of the span in ctx with that span's tracer. Outside a trace there is
+// nothing to attach to, so it returns ctx and a nil span.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind, attrs...)
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetName replaces the span's name, for when it is only known once the work is done.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attributes, replacing any with the same key.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+next:
+	for _, a := range attrs {
+		for i := range s.attrs {
+			if s.attrs[i].Key == a.Key {
+				s.attrs[i] = a
+				continue next
+			}
+		}
+		s.attrs = append(s.attrs, a)
+	}
+}
+
+// SetError marks the span as failed with err. A nil err does nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.errorText = err.Error()
+}
+
+// End records the end time and exports the span if it is sampled. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = s.tracer.now()
+	s.mu.Unlock()
+
+	if s.Sampled && s.tracer.Exporter != nil {
+		s.tracer.Exporter.Export(s.snapshot())
+	}
+}
+
+// snapshot copies what exporters need, so they never share the span's lock.
+func (s *Span) snapshot() SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SpanData{
+		Service:   s.tracer.Service,
+		TraceID:   s.TraceID,
+		SpanID:    s.SpanID,
+		Parent:    s.Parent,
+		Name:      s.name,
+		Kind:      s.Kind,
+		Start:     s.start,
+		End:       s.end,
+		Attrs:     append([]Attr(nil), s.attrs...),
+		Failed:    s.failed,
+		ErrorText: s.errorText,
+	}
+}
+
+// SpanData is a finished span, as handed to exporters.
+type SpanData struct {
+	Service   string
+	TraceID   TraceID
+	SpanID    SpanID
+	Parent    SpanID
+	Name      string
+	Kind      Kind
+	Start     time.Time
+	End       time.Time
+	Attrs     []Attr
+	Failed    bool
+	ErrorText string
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	for !t.IsValid() {
+		_, _ = rand.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	for !s.IsValid() {
+		_, _ = rand.Read(s[:])
+	}
+	return s
+}
+
+// remoteParent is a span in another process, from an incoming traceparent header.
+type remoteParent struct {
+	traceID TraceID
+	spanID  SpanID
+	sampled bool
+}
+
+type remoteKey struct{}
+
+// TraceparentHeader is the W3C Trace Context header.
+const TraceparentHeader = "traceparent"
+
+// ParseTraceparent reads a version 00 traceparent header, or a later version, which is
+// required to start with the same fields.
+func ParseTraceparent(h string) (traceID TraceID, spanID SpanID, sampled bool, err error) {
+	// version-traceid-spanid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return traceID, spanID, false, fmt.Errorf("malformed traceparent %q", h)
+	}
+
+	version, err := hex.DecodeString(h[0:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(h) != 55) {
+		return traceID, spanID, false, fmt.Errorf("unsupported traceparent %q", h)
+	}
+	if len(h) > 55 && h[55] != '-' {
+		return traceID, spanID, false, fmt.Errorf("malformed traceparent %q", h)
+	}
+
+	flags, err1 := hex.DecodeString(h[53:55])
+	_, err2 := hex.Decode(traceID[:], []byte(h[3:35]))
+	_, err3 := hex.Decode(spanID[:], []byte(h[36:52]))
+	if err1 != nil || err2 != nil || err3 != nil || !traceID.IsValid() || !spanID.IsValid() || h != lowerHex(h) {
+		return TraceID{}, SpanID{}, false, fmt.Errorf("malformed traceparent %q", h)
+	}
+
+	return traceID, spanID, flags[0]&1 == 1, nil
+}
+
+// lowerHex returns h with A-F lowered, since the spec only allows lowercase ids.
+func lowerHex(h string) string {
+	b := []byte(h)
+	for i, c := range b {
+		if c >= 'A' && c <= 'F' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// Traceparent formats the span as a traceparent header, to pass the trace on.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-" + flags
+}
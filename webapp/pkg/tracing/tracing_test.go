@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// memoryExporter keeps exported spans for tests.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (m *memoryExporter) Export(s SpanData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, s)
+}
+
+func (m *memoryExporter) Shutdown(ctx context.Context) error { return nil }
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"empty", "", false, false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"version 00 too long", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, false},
+	}
+
+	for _, e := range tests {
+		traceID, spanID, sampled, err := ParseTraceparent(e.header)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %v but got error %v", e.name, e.valid, err)
+			continue
+		}
+		if !e.valid {
+			continue
+		}
+		if traceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID.String() != "00f067aa0ba902b7" {
+			t.Errorf("%s: parsed %s %s", e.name, traceID, spanID)
+		}
+		if sampled != e.sampled {
+			t.Errorf("%s: expected sampled %v", e.name, e.sampled)
+		}
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exp := &memoryExporter{}
+	tracer := New("test", exp)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	_, child := Start(ctx, "child", KindClient, Attr{"db.operation", "select users"})
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("expected each span exported once, got %d", len(exp.spans))
+	}
+
+	c, r := exp.spans[0], exp.spans[1]
+	if c.TraceID != r.TraceID || c.Parent != r.SpanID || r.Parent.IsValid() {
+		t.Error("expected the child to belong to the root's trace")
+	}
+	if !c.Failed || c.ErrorText != "boom" || c.Service != "test" {
+		t.Errorf("unexpected child %+v", c)
+	}
+
+	// outside a trace there is nothing to attach to, and a nil span is safe to use
+	_, none := Start(context.Background(), "orphan", KindClient)
+	if none != nil {
+		t.Error("expected no span outside a trace")
+	}
+	none.SetAttributes(Attr{"k", "v"})
+	none.SetError(errors.New("ignored"))
+	none.End()
+}
+
+func TestTracer_Middleware(t *testing.T) {
+	exp := &memoryExporter{}
+	tracer := New("test", exp)
+
+	var traceparent string
+	mux := chi.NewRouter()
+	mux.Use(tracer.Middleware)
+	mux.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		traceparent = SpanFromContext(r.Context()).Traceparent()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	// an unsampled caller is followed, so nothing is exported
+	req = httptest.NewRequest("GET", "/users/2", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(exp.spans) != 1 {
+		t.Fatalf("expected 1 exported span but got %d", len(exp.spans))
+	}
+
+	s := exp.spans[0]
+	if s.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || s.Parent.String() != "00f067aa0ba902b7" {
+		t.Error("expected the caller's trace to be continued")
+	}
+	if s.Name != "GET /users/{id}" || s.Kind != KindServer || !s.Failed {
+		t.Errorf("unexpected span %+v", s)
+	}
+
+	attrs := make(map[string]interface{})
+	for _, a := range s.Attrs {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["http.route"] != "/users/{id}" || attrs["http.response.status_code"] != http.StatusBadGateway {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	if traceparent[:36] != "00-4bf92f3577b34da6a3ce929d0e0e4736-" || traceparent[52:] != "-00" {
+		t.Errorf("unexpected traceparent %s", traceparent)
+	}
+}
+
+func testSpan() SpanData {
+	start := time.Unix(1700000000, 0)
+	return SpanData{
+		Service: "test",
+		TraceID: TraceID{1},
+		SpanID:  SpanID{2},
+		Name:    "GET /",
+		Kind:    KindServer,
+		Start:   start,
+		End:     start.Add(time.Millisecond),
+		Attrs:   []Attr{{"http.response.status_code", 200}, {"url.path", "/"}},
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	NewWriterExporter(&buf).Export(testSpan())
+
+	var req otlpRequest
+	if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "01000000000000000000000000000000" || span.StartTimeUnixNano != "1700000000000000000" {
+		t.Errorf("unexpected span %+v", span)
+	}
+	if v := span.Attributes[0].Value["intValue"]; v != "200" {
+		t.Errorf("expected an OTLP int value but got %v", span.Attributes[0].Value)
+	}
+	if v := req.ResourceSpans[0].Resource.Attributes[0].Value["stringValue"]; v != "test" {
+		t.Errorf("expected the service name as a resource attribute, got %v", v)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var received []otlpSpan
+
+	// a stand-in for a collector's OTLP/HTTP endpoint
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			received = append(received, rs.ScopeSpans[0].Spans...)
+		}
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL + "/v1/traces")
+	exp.BatchSize = 2
+	for i := 0; i < 3; i++ {
+		exp.Export(testSpan())
+	}
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 3 {
+		t.Errorf("expected 3 spans at the collector but got %d", len(received))
+	}
+}
@@ -0,0 +1,198 @@
+// Package validate checks values against declarative rules, such as
+// "required,email,max=255", given in `validate` struct tags or directly. The api's request
+// types and the web's forms both use it, so they agree on what is valid and say so alike.
+//
+// The rules are:
+//
+//	required      not blank: text with more than spaces, a number other than 0, a non-empty list
+//	omitempty     skip the rules after it when the value is blank
+//	email         a single email address, with no display name
+//	min=N, max=N  at least or at most N characters of text, items of a list, or as a number
+//	oneof=a b c   one of the values listed, separated by spaces
+//
+// A nil pointer stands for a value that was not given at all: only required applies to it.
+// Rules that do not parse are mistakes in the program, not the input, and panic.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Errors maps field names to what is wrong with them, as both the api and the web report it.
+type Errors map[string][]string
+
+// Add records message against field.
+func (e Errors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+// Struct checks the fields of v, a struct or pointer to one, against their validate tags.
+// Fields are named by their json tag, and embedded structs are checked as if their fields
+// were v's own. It returns nil if everything is valid.
+func Struct(v interface{}) Errors {
+	errs := make(Errors)
+	checkStruct(reflect.Indirect(reflect.ValueOf(v)), errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func checkStruct(v reflect.Value, errs Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			checkStruct(v.Field(i), errs)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		rules, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		for _, message := range check(v.Field(i), rules) {
+			errs.Add(name, message)
+		}
+	}
+}
+
+// Value checks one value against rules, returning what is wrong with it.
+func Value(v interface{}, rules string) []string {
+	return check(reflect.ValueOf(v), rules)
+}
+
+func check(v reflect.Value, rules string) []string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if hasRule(rules, "required") {
+				return []string{"This field cannot be blank"}
+			}
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	var problems []string
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+		switch name {
+		case "":
+		case "omitempty":
+			if isBlank(v) {
+				return problems
+			}
+		case "required":
+			// the other rules would only repeat that the value is missing
+			if isBlank(v) {
+				return append(problems, "This field cannot be blank")
+			}
+		case "email":
+			if !isEmail(v) {
+				problems = append(problems, "Enter a valid email address")
+			}
+		case "min":
+			n := number(rule, arg)
+			if size(v) < n {
+				problems = append(problems, sizeMessage(v, "at least", n))
+			}
+		case "max":
+			n := number(rule, arg)
+			if size(v) > n {
+				problems = append(problems, sizeMessage(v, "at most", n))
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if !contains(options, fmt.Sprint(v.Interface())) {
+				problems = append(problems, "This field must be one of "+strings.Join(options, ", "))
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q", rule))
+		}
+	}
+
+	return problems
+}
+
+func hasRule(rules, name string) bool {
+	for _, rule := range strings.Split(rules, ",") {
+		if strings.TrimSpace(rule) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlank(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func isEmail(v reflect.Value) bool {
+	if v.Kind() != reflect.String {
+		return false
+	}
+	addr, err := mail.ParseAddress(v.String())
+	return err == nil && addr.Address == v.String()
+}
+
+// size is what min and max compare: the length of text or lists, or a number's value.
+func size(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.String:
+		return len([]rune(v.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	}
+	panic(fmt.Sprintf("validate: min and max do not apply to %s", v.Kind()))
+}
+
+func sizeMessage(v reflect.Value, bound string, n int) string {
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("This field must be %s %d characters long", bound, n)
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return fmt.Sprintf("This field must have %s %d items", bound, n)
+	}
+	return fmt.Sprintf("This field must be %s %d", bound, n)
+}
+
+func number(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validate: rule %q needs a number", rule))
+	}
+	return n
+}
+
+func contains(options []string, s string) bool {
+	for _, o := range options {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testEmbedded struct {
+	Role string `json:"role" validate:"oneof=admin user"`
+}
+
+type testRequest struct {
+	testEmbedded
+	ID       int      `json:"id" validate:"required,min=1"`
+	Name     string   `json:"name,omitempty" validate:"required,max=5"`
+	Email    string   `json:"email" validate:"omitempty,email"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Nickname *string  `json:"nickname" validate:"min=3"`
+	Untagged string
+	NoJSON   string `validate:"required"`
+}
+
+func TestStruct(t *testing.T) {
+	short := "al"
+	errs := Struct(&testRequest{
+		testEmbedded: testEmbedded{Role: "root"},
+		ID:           -1,
+		Name:         "   ",
+		Email:        "Al <al@example.com>",
+		Tags:         []string{"a", "b", "c"},
+		Nickname:     &short,
+	})
+
+	expected := Errors{
+		"role":     {"This field must be one of admin, user"},
+		"id":       {"This field must be at least 1"},
+		"name":     {"This field cannot be blank"},
+		"email":    {"Enter a valid email address"},
+		"tags":     {"This field must have at most 2 items"},
+		"nickname": {"This field must be at least 3 characters long"},
+		"NoJSON":   {"This field cannot be blank"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v but got %v", expected, errs)
+	}
+
+	errs = Struct(testRequest{
+		testEmbedded: testEmbedded{Role: "user"},
+		ID:           1,
+		Name:         "Al",
+		NoJSON:       "x",
+	})
+	if errs != nil {
+		t.Errorf("expected no errors, with absent optional fields, but got %v", errs)
+	}
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		rules    string
+		problems int
+	}{
+		{"required text", "x", "required", 0},
+		{"blank text", " ", "required", 1},
+		{"required stops the rest", "", "required,email,min=3", 1},
+		{"email", "admin@example.com", "email", 0},
+		{"not email", "admin", "email", 1},
+		{"empty email", "", "email", 1},
+		{"omitted email", "", "omitempty,email", 0},
+		{"min runes", "héé", "min=3", 0},
+		{"too long", "abcdef", "max=5", 1},
+		{"number in range", 3, "min=1,max=3", 0},
+		{"number out of range", 4, "min=1,max=3", 1},
+		{"one of numbers", 1, "oneof=0 1", 0},
+		{"not one of", "x", "oneof=a b", 1},
+	}
+
+	for _, e := range tests {
+		if problems := Value(e.value, e.rules); len(problems) != e.problems {
+			t.Errorf("%s: expected %d problems but got %v", e.name, e.problems, problems)
+		}
+	}
+}
+
+func TestValue_badRules(t *testing.T) {
+	for _, rules := range []string{"bogus", "min=x", "min=1"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %q to panic", rules)
+				}
+			}()
+			Value(true, rules)
+		}()
+	}
+}
@@ -0,0 +1,126 @@
+--
+-- Brings a database created from an earlier users.sql up to date with it. New databases
+-- are created from users.sql and do not need this. Every statement can be run again.
+--
+
+--
+-- Name: users.totp_secret, users.totp_enabled, users.totp_last_step; Type: COLUMN; Schema: public; Owner: -
+--
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS totp_secret character varying(64);
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS totp_enabled boolean DEFAULT false NOT NULL;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS totp_last_step bigint;
+
+--
+-- Name: user_recovery_codes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE IF NOT EXISTS public.user_recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone,
+    CONSTRAINT user_recovery_codes_pkey PRIMARY KEY (id),
+    CONSTRAINT user_recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+--
+-- Name: login_attempts; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE IF NOT EXISTS public.login_attempts (
+    key character varying(255) NOT NULL,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure timestamp without time zone NOT NULL,
+    locked_until timestamp without time zone NOT NULL,
+    CONSTRAINT login_attempts_pkey PRIMARY KEY (key)
+);
+
+--
+-- Name: users.password_changed_at; Type: COLUMN; Schema: public; Owner: -
+--
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS password_changed_at timestamp without time zone;
+
+--
+-- Name: users.email_verified_at; Type: COLUMN; Schema: public; Owner: -
+--
+-- Accounts from before email verification count as verified, so that none are locked out.
+-- The backfill only runs when the column is added, so that running this again does not
+-- verify accounts that have signed up since.
+--
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'email_verified_at') THEN
+        ALTER TABLE public.users ADD COLUMN email_verified_at timestamp without time zone;
+        UPDATE public.users SET email_verified_at = coalesce(email_verified_at, created_at, now());
+    END IF;
+END $$;
+
+--
+-- Name: api_keys; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    name character varying(255) NOT NULL,
+    prefix character varying(32) NOT NULL,
+    key_hash character varying(64) NOT NULL,
+    scopes character varying(255) NOT NULL,
+    last_used_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_prefix_key UNIQUE (prefix),
+    CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+--
+-- Name: sessions; Type: TABLE; Schema: public; Owner: -
+--
+-- A database that already has this table from scs's postgresstore keeps it; the layout is
+-- the same.
+--
+
+CREATE TABLE IF NOT EXISTS public.sessions (
+    token text NOT NULL,
+    data bytea NOT NULL,
+    expiry timestamp with time zone NOT NULL,
+    CONSTRAINT sessions_pkey PRIMARY KEY (token)
+);
+
+CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON public.sessions USING btree (expiry);
+
+--
+-- Name: user_sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE IF NOT EXISTS public.user_sessions (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    token text NOT NULL,
+    ip character varying(255) NOT NULL,
+    user_agent text NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    CONSTRAINT user_sessions_pkey PRIMARY KEY (id),
+    CONSTRAINT user_sessions_token_key UNIQUE (token),
+    CONSTRAINT user_sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+--
+-- Name: revoked_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE IF NOT EXISTS public.revoked_tokens (
+    jti character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+);
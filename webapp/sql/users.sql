@@ -65,7 +65,12 @@ CREATE TABLE public.users ( id integer NOT NULL,
                                                             last_name character varying(255),
                                                                                 email character varying(255),
                                                                                                 password character varying(60),
-                                                                                                                   is_admin integer, created_at timestamp without time zone,
+                                                                                                                   is_admin integer, totp_secret character varying(64),
+                                                                                                                                     totp_enabled boolean DEFAULT false NOT NULL,
+                                                                                                                                     totp_last_step bigint,
+                                                                                                                                     password_changed_at timestamp without time zone,
+                                                                                                                                     email_verified_at timestamp without time zone,
+                                                                                                                                     created_at timestamp without time zone,
                                                                                                                                                                        updated_at timestamp without time zone);
 
 --
@@ -76,6 +81,90 @@ ALTER TABLE public.users
 ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY
     ( SEQUENCE NAME public.users_id_seq START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1);
 
+--
+-- Name: user_recovery_codes; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.user_recovery_codes ( id integer NOT NULL,
+                                                     user_id integer NOT NULL,
+                                                                     code_hash character varying(64) NOT NULL,
+                                                                                                     used_at timestamp without time zone,
+                                                                                                                                    created_at timestamp without time zone);
+
+--
+-- Name: user_recovery_codes_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.user_recovery_codes
+ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY
+    ( SEQUENCE NAME public.user_recovery_codes_id_seq START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1);
+
+--
+-- Name: login_attempts; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.login_attempts ( key character varying(255) NOT NULL,
+                                                                failures integer DEFAULT 0 NOT NULL,
+                                                                                           last_failure timestamp without time zone NOT NULL,
+                                                                                                                                    locked_until timestamp without time zone NOT NULL);
+
+--
+-- Name: api_keys; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.api_keys ( id integer NOT NULL,
+                                          user_id integer NOT NULL,
+                                                          name character varying(255) NOT NULL,
+                                                                                      prefix character varying(32) NOT NULL,
+                                                                                                                   key_hash character varying(64) NOT NULL,
+                                                                                                                                                  scopes character varying(255) NOT NULL,
+                                                                                                                                                                                last_used_at timestamp without time zone,
+                                                                                                                                                                                                                       revoked_at timestamp without time zone,
+                                                                                                                                                                                                                                                            created_at timestamp without time zone);
+
+--
+-- Name: api_keys_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.api_keys
+ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY
+    ( SEQUENCE NAME public.api_keys_id_seq START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1);
+
+--
+-- Name: sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.sessions ( token text NOT NULL,
+                                          data bytea NOT NULL,
+                                                     expiry timestamp with time zone NOT NULL);
+
+--
+-- Name: user_sessions; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.user_sessions ( id integer NOT NULL,
+                                               user_id integer NOT NULL,
+                                                               token text NOT NULL,
+                                                                          ip character varying(255) NOT NULL,
+                                                                                                    user_agent text NOT NULL,
+                                                                                                                    created_at timestamp without time zone NOT NULL,
+                                                                                                                                                           expires_at timestamp without time zone NOT NULL);
+
+--
+-- Name: user_sessions_id_seq; Type: SEQUENCE; Schema: public; Owner: -
+--
+
+ALTER TABLE public.user_sessions
+ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY
+    ( SEQUENCE NAME public.user_sessions_id_seq START WITH 1 INCREMENT BY 1 NO MINVALUE NO MAXVALUE CACHE 1);
+
+--
+-- Name: revoked_tokens; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.revoked_tokens ( jti character varying(64) NOT NULL,
+                                                               expires_at timestamp without time zone NOT NULL);
+
 --
 -- Data for Name: user_images; Type: TABLE DATA; Schema: public; Owner: -
 --
@@ -85,10 +174,10 @@ FROM stdin;
 \. --
 -- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: -
 --
- COPY public.users (id, first_name, last_name, email, password, is_admin, created_at, updated_at)
+ COPY public.users (id, first_name, last_name, email, password, is_admin, created_at, updated_at, email_verified_at)
 FROM stdin;
 
-1 Admin User admin@example.com $2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK 1 2022-08-19 00:00:00 2022-08-19 00:00:00 \. --
+1 Admin User admin@example.com $2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK 1 2022-08-19 00:00:00 2022-08-19 00:00:00 2022-08-19 00:00:00 \. --
 -- Name: user_images_id_seq; Type: SEQUENCE SET; Schema: public; Owner: -
 --
 
@@ -112,6 +201,60 @@ ALTER TABLE ONLY public.user_images ADD CONSTRAINT user_images_pkey PRIMARY KEY
 
 ALTER TABLE ONLY public.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
+--
+-- Name: user_recovery_codes user_recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_recovery_codes ADD CONSTRAINT user_recovery_codes_pkey PRIMARY KEY (id);
+
+--
+-- Name: login_attempts login_attempts_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.login_attempts ADD CONSTRAINT login_attempts_pkey PRIMARY KEY (key);
+
+--
+-- Name: api_keys api_keys_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_keys ADD CONSTRAINT api_keys_pkey PRIMARY KEY (id);
+
+--
+-- Name: api_keys api_keys_prefix_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_keys ADD CONSTRAINT api_keys_prefix_key UNIQUE (prefix);
+
+--
+-- Name: sessions sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.sessions ADD CONSTRAINT sessions_pkey PRIMARY KEY (token);
+
+--
+-- Name: sessions_expiry_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX sessions_expiry_idx ON public.sessions USING btree (expiry);
+
+--
+-- Name: user_sessions user_sessions_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_sessions ADD CONSTRAINT user_sessions_pkey PRIMARY KEY (id);
+
+--
+-- Name: user_sessions user_sessions_token_key; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_sessions ADD CONSTRAINT user_sessions_token_key UNIQUE (token);
+
+--
+-- Name: revoked_tokens revoked_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.revoked_tokens ADD CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti);
+
 --
 -- Name: user_images user_images_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -121,6 +264,33 @@ FOREIGN KEY (user_id) REFERENCES public.users(id) ON
 UPDATE CASCADE ON
 DELETE CASCADE;
 
+--
+-- Name: user_recovery_codes user_recovery_codes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_recovery_codes ADD CONSTRAINT user_recovery_codes_user_id_fkey
+FOREIGN KEY (user_id) REFERENCES public.users(id) ON
+UPDATE CASCADE ON
+DELETE CASCADE;
+
+--
+-- Name: api_keys api_keys_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.api_keys ADD CONSTRAINT api_keys_user_id_fkey
+FOREIGN KEY (user_id) REFERENCES public.users(id) ON
+UPDATE CASCADE ON
+DELETE CASCADE;
+
+--
+-- Name: user_sessions user_sessions_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.user_sessions ADD CONSTRAINT user_sessions_user_id_fkey
+FOREIGN KEY (user_id) REFERENCES public.users(id) ON
+UPDATE CASCADE ON
+DELETE CASCADE;
+
 --
 -- PostgreSQL database dump complete
 --